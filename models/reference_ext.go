@@ -6,13 +6,11 @@ import (
 )
 
 func (r *Reference) MarshalJSON() ([]byte, error) {
-	m := map[string]string{
-		"reference": r.Reference,
-	}
-	if r.Display != "" {
-		m["display"] = r.Display
-	}
-	return json.Marshal(m)
+	// A struct (rather than a map) keeps the properties in the order FHIR defines them
+	return json.Marshal(struct {
+		Reference string `json:"reference"`
+		Display   string `json:"display,omitempty"`
+	}{r.Reference, r.Display})
 }
 
 type reference Reference
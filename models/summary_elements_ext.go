@@ -0,0 +1,250 @@
+package models
+
+// SummaryElements returns the top-level elements of the given resource type that the DSTU2 StructureDefinitions mark
+// as summary elements (those returned when _summary=true), or nil if the resource type is unknown.  The id, meta,
+// and implicitRules elements, which are summary elements of every resource, are not included.  Choice elements are
+// listed by the names of each of their types (e.g., deceasedBoolean and deceasedDateTime).
+func SummaryElements(resourceType string) []string {
+	return summaryElements[resourceType]
+}
+
+var summaryElements = map[string][]string{
+	"Account": {
+		"identifier", "name", "type", "status", "activePeriod", "currency", "balance", "coveragePeriod", "subject",
+		"owner", "description",
+	},
+	"AllergyIntolerance": {
+		"identifier", "onset", "recordedDate", "recorder", "patient", "reporter", "substance", "status", "criticality",
+		"type", "category", "lastOccurence",
+	},
+	"Appointment":         {"identifier", "status", "type", "reason", "start", "end", "participant"},
+	"AppointmentResponse": {"identifier", "appointment", "participantType", "actor", "participantStatus"},
+	"AuditEvent":          {"event", "participant", "source", "object"},
+	"Basic":               {"identifier", "code", "subject", "author", "created"},
+	"Binary":              {"contentType"},
+	"BodySite":            {"patient", "identifier", "code", "description"},
+	"Bundle":              {"type", "total", "link", "entry", "signature"},
+	"CarePlan":            {"identifier", "subject", "status", "context", "period", "author", "modified", "addresses"},
+	"Claim": {
+		"type", "identifier", "ruleset", "originalRuleset", "created", "target", "provider", "organization", "use",
+		"priority", "fundsReserve", "enterer", "facility", "prescription", "originalPrescription", "payee", "referral",
+		"diagnosis", "condition", "patient", "coverage", "exception", "school", "accident", "accidentType",
+		"interventionException", "item", "additionalMaterials", "missingTeeth",
+	},
+	"ClaimResponse": {
+		"identifier", "request", "ruleset", "originalRuleset", "created", "organization", "requestProvider",
+		"requestOrganization", "outcome", "disposition", "payeeType", "item", "addItem", "error", "totalCost",
+		"unallocDeductable", "totalBenefit", "paymentAdjustment", "paymentAdjustmentReason", "paymentDate",
+		"paymentAmount", "paymentRef", "reserved", "form", "note", "coverage",
+	},
+	"ClinicalImpression":   {"patient", "assessor", "status", "date", "description", "problem"},
+	"Communication":        {"status"},
+	"CommunicationRequest": {"status"},
+	"Composition": {
+		"identifier", "date", "type", "class", "title", "status", "confidentiality", "subject", "author", "custodian",
+		"event", "encounter",
+	},
+	"ConceptMap": {
+		"url", "identifier", "version", "name", "status", "experimental", "publisher", "contact", "date", "description",
+		"useContext", "sourceUri", "sourceReference", "targetUri", "targetReference",
+	},
+	"Condition": {
+		"identifier", "patient", "encounter", "asserter", "dateRecorded", "code", "category", "clinicalStatus",
+		"verificationStatus", "severity", "onsetDateTime", "onsetAge", "onsetPeriod", "onsetRange", "onsetString",
+		"abatementDateTime", "abatementAge", "abatementBoolean", "abatementPeriod", "abatementRange", "abatementString",
+		"stage", "evidence", "bodySite",
+	},
+	"Conformance": {
+		"url", "version", "name", "status", "experimental", "publisher", "contact", "date", "description", "kind",
+		"software", "implementation", "fhirVersion", "acceptUnknown", "format", "profile", "rest", "messaging",
+		"document",
+	},
+	"Contract": {"identifier", "issued", "applies", "subject", "type", "subType"},
+	"Coverage": {
+		"issuer", "bin", "period", "type", "subscriberId", "identifier", "group", "plan", "subPlan", "dependent",
+		"sequence", "network",
+	},
+	"DataElement": {
+		"url", "identifier", "version", "name", "status", "experimental", "publisher", "contact", "date", "useContext",
+		"stringency", "element",
+	},
+	"DetectedIssue": {"patient", "category", "severity", "implicated", "date", "author", "identifier"},
+	"Device":        {"type", "status"},
+	"DeviceComponent": {
+		"type", "identifier", "lastSystemChange", "source", "parent", "operationalStatus", "parameterGroup",
+		"measurementPrinciple", "productionSpecification", "languageCode",
+	},
+	"DeviceMetric": {
+		"type", "identifier", "unit", "source", "parent", "operationalStatus", "color", "category", "measurementPeriod",
+		"calibration",
+	},
+	"DeviceUseRequest": {
+		"bodySiteCodeableConcept", "bodySiteReference", "status", "device", "encounter", "identifier", "indication",
+		"notes", "prnReason", "orderedOn", "recordedOn", "subject", "timingTiming", "timingPeriod", "timingDateTime",
+		"priority",
+	},
+	"DeviceUseStatement": {"device", "subject"},
+	"DiagnosticOrder":    {"subject", "orderer", "identifier", "encounter", "status", "priority"},
+	"DiagnosticReport": {
+		"identifier", "status", "category", "code", "subject", "encounter", "effectiveDateTime", "effectivePeriod",
+		"issued", "performer", "image",
+	},
+	"DocumentManifest": {
+		"masterIdentifier", "identifier", "subject", "recipient", "type", "author", "created", "source", "status",
+		"description", "content",
+	},
+	"DocumentReference": {
+		"masterIdentifier", "identifier", "subject", "type", "class", "author", "custodian", "authenticator", "created",
+		"indexed", "status", "docStatus", "relatesTo", "description", "securityLabel", "content", "context",
+	},
+	"EligibilityRequest": {"identifier", "ruleset", "originalRuleset", "created", "target", "provider", "organization"},
+	"EligibilityResponse": {
+		"identifier", "request", "outcome", "disposition", "ruleset", "originalRuleset", "created", "organization",
+		"requestProvider", "requestOrganization",
+	},
+	"Encounter": {
+		"identifier", "status", "class", "type", "patient", "episodeOfCare", "participant", "appointment", "reason",
+	},
+	"EnrollmentRequest": {
+		"identifier", "ruleset", "originalRuleset", "created", "target", "provider", "organization", "subject",
+		"coverage", "relationship",
+	},
+	"EnrollmentResponse": {
+		"identifier", "request", "outcome", "disposition", "ruleset", "originalRuleset", "created", "organization",
+		"requestProvider", "requestOrganization",
+	},
+	"EpisodeOfCare": {"identifier", "status", "type", "condition", "patient", "managingOrganization", "period"},
+	"ExplanationOfBenefit": {
+		"identifier", "request", "outcome", "disposition", "ruleset", "originalRuleset", "created", "organization",
+		"requestProvider", "requestOrganization",
+	},
+	"FamilyMemberHistory": {
+		"identifier", "patient", "date", "status", "name", "relationship", "gender", "ageAge", "ageRange", "ageString",
+		"deceasedBoolean", "deceasedAge", "deceasedRange", "deceasedDate", "deceasedString",
+	},
+	"Flag": {"identifier", "category", "status", "period", "subject", "encounter", "author", "code"},
+	"Goal": {
+		"identifier", "subject", "startDate", "startCodeableConcept", "targetDate", "targetDuration", "category",
+		"description", "status", "statusDate", "author", "priority", "addresses",
+	},
+	"Group": {"identifier", "type", "actual", "code", "name", "quantity"},
+	"HealthcareService": {
+		"identifier", "providedBy", "serviceCategory", "serviceType", "location", "serviceName", "comment", "photo",
+	},
+	"ImagingObjectSelection": {"uid", "patient", "title", "description", "author", "authoringTime", "study"},
+	"ImagingStudy": {
+		"started", "patient", "uid", "accession", "identifier", "order", "modalityList", "referrer", "availability",
+		"url", "numberOfSeries", "numberOfInstances", "procedure", "interpreter", "description", "series",
+	},
+	"Immunization":               {"status", "wasNotGiven", "reported"},
+	"ImmunizationRecommendation": {"identifier", "patient", "recommendation"},
+	"ImplementationGuide": {
+		"url", "version", "name", "status", "experimental", "publisher", "contact", "date", "useContext", "fhirVersion",
+		"dependency", "package", "global", "page",
+	},
+	"List":     {"identifier", "title", "code", "subject", "source", "status", "date", "mode"},
+	"Location": {"identifier", "status", "name", "description", "mode", "type", "physicalType", "managingOrganization"},
+	"Media": {
+		"type", "subtype", "identifier", "subject", "operator", "view", "deviceName", "height", "width", "frames",
+		"duration",
+	},
+	"Medication":               {"code", "isBrand", "manufacturer"},
+	"MedicationAdministration": {"status", "wasNotGiven"},
+	"MedicationDispense":       {"status"},
+	"MedicationOrder":          {"status"},
+	"MedicationStatement":      {"status", "wasNotTaken"},
+	"MessageHeader": {
+		"timestamp", "event", "response", "source", "destination", "enterer", "author", "receiver", "responsible",
+		"reason", "data",
+	},
+	"NamingSystem":   {"name", "status", "kind", "publisher", "contact", "date", "useContext", "uniqueId"},
+	"NutritionOrder": {"patient", "orderer", "identifier", "encounter", "dateTime", "status"},
+	"Observation": {
+		"status", "code", "subject", "effectiveDateTime", "effectivePeriod", "issued", "performer", "valueQuantity",
+		"valueCodeableConcept", "valueString", "valueRange", "valueRatio", "valueSampledData", "valueAttachment",
+		"valueTime", "valueDateTime", "valuePeriod", "related", "component",
+	},
+	"OperationDefinition": {
+		"url", "version", "name", "status", "kind", "experimental", "publisher", "contact", "date", "idempotent",
+		"code", "base", "system", "type", "instance",
+	},
+	"OperationOutcome": {"issue"},
+	"Order": {
+		"identifier", "date", "subject", "source", "target", "reasonCodeableConcept", "reasonReference", "when",
+		"detail",
+	},
+	"OrderResponse": {"identifier", "request", "date", "who", "orderStatus", "description", "fulfillment"},
+	"Organization":  {"identifier", "active", "type", "name", "partOf"},
+	"Patient": {
+		"identifier", "active", "name", "telecom", "gender", "birthDate", "deceasedBoolean", "deceasedDateTime",
+		"address", "animal", "managingOrganization", "link",
+	},
+	"PaymentNotice": {
+		"identifier", "ruleset", "originalRuleset", "created", "target", "provider", "organization", "request",
+		"response", "paymentStatus",
+	},
+	"PaymentReconciliation": {
+		"identifier", "request", "outcome", "disposition", "ruleset", "originalRuleset", "created", "period",
+		"organization", "requestProvider", "requestOrganization", "detail", "form", "total", "note",
+	},
+	"Person": {
+		"identifier", "name", "telecom", "gender", "birthDate", "address", "managingOrganization", "active",
+	},
+	"Practitioner": {"identifier", "active", "name", "telecom", "address", "gender", "birthDate"},
+	"Procedure": {
+		"identifier", "subject", "status", "code", "notPerformed", "bodySite", "reasonNotPerformed",
+		"reasonCodeableConcept", "reasonReference", "performer", "performedDateTime", "performedPeriod", "encounter",
+		"location", "outcome",
+	},
+	"ProcedureRequest": {
+		"identifier", "subject", "code", "bodySite", "reasonCodeableConcept", "reasonReference", "scheduledDateTime",
+		"scheduledPeriod", "scheduledTiming", "encounter", "performer", "status", "asNeededBoolean",
+		"asNeededCodeableConcept", "orderedOn", "orderer", "priority",
+	},
+	"ProcessRequest": {
+		"action", "identifier", "ruleset", "originalRuleset", "created", "target", "provider", "organization",
+		"request", "response", "nullify", "reference", "item", "include", "exclude", "period",
+	},
+	"ProcessResponse": {
+		"identifier", "request", "outcome", "disposition", "ruleset", "originalRuleset", "created", "organization",
+		"requestProvider", "requestOrganization", "form", "notes", "error",
+	},
+	"Provenance":    {"target", "recorded"},
+	"Questionnaire": {"identifier", "version", "status", "date", "publisher", "telecom", "subjectType"},
+	"QuestionnaireResponse": {
+		"identifier", "questionnaire", "status", "subject", "author", "authored", "source", "encounter",
+	},
+	"ReferralRequest": {
+		"status", "identifier", "date", "type", "priority", "patient", "requester", "recipient", "dateSent", "reason",
+		"serviceRequested", "fulfillmentTime",
+	},
+	"RelatedPerson":  {"identifier", "patient", "relationship", "name", "telecom", "gender", "birthDate", "address"},
+	"RiskAssessment": {"subject", "date", "condition", "encounter", "performer", "identifier", "method"},
+	"Schedule":       {"identifier", "type", "actor", "planningHorizon"},
+	"SearchParameter": {
+		"url", "name", "status", "experimental", "publisher", "contact", "date", "code", "base", "type", "description",
+	},
+	"Slot":     {"type", "schedule", "freeBusyType", "start", "end"},
+	"Specimen": {"identifier", "status", "type", "subject", "accessionIdentifier", "receivedTime"},
+	"StructureDefinition": {
+		"url", "identifier", "version", "name", "display", "status", "experimental", "publisher", "contact", "date",
+		"description", "useContext", "code", "fhirVersion", "kind", "constrainedType", "abstract", "contextType",
+		"context", "base",
+	},
+	"Subscription":   {"criteria", "contact", "reason", "status", "error", "channel", "end", "tag"},
+	"Substance":      {"identifier", "category", "code", "description", "instance", "ingredient"},
+	"SupplyDelivery": {"identifier", "status"},
+	"SupplyRequest": {
+		"patient", "source", "date", "identifier", "status", "kind", "orderedItem", "supplier", "reasonCodeableConcept",
+		"reasonReference", "when",
+	},
+	"TestScript": {
+		"url", "version", "name", "status", "identifier", "experimental", "publisher", "contact", "date", "description",
+		"useContext",
+	},
+	"ValueSet": {
+		"url", "identifier", "version", "name", "status", "experimental", "publisher", "contact", "date", "lockedDate",
+		"description", "useContext", "immutable", "extensible",
+	},
+	"VisionPrescription": {"identifier", "dateWritten", "patient", "prescriber"},
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+
+	check "gopkg.in/check.v1"
+)
+
+type SummaryElementsSuite struct {
+}
+
+var _ = check.Suite(&SummaryElementsSuite{})
+
+func (s *SummaryElementsSuite) TestSummaryElements(c *check.C) {
+	c.Assert(SummaryElements("Patient"), check.DeepEquals, []string{
+		"identifier", "active", "name", "telecom", "gender", "birthDate", "deceasedBoolean", "deceasedDateTime",
+		"address", "animal", "managingOrganization", "link",
+	})
+	c.Assert(SummaryElements("Foo"), check.IsNil)
+}
+
+func (s *SummaryElementsSuite) TestSummaryElementsAreResourceElements(c *check.C) {
+	for resourceType, elements := range summaryElements {
+		names := jsonNames(reflect.TypeOf(StructForResourceName(resourceType)))
+		for _, element := range elements {
+			c.Assert(names[element], check.Equals, true, check.Commentf("%s.%s is not an element", resourceType, element))
+		}
+	}
+}
+
+// jsonNames returns the JSON names of the fields of the struct type, including those of embedded structs
+func jsonNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			for name := range jsonNames(f.Type) {
+				names[name] = true
+			}
+			continue
+		}
+		names[strings.Split(f.Tag.Get("json"), ",")[0]] = true
+	}
+	return names
+}
@@ -0,0 +1,100 @@
+package search
+
+import (
+	"github.com/intervention-engine/fhir/models"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// PatientCompartment maps each resource type in the patient compartment to the reference search parameters that link
+// its resources to a patient, as defined by the FHIR DSTU2 Patient compartment.  The Patient resource itself is in the
+// compartment of the patient it represents, so its "link" parameter only adds linked patients.
+var PatientCompartment = map[string][]string{
+	"AllergyIntolerance":         {"patient", "recorder", "reporter"},
+	"Appointment":                {"actor"},
+	"AppointmentResponse":        {"actor"},
+	"AuditEvent":                 {"patient"},
+	"Basic":                      {"patient", "author"},
+	"BodySite":                   {"patient"},
+	"CarePlan":                   {"patient", "participant", "performer"},
+	"Claim":                      {"patient"},
+	"ClinicalImpression":         {"patient"},
+	"Communication":              {"subject", "sender", "recipient"},
+	"CommunicationRequest":       {"subject", "sender", "recipient", "requester"},
+	"Composition":                {"subject", "author", "attester"},
+	"Condition":                  {"patient", "asserter"},
+	"DetectedIssue":              {"patient"},
+	"DeviceUseRequest":           {"subject"},
+	"DeviceUseStatement":         {"subject"},
+	"DiagnosticOrder":            {"subject"},
+	"DiagnosticReport":           {"subject"},
+	"DocumentManifest":           {"subject", "author", "recipient"},
+	"DocumentReference":          {"subject", "author"},
+	"Encounter":                  {"patient"},
+	"EnrollmentRequest":          {"subject"},
+	"EpisodeOfCare":              {"patient"},
+	"FamilyMemberHistory":        {"patient"},
+	"Flag":                       {"patient"},
+	"Goal":                       {"patient"},
+	"Group":                      {"member"},
+	"ImagingObjectSelection":     {"patient", "author"},
+	"ImagingStudy":               {"patient"},
+	"Immunization":               {"patient"},
+	"ImmunizationRecommendation": {"patient"},
+	"List":                       {"subject", "source"},
+	"MedicationAdministration":   {"patient"},
+	"MedicationDispense":         {"patient"},
+	"MedicationOrder":            {"patient"},
+	"MedicationStatement":        {"patient", "source"},
+	"NutritionOrder":             {"patient"},
+	"Observation":                {"subject", "performer"},
+	"Order":                      {"subject"},
+	"Patient":                    {"link"},
+	"Person":                     {"patient"},
+	"Procedure":                  {"patient", "performer"},
+	"ProcedureRequest":           {"subject", "orderer"},
+	"Provenance":                 {"target", "patient"},
+	"QuestionnaireResponse":      {"subject", "author"},
+	"ReferralRequest":            {"patient", "requester"},
+	"RelatedPerson":              {"patient"},
+	"RiskAssessment":             {"subject"},
+	"Schedule":                   {"actor"},
+	"Specimen":                   {"subject"},
+	"SupplyDelivery":             {"patient"},
+	"SupplyRequest":              {"patient"},
+	"VisionPrescription":         {"patient"},
+}
+
+// CreatePatientCompartmentQuery takes a FHIR-based Query and returns a pointer to the corresponding mgo.Query, further
+// limited to the resources in the patient compartment of the referenced patient(s).  The patient reference may be a
+// LocalReference (for a single patient) or a ChainedQueryReference (for all patients matching a Patient query).  Like
+// CreateQueryWithoutOptions, any options passed in through the query are ignored.
+func (m *MongoSearcher) CreatePatientCompartmentQuery(query Query, patient interface{}) *mgo.Query {
+	var compartment []bson.M
+	if query.Resource == "Patient" {
+		// A patient is in its own compartment
+		switch ref := patient.(type) {
+		case LocalReference:
+			compartment = append(compartment, bson.M{"_id": ref.ID})
+		case ChainedQueryReference:
+			compartment = append(compartment, m.createQueryObject(ref.ChainedQuery))
+		}
+	}
+	for _, name := range PatientCompartment[query.Resource] {
+		info, ok := SearchParameterDictionary[query.Resource][name]
+		if !ok || info.Type != "reference" {
+			continue
+		}
+		compartment = append(compartment, m.createReferenceQueryObject(&ReferenceParam{info, patient}))
+	}
+
+	c := m.db.C(models.PluralizeLowerResourceName(query.Resource))
+	q := m.createQueryObject(query)
+	switch len(compartment) {
+	case 0:
+		return c.Find(bson.M{"_id": bson.M{"$in": []string{}}})
+	case 1:
+		return c.Find(bson.M{"$and": []bson.M{compartment[0], q}})
+	}
+	return c.Find(bson.M{"$and": []bson.M{{"$or": compartment}, q}})
+}
@@ -0,0 +1,26 @@
+package search
+
+// compositeSearchParams are the DSTU2 composite search parameters, which the generated SearchParameterDictionary
+// doesn't contain.  Each lists the search parameters matching its components, in order.
+var compositeSearchParams = []SearchParamInfo{
+	{Resource: "Group", Name: "characteristic-value", Composites: []string{"characteristic", "value"}},
+	{Resource: "Observation", Name: "code-value-concept", Composites: []string{"code", "value-concept"}},
+	{Resource: "Observation", Name: "code-value-date", Composites: []string{"code", "value-date"}},
+	{Resource: "Observation", Name: "code-value-quantity", Composites: []string{"code", "value-quantity"}},
+	{Resource: "Observation", Name: "code-value-string", Composites: []string{"code", "value-string"}},
+	{Resource: "Observation", Name: "component-code-component-value-concept", Composites: []string{"component-code", "component-value-concept"}},
+	{Resource: "Observation", Name: "component-code-component-value-quantity", Composites: []string{"component-code", "component-value-quantity"}},
+	{Resource: "Observation", Name: "component-code-component-value-string", Composites: []string{"component-code", "component-value-string"}},
+	// The shorter names later versions of FHIR use for the component composites are accepted too
+	{Resource: "Observation", Name: "component-code-value-concept", Composites: []string{"component-code", "component-value-concept"}},
+	{Resource: "Observation", Name: "component-code-value-quantity", Composites: []string{"component-code", "component-value-quantity"}},
+	{Resource: "Observation", Name: "component-code-value-string", Composites: []string{"component-code", "component-value-string"}},
+	{Resource: "Observation", Name: "related", Composites: []string{"related-target", "related-type"}},
+}
+
+func init() {
+	for _, info := range compositeSearchParams {
+		info.Type = "composite"
+		SearchParameterDictionary[info.Resource][info.Name] = info
+	}
+}
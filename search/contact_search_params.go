@@ -0,0 +1,78 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// contactSearchParams are the contact (email and phone) search parameters, which the generated
+// SearchParameterDictionary doesn't contain, and the telecom parameter on Organization, which DSTU2 doesn't define.
+var contactSearchParams = []SearchParamInfo{
+	{Resource: "Organization", Name: "email"},
+	{Resource: "Organization", Name: "phone"},
+	{Resource: "Organization", Name: "telecom"},
+	{Resource: "Patient", Name: "email"},
+	{Resource: "Patient", Name: "phone"},
+	{Resource: "Person", Name: "email"},
+	{Resource: "Person", Name: "phone"},
+	{Resource: "Practitioner", Name: "email"},
+	{Resource: "Practitioner", Name: "phone"},
+	{Resource: "RelatedPerson", Name: "email"},
+	{Resource: "RelatedPerson", Name: "phone"},
+}
+
+// contactPointSystems are the ContactPoint systems the email and phone parameters are restricted to
+var contactPointSystems = map[string]string{"email": "email", "phone": "phone"}
+
+func init() {
+	for _, info := range contactSearchParams {
+		info.Type = "token"
+		info.Paths = []SearchParamPath{{Path: "[]telecom", Type: "ContactPoint"}}
+		SearchParameterDictionary[info.Resource][info.Name] = info
+	}
+}
+
+// createContactPointCriteria matches a ContactPoint's system and value.  The email and phone parameters only match
+// contact points with that system, regardless of the system in the search.  Phone numbers (and numbers in other
+// telephone systems) are compared by their digits, so formatting such as "(555) 123-4567" doesn't matter.
+func createContactPointCriteria(t *TokenParam) bson.M {
+	criteria := bson.M{}
+	system, fixed := contactPointSystems[t.Name]
+	if !fixed && !t.AnySystem {
+		system = t.System
+	}
+	if system != "" {
+		criteria["system"] = ci(system)
+	}
+
+	criteria["value"] = ci(t.Code)
+	switch strings.ToLower(system) {
+	case "phone", "fax", "pager", "sms", "":
+		if pattern, ok := phoneNumberPattern(t.Code); ok {
+			criteria["value"] = pattern
+		}
+	}
+	return criteria
+}
+
+// phoneNumberPattern returns a regular expression matching the phone number however it is formatted, if the value
+// looks like a phone number (i.e., it contains digits and formatting characters only).
+func phoneNumberPattern(value string) (bson.RegEx, bool) {
+	var digits []string
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = append(digits, string(r))
+		case strings.ContainsRune(" ()-.+/", r):
+			// formatting
+		default:
+			return bson.RegEx{}, false
+		}
+	}
+	if len(digits) == 0 {
+		return bson.RegEx{}, false
+	}
+	return bson.RegEx{Pattern: fmt.Sprintf(`^\D*%s\D*$`, strings.Join(digits, `\D*`))}, true
+}
@@ -2,6 +2,7 @@ package search
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"regexp"
 	"strings"
@@ -81,6 +82,18 @@ func (m *MongoSearcher) createQuery(query Query, withOptions bool) *mgo.Query {
 			mgoQuery = mgoQuery.Skip(o.Offset)
 		}
 		mgoQuery = mgoQuery.Limit(o.Count)
+		projection := bson.M{}
+		if elements, exclude := o.SelectedElements(query.Resource); len(elements) > 0 {
+			projection = createProjection(elements, exclude)
+		}
+		if len(o.Sort) == 0 && usesFullTextSearch(query) {
+			// Without a _sort, full-text search results are ranked by relevance, which Mongo requires projecting
+			projection[textScoreField] = bson.M{"$meta": "textScore"}
+			mgoQuery = mgoQuery.Sort("$textScore:" + textScoreField)
+		}
+		if len(projection) > 0 {
+			mgoQuery = mgoQuery.Select(projection)
+		}
 	}
 	return mgoQuery
 }
@@ -114,6 +127,9 @@ func (m *MongoSearcher) CreatePipeline(query Query) *mgo.Pipe {
 			sortBSOND = append(sortBSOND, bson.DocElem{Name: field, Value: order})
 		}
 		p = append(p, bson.M{"$sort": sortBSOND})
+	} else if usesFullTextSearch(query) {
+		// Without a _sort, full-text search results are ranked by relevance
+		p = append(p, bson.M{"$sort": bson.M{textScoreField: bson.M{"$meta": "textScore"}}})
 	}
 
 	// support for _offset
@@ -126,6 +142,10 @@ func (m *MongoSearcher) CreatePipeline(query Query) *mgo.Pipe {
 	// support for _include
 	if len(o.Include) > 0 {
 		for _, incl := range o.Include {
+			// Includes from other resources only apply to included resources (see IncludeOption.Iterate)
+			if incl.Resource != query.Resource {
+				continue
+			}
 			for _, inclPath := range incl.Parameter.Paths {
 				if inclPath.Type != "Reference" {
 					continue
@@ -194,9 +214,41 @@ func (m *MongoSearcher) CreatePipeline(query Query) *mgo.Pipe {
 		}
 	}
 
+	// support for _summary and _elements
+	if elements, exclude := o.SelectedElements(query.Resource); len(elements) > 0 {
+		projection := createProjection(elements, exclude)
+		if !exclude {
+			// The included resources must be kept too
+			for _, stage := range p {
+				if lookup, ok := stage["$lookup"].(bson.M); ok {
+					projection[lookup["as"].(string)] = 1
+				}
+			}
+		}
+		p = append(p, bson.M{"$project": projection})
+	}
+
 	return c.Pipe(p)
 }
 
+// createProjection returns the Mongo projection including (or, if exclude is true, excluding) the given top-level
+// elements, as returned by QueryOptions.SelectedElements.
+func createProjection(elements []string, exclude bool) bson.M {
+	value := 1
+	if exclude {
+		value = 0
+	}
+	projection := bson.M{}
+	for _, element := range elements {
+		if element == "id" {
+			// The id is stored as the document's _id, which is always returned
+			continue
+		}
+		projection[element] = value
+	}
+	return projection
+}
+
 func (m *MongoSearcher) createQueryObject(query Query) bson.M {
 	result := bson.M{}
 	for _, p := range m.createParamObjects(query.Params()) {
@@ -207,9 +259,21 @@ func (m *MongoSearcher) createQueryObject(query Query) bson.M {
 
 func (m *MongoSearcher) createParamObjects(params []SearchParam) []bson.M {
 	results := make([]bson.M, len(params))
+	fullTextSearched := false
 	for i, p := range params {
 		panicOnUnsupportedFeatures(p)
 		switch p := p.(type) {
+		case *FullTextParam:
+			// Mongo supports only one text search per query
+			if fullTextSearched {
+				panic(createUnsupportedSearchError("MSG_PARAM_NO_REPEAT", fmt.Sprintf("Parameter \"%s\" is not allowed to repeat", p.Name)))
+			}
+			fullTextSearched = true
+			results[i] = m.createFullTextQueryObject(p)
+		case *ListMembershipParam:
+			results[i] = m.createListMembershipQueryObject(p)
+		case *MissingParam:
+			results[i] = m.createMissingQueryObject(p)
 		case *CompositeParam:
 			results[i] = m.createCompositeQueryObject(p)
 		case *DateParam:
@@ -220,6 +284,8 @@ func (m *MongoSearcher) createParamObjects(params []SearchParam) []bson.M {
 			results[i] = m.createQuantityQueryObject(p)
 		case *ReferenceParam:
 			results[i] = m.createReferenceQueryObject(p)
+		case *ReverseChainedParam:
+			results[i] = m.createReverseChainedQueryObject(p)
 		case *StringParam:
 			results[i] = m.createStringQueryObject(p)
 		case *TokenParam:
@@ -246,25 +312,161 @@ func (m *MongoSearcher) createParamObjects(params []SearchParam) []bson.M {
 }
 
 func panicOnUnsupportedFeatures(p SearchParam) {
-	// No prefixes are supported except EQ (the default) and date prefixes
-	_, isDate := p.(*DateParam)
-	prefix := p.getInfo().Prefix
-	if prefix != "" && prefix != EQ && !isDate {
-		panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", p.getInfo().Name)))
+	// No prefixes are supported except EQ (the default) and date, number, and quantity prefixes
+	switch p.(type) {
+	case *DateParam, *NumberParam, *QuantityParam:
+	default:
+		if prefix := p.getInfo().Prefix; prefix != "" && prefix != EQ {
+			panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", p.getInfo().Name)))
+		}
 	}
 
-	// No modifiers are supported except for resource types in reference parameters
-	_, isRef := p.(*ReferenceParam)
+	// No modifiers are supported except for missing, exact and contains in string parameters, and resource types in
+	// reference parameters
 	modifier := p.getInfo().Modifier
-	if modifier != "" {
-		if _, ok := SearchParameterDictionary[modifier]; !isRef || !ok {
-			panic(createUnsupportedSearchError("MSG_PARAM_MODIFIER_INVALID", fmt.Sprintf("Parameter \"%s\" modifier is invalid", p.getInfo().Name)))
+	supported := modifier == ""
+	switch p.(type) {
+	case *MissingParam:
+		supported = true
+	case *StringParam:
+		supported = supported || modifier == ExactModifier || modifier == ContainsModifier
+	case *TokenParam:
+		switch modifier {
+		case TextModifier, NotModifier, InModifier, NotInModifier, AboveModifier, BelowModifier:
+			supported = true
+		}
+	case *ReferenceParam:
+		_, isResource := SearchParameterDictionary[modifier]
+		supported = supported || isResource
+	}
+	if !supported {
+		panic(createUnsupportedSearchError("MSG_PARAM_MODIFIER_INVALID", fmt.Sprintf("Parameter \"%s\" modifier is invalid", p.getInfo().Name)))
+	}
+}
+
+// textScoreField is the field in which the relevance of full-text search results is projected
+const textScoreField = "_textScore"
+
+// usesFullTextSearch indicates whether the query has a _text or _content parameter
+func usesFullTextSearch(query Query) bool {
+	for _, p := range query.Params() {
+		if _, ok := p.(*FullTextParam); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// createFullTextQueryObject searches the collection's text index.  Since a collection has only one text index, which
+// may cover more than the narrative, _text searches also require the narrative to contain each of the quoted phrases
+// and at least one of the other words.
+func (m *MongoSearcher) createFullTextQueryObject(f *FullTextParam) bson.M {
+	criteria := bson.M{"$text": bson.M{"$search": f.Text}}
+	if f.Name != TextParam {
+		return criteria
+	}
+
+	var and []bson.M
+	var words []bson.M
+	for _, term := range regexp.MustCompile(`"([^"]*)"|([^\s,]+)`).FindAllStringSubmatch(f.Text, -1) {
+		switch {
+		case strings.TrimSpace(term[1]) != "":
+			and = append(and, bson.M{"text.div": cic(strings.TrimSpace(term[1]))})
+		case term[2] != "" && !strings.HasPrefix(term[2], "-"):
+			words = append(words, bson.M{"text.div": cic(term[2])})
 		}
 	}
+	if len(words) == 1 {
+		and = append(and, words[0])
+	} else if len(words) > 1 {
+		and = append(and, bson.M{"$or": words})
+	}
+	if len(and) > 0 {
+		criteria["$and"] = and
+	}
+	return criteria
+}
+
+// createMissingQueryObject matches resources that have no value for any of the parameter's paths or, if Missing is
+// false, resources that have a value for at least one of them.
+func (m *MongoSearcher) createMissingQueryObject(p *MissingParam) bson.M {
+	if len(p.Paths) == 0 {
+		panic(createUnsupportedSearchError("MSG_PARAM_MODIFIER_INVALID", fmt.Sprintf("Parameter \"%s\" modifier is invalid", p.Name)))
+	}
+	criteria := make([]bson.M, len(p.Paths))
+	for i, path := range p.Paths {
+		criteria[i] = bson.M{convertSearchPathToMongoField(path.Path): bson.M{"$exists": !p.Missing}}
+	}
+	if len(criteria) == 1 {
+		return criteria[0]
+	}
+	if p.Missing {
+		return bson.M{"$and": criteria}
+	}
+	return bson.M{"$or": criteria}
 }
 
+// createCompositeQueryObject combines the queries for each of the composite's components.  If the components are
+// all within the same array (e.g., Observation.component), the components must match the same element of the array,
+// so they are searched relative to that element using an $elemMatch.
 func (m *MongoSearcher) createCompositeQueryObject(c *CompositeParam) bson.M {
-	panic(createUnsupportedSearchError("MSG_PARAM_UNKNOWN", fmt.Sprintf("Parameter \"%s\" not understood", c.Name)))
+	components := make([]SearchParamInfo, len(c.Composites))
+	var paths []string
+	for i, name := range c.Composites {
+		info, ok := SearchParameterDictionary[c.Resource][name]
+		if !ok {
+			panic(createInternalServerError("MSG_PARAM_UNKNOWN", fmt.Sprintf("Parameter \"%s\" not understood", c.Name)))
+		}
+		components[i] = info
+		for _, p := range info.Paths {
+			paths = append(paths, p.Path)
+		}
+	}
+	arrayPath := commonArrayPath(paths)
+
+	result := bson.M{}
+	for i, info := range components {
+		if arrayPath != "" {
+			relativePaths := make([]SearchParamPath, len(info.Paths))
+			for j, p := range info.Paths {
+				relativePaths[j] = SearchParamPath{Path: strings.TrimPrefix(p.Path, arrayPath+"."), Type: p.Type}
+			}
+			info.Paths = relativePaths
+		}
+		component := info.CreateSearchParam(c.CompositeValues[i])
+		merge(result, m.createParamObjects([]SearchParam{component})[0])
+	}
+
+	if arrayPath == "" {
+		return result
+	}
+	return bson.M{convertSearchPathToMongoField(arrayPath): bson.M{"$elemMatch": result}}
+}
+
+// commonArrayPath returns the longest path to an array (e.g., "[]component") that all of the paths are within, or an
+// empty string if they aren't all within the same array.
+func commonArrayPath(paths []string) string {
+	var common []string
+	for i, path := range paths {
+		// The last element is the one being searched, so only the elements containing it are considered
+		parts := strings.Split(path, ".")
+		parts = parts[:len(parts)-1]
+		if i == 0 {
+			common = parts
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(parts) && common[n] == parts[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	for n := len(common); n > 0; n-- {
+		if strings.HasPrefix(common[n-1], "[]") {
+			return strings.Join(common[:n], ".")
+		}
+	}
+	return ""
 }
 
 func (m *MongoSearcher) createDateQueryObject(d *DateParam) bson.M {
@@ -428,26 +630,48 @@ func periodSelector(d *DateParam) bson.M {
 
 func (m *MongoSearcher) createNumberQueryObject(n *NumberParam) bson.M {
 	single := func(p SearchParamPath) bson.M {
-		l, _ := n.Number.RangeLowIncl().Float64()
-		h, _ := n.Number.RangeHighExcl().Float64()
-		return buildBSON(p.Path, bson.M{
-			"$gte": l,
-			"$lt":  h,
-		})
+		return buildBSON(p.Path, numberSelector(n.Name, n.Prefix, n.Number))
 	}
 
 	return orPaths(single, n.Paths)
 }
 
+// numberSelector returns the criteria matching values that compare to the number as the prefix indicates.  Like
+// equality, the prefixes are interpreted using the range implied by the number's precision (e.g., 100 means
+// [99.5, 100.5)), so ge100 matches 99.5 and le100 matches 100.4.  Approximately equal (ap) matches values within 10%
+// of the number, or within its implied range if that is wider.
+func numberSelector(name string, prefix Prefix, n *Number) bson.M {
+	value, _ := n.Value.Float64()
+	low, _ := n.RangeLowIncl().Float64()
+	high, _ := n.RangeHighExcl().Float64()
+	switch prefix {
+	case EQ:
+		return bson.M{"$gte": low, "$lt": high}
+	case NE:
+		return bson.M{"$not": bson.M{"$gte": low, "$lt": high}, "$exists": true}
+	case GT:
+		return bson.M{"$gt": value}
+	case LT:
+		return bson.M{"$lt": value}
+	case GE:
+		return bson.M{"$gte": low}
+	case LE:
+		return bson.M{"$lt": high}
+	case SA:
+		return bson.M{"$gte": high}
+	case EB:
+		return bson.M{"$lt": low}
+	case AP:
+		delta := math.Abs(value) * 0.1
+		return bson.M{"$gte": math.Min(low, value-delta), "$lte": math.Max(high, value+delta)}
+	}
+	panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", name)))
+}
+
 func (m *MongoSearcher) createQuantityQueryObject(q *QuantityParam) bson.M {
 	single := func(p SearchParamPath) bson.M {
-		l, _ := q.Number.RangeLowIncl().Float64()
-		h, _ := q.Number.RangeHighExcl().Float64()
 		criteria := bson.M{
-			"value": bson.M{
-				"$gte": l,
-				"$lt":  h,
-			},
+			"value": numberSelector(q.Name, q.Prefix, q.Number),
 		}
 		if q.System == "" {
 			criteria["$or"] = []bson.M{
@@ -503,6 +727,47 @@ func (m *MongoSearcher) createReferenceQueryObject(r *ReferenceParam) bson.M {
 	return orPaths(single, r.Paths)
 }
 
+func (m *MongoSearcher) createReverseChainedQueryObject(r *ReverseChainedParam) bson.M {
+	// As with chained references, MongoDB does not support cross-collection searches, so we must break this into two:
+	// (1) perform search against referencing collection using chained search Query, collecting the IDs it references
+	// (2) use the referenced IDs to build second query
+	c := m.db.C(models.PluralizeLowerResourceName(r.Reference.Resource))
+	criteria := m.createQueryObject(r.ChainedQuery)
+	ids := []string{}
+	for _, p := range r.Reference.Paths {
+		if p.Type != "Reference" {
+			continue
+		}
+		field := convertSearchPathToMongoField(p.Path)
+		q := bson.M{"$and": []bson.M{criteria, {field + ".type": r.Resource}}}
+		var pathIDs []string
+		if err := c.Find(q).Distinct(field+".referenceid", &pathIDs); err != nil {
+			panic(createInternalServerError("", err.Error()))
+		}
+		ids = append(ids, pathIDs...)
+	}
+	return bson.M{"_id": bson.M{"$in": ids}}
+}
+
+func (m *MongoSearcher) createListMembershipQueryObject(l *ListMembershipParam) bson.M {
+	// Like chained references, this is broken into two queries: (1) get the List, and (2) use the IDs of its entries
+	// of the searched resource type to build the second query
+	list := &models.List{}
+	if err := m.db.C(models.PluralizeLowerResourceName("List")).FindId(l.ListID).One(list); err != nil {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", l.Name)))
+	}
+	ids := []string{}
+	for _, entry := range list.Entry {
+		if entry.Deleted != nil && *entry.Deleted {
+			continue
+		}
+		if entry.Item != nil && entry.Item.Type == l.Resource && entry.Item.ReferencedID != "" {
+			ids = append(ids, entry.Item.ReferencedID)
+		}
+	}
+	return bson.M{"_id": bson.M{"$in": ids}}
+}
+
 func (m *MongoSearcher) createInlinedReferenceQueryObject(r *ReferenceParam, p SearchParamPath) bson.M {
 	criteria := bson.M{}
 	switch ref := r.Reference.(type) {
@@ -523,32 +788,44 @@ func (m *MongoSearcher) createInlinedReferenceQueryObject(r *ReferenceParam, p S
 }
 
 func (m *MongoSearcher) createStringQueryObject(s *StringParam) bson.M {
+	// By default, strings match values starting with them (ignoring case), but they may also match exactly or match
+	// values containing them (ignoring case)
+	var value interface{}
+	switch s.Modifier {
+	case ExactModifier:
+		value = s.String
+	case ContainsModifier:
+		value = cic(s.String)
+	default:
+		value = cisw(s.String)
+	}
+
 	single := func(p SearchParamPath) bson.M {
 		switch p.Type {
 		case "HumanName":
 			return buildBSON(p.Path, bson.M{
 				"$or": []bson.M{
-					bson.M{"text": cisw(s.String)},
-					bson.M{"family": cisw(s.String)},
-					bson.M{"given": cisw(s.String)},
+					bson.M{"text": value},
+					bson.M{"family": value},
+					bson.M{"given": value},
 				},
 			})
 		case "Address":
 			return buildBSON(p.Path, bson.M{
 				"$or": []bson.M{
-					bson.M{"text": cisw(s.String)},
-					bson.M{"line": cisw(s.String)},
-					bson.M{"city": cisw(s.String)},
-					bson.M{"state": cisw(s.String)},
-					bson.M{"postalCode": cisw(s.String)},
-					bson.M{"country": cisw(s.String)},
+					bson.M{"text": value},
+					bson.M{"line": value},
+					bson.M{"city": value},
+					bson.M{"state": value},
+					bson.M{"postalCode": value},
+					bson.M{"country": value},
 				},
 			})
 		default:
 			if s.Name == "_id" {
 				return buildBSON(p.Path, s.String)
 			}
-			return buildBSON(p.Path, cisw(s.String))
+			return buildBSON(p.Path, value)
 		}
 	}
 
@@ -556,6 +833,20 @@ func (m *MongoSearcher) createStringQueryObject(s *StringParam) bson.M {
 }
 
 func (m *MongoSearcher) createTokenQueryObject(t *TokenParam) bson.M {
+	switch t.Modifier {
+	case TextModifier:
+		return m.createTokenTextQueryObject(t)
+	case NotModifier, NotInModifier:
+		// Negate the query without the "not"
+		negated := *t
+		negated.Modifier = map[string]string{NotModifier: "", NotInModifier: InModifier}[t.Modifier]
+		return bson.M{"$nor": []bson.M{m.createTokenQueryObject(&negated)}}
+	case InModifier:
+		return m.createTokenCodesQueryObject(t, m.valueSetCodes(t))
+	case AboveModifier, BelowModifier:
+		return m.createTokenCodesQueryObject(t, m.hierarchyCodes(t))
+	}
+
 	single := func(p SearchParamPath) bson.M {
 		criteria := bson.M{}
 		switch p.Type {
@@ -577,10 +868,7 @@ func (m *MongoSearcher) createTokenQueryObject(t *TokenParam) bson.M {
 				criteria["system"] = ci(t.System)
 			}
 		case "ContactPoint":
-			criteria["value"] = ci(t.Code)
-			if !t.AnySystem {
-				criteria["use"] = ci(t.System)
-			}
+			criteria = createContactPointCriteria(t)
 		case "boolean":
 			switch t.Code {
 			case "true":
@@ -605,6 +893,96 @@ func (m *MongoSearcher) createTokenQueryObject(t *TokenParam) bson.M {
 	return orPaths(single, t.Paths)
 }
 
+// createTokenTextQueryObject matches the text associated with codes and identifiers: the text of CodeableConcepts
+// and Identifier types, and the display of Codings.  Like strings, the text matches values starting with it.
+func (m *MongoSearcher) createTokenTextQueryObject(t *TokenParam) bson.M {
+	text := t.Code
+	if !t.AnySystem {
+		text = t.System + "|" + t.Code
+	}
+	single := func(p SearchParamPath) bson.M {
+		switch p.Type {
+		case "CodeableConcept":
+			return buildBSON(p.Path, bson.M{
+				"$or": []bson.M{
+					bson.M{"text": cisw(text)},
+					bson.M{"coding.display": cisw(text)},
+				},
+			})
+		case "Coding":
+			return buildBSON(p.Path, bson.M{"display": cisw(text)})
+		case "Identifier":
+			return buildBSON(p.Path, bson.M{"type.text": cisw(text)})
+		}
+		panic(createUnsupportedSearchError("MSG_PARAM_MODIFIER_INVALID", fmt.Sprintf("Parameter \"%s\" modifier is invalid", t.Name)))
+	}
+
+	return orPaths(single, t.Paths)
+}
+
+// createTokenCodesQueryObject matches any of the codes (e.g., the codes in a value set).  A code without a code
+// matches any code in its system.
+func (m *MongoSearcher) createTokenCodesQueryObject(t *TokenParam, codes []SystemCode) bson.M {
+	// Group the codes by system, so each system is only matched once
+	var systems []string
+	var allCodes []interface{}
+	codesBySystem := make(map[string][]interface{})
+	wholeSystems := make(map[string]bool)
+	for _, code := range codes {
+		if _, seen := codesBySystem[code.System]; !seen && !wholeSystems[code.System] {
+			systems = append(systems, code.System)
+		}
+		if code.Code == "" {
+			wholeSystems[code.System] = true
+			continue
+		}
+		codesBySystem[code.System] = append(codesBySystem[code.System], ci(code.Code))
+		allCodes = append(allCodes, ci(code.Code))
+	}
+
+	single := func(p SearchParamPath) bson.M {
+		switch p.Type {
+		case "Coding", "CodeableConcept":
+		case "code":
+			// Plain codes have no system, so any system's codes match (but whole systems can't be matched)
+			if len(wholeSystems) > 0 {
+				panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", t.Name)))
+			}
+			if len(allCodes) == 0 {
+				allCodes = []interface{}{}
+			}
+			return buildBSON(p.Path, bson.M{"$in": allCodes})
+		default:
+			panic(createUnsupportedSearchError("MSG_PARAM_MODIFIER_INVALID", fmt.Sprintf("Parameter \"%s\" modifier is invalid", t.Name)))
+		}
+
+		var criteria []bson.M
+		for _, system := range systems {
+			codeCriteria := bson.M{}
+			if system != "" {
+				codeCriteria["system"] = ci(system)
+			}
+			if !wholeSystems[system] {
+				codeCriteria["code"] = bson.M{"$in": codesBySystem[system]}
+			}
+			if p.Type == "CodeableConcept" {
+				codeCriteria = bson.M{"coding": bson.M{"$elemMatch": codeCriteria}}
+			}
+			criteria = append(criteria, codeCriteria)
+		}
+		switch len(criteria) {
+		case 0:
+			// No codes, so nothing matches
+			return bson.M{convertSearchPathToMongoField(p.Path): bson.M{"$in": []interface{}{}}}
+		case 1:
+			return buildBSON(p.Path, criteria[0])
+		}
+		return buildBSON(p.Path, bson.M{"$or": criteria})
+	}
+
+	return orPaths(single, t.Paths)
+}
+
 func (m *MongoSearcher) createURIQueryObject(u *URIParam) bson.M {
 	single := func(p SearchParamPath) bson.M {
 		return buildBSON(p.Path, u.URI)
@@ -793,10 +1171,11 @@ func processQueryOperatorCriteria(path string, key string, value interface{}, re
 	case "$or":
 		processOrCriteria(path, value, result)
 	default:
-		criteria, ok := result[path]
+		field := convertSearchPathToMongoField(path)
+		criteria, ok := result[field]
 		if !ok {
 			criteria = bson.M{}
-			result[path] = criteria
+			result[field] = criteria
 		}
 		criteria.(bson.M)[key] = value
 	}
@@ -824,6 +1203,11 @@ func cisw(s string) bson.RegEx {
 	return bson.RegEx{Pattern: fmt.Sprintf("^%s", regexp.QuoteMeta(s)), Options: "i"}
 }
 
+// Case-insensitive contains
+func cic(s string) bson.RegEx {
+	return bson.RegEx{Pattern: regexp.QuoteMeta(s), Options: "i"}
+}
+
 // When multiple paths are present, they should be represented as an OR.
 // objFunc is a function that generates a single query for a path
 func orPaths(objFunc func(SearchParamPath) bson.M, paths []SearchParamPath) bson.M {
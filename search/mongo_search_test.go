@@ -188,6 +188,136 @@ func getCodeableConceptComparisonValue(c *models.CodeableConcept) string {
 	return c.Text
 }
 
+// Tests token searches with modifiers
+
+func (m *MongoSearchSuite) TestConditionCodeTextQueryObject(c *C) {
+	q := Query{"Condition", "code:text=heart"}
+
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$or": []bson.M{
+			bson.M{"code.text": bson.RegEx{Pattern: "^heart", Options: "i"}},
+			bson.M{"code.coding.display": bson.RegEx{Pattern: "^heart", Options: "i"}},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestConditionCodeTextQuery(c *C) {
+	counts := map[string]int{"diagnosis, active": 5, "Pertussis": 1, "Heart": 0}
+	for text, expected := range counts {
+		q := Query{"Condition", "code:text=" + text}
+		num, err := m.MongoSearcher.CreateQuery(q).Count()
+		util.CheckErr(err)
+		c.Assert(num, Equals, expected, Commentf("code:text=%s", text))
+	}
+}
+
+func (m *MongoSearchSuite) TestConditionCodeNotQueryObject(c *C) {
+	q := Query{"Condition", "code:not=http://snomed.info/sct|123641001"}
+
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$nor": []bson.M{
+			bson.M{
+				"code.coding": bson.M{
+					"$elemMatch": bson.M{
+						"system": bson.RegEx{Pattern: "^http://snomed\\.info/sct$", Options: "i"},
+						"code":   bson.RegEx{Pattern: "^123641001$", Options: "i"},
+					},
+				},
+			},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestConditionCodeNotQuery(c *C) {
+	q := Query{"Condition", "code:not=http://snomed.info/sct|123641001"}
+	num, err := m.MongoSearcher.CreateQuery(q).Count()
+	util.CheckErr(err)
+	c.Assert(num, Equals, 4)
+}
+
+func (m *MongoSearchSuite) insertValueSet(c *C, data string) {
+	var resourceMap map[string]interface{}
+	util.CheckErr(json.Unmarshal([]byte(data), &resourceMap))
+	util.CheckErr(m.Session.DB("fhir-test").C("valuesets").Insert(models.MapToResource(resourceMap, true)))
+}
+
+func (m *MongoSearchSuite) removeValueSet(id string) {
+	util.CheckErr(m.Session.DB("fhir-test").C("valuesets").RemoveId(id))
+}
+
+func (m *MongoSearchSuite) TestConditionCodeInQuery(c *C) {
+	m.insertValueSet(c, `{
+		"resourceType": "ValueSet",
+		"id": "heart-failure",
+		"url": "http://example.org/fhir/ValueSet/heart-failure",
+		"status": "active",
+		"compose": {"include": [{"system": "http://snomed.info/sct", "concept": [{"code": "10091002"}, {"code": "981000124106"}]}]}
+	}`)
+	defer m.removeValueSet("heart-failure")
+	m.insertValueSet(c, `{
+		"resourceType": "ValueSet",
+		"id": "heart",
+		"url": "http://example.org/fhir/ValueSet/heart",
+		"status": "active",
+		"compose": {
+			"import": ["http://example.org/fhir/ValueSet/heart-failure"],
+			"include": [{"system": "http://hl7.org/fhir/sid/icd-9", "concept": [{"code": "411.0"}, {"code": "401.1"}]}],
+			"exclude": [{"system": "http://hl7.org/fhir/sid/icd-9", "concept": [{"code": "401.1"}]}]
+		}
+	}`)
+	defer m.removeValueSet("heart")
+
+	counts := map[string]int{
+		"code:in=http://example.org/fhir/ValueSet/heart-failure":     2,
+		"code:in=http://example.org/fhir/ValueSet/heart":             4,
+		"code:in=ValueSet/heart":                                     4,
+		"code:not-in=http://example.org/fhir/ValueSet/heart-failure": 4,
+	}
+	for query, expected := range counts {
+		q := Query{"Condition", query}
+		num, err := m.MongoSearcher.CreateQuery(q).Count()
+		util.CheckErr(err)
+		c.Assert(num, Equals, expected, Commentf("%s", query))
+	}
+
+	q := Query{"Condition", "code:in=http://example.org/fhir/ValueSet/unknown"}
+	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"code\" content is invalid"))
+}
+
+func (m *MongoSearchSuite) TestConditionCodeAboveAndBelowQuery(c *C) {
+	m.insertValueSet(c, `{
+		"resourceType": "ValueSet",
+		"id": "cardiac",
+		"url": "http://example.org/fhir/ValueSet/cardiac",
+		"status": "active",
+		"codeSystem": {"system": "http://snomed.info/sct", "concept": [
+			{"code": "49601007", "display": "Disorder of cardiovascular system", "concept": [
+				{"code": "10091002", "display": "High output heart failure", "concept": [
+					{"code": "981000124106", "display": "Moderate or severe LVSD"}
+				]},
+				{"code": "123641001", "display": "Left coronary artery occlusion"}
+			]}
+		]}
+	}`)
+	defer m.removeValueSet("cardiac")
+
+	counts := map[string]int{
+		"code:below=http://snomed.info/sct|49601007":     4,
+		"code:below=http://snomed.info/sct|10091002":     2,
+		"code:above=http://snomed.info/sct|981000124106": 2,
+		"code:above=http://snomed.info/sct|123641001":    2,
+		"code:below=http://snomed.info/sct|27836007":     1,
+	}
+	for query, expected := range counts {
+		q := Query{"Condition", query}
+		num, err := m.MongoSearcher.CreateQuery(q).Count()
+		util.CheckErr(err)
+		c.Assert(num, Equals, expected, Commentf("%s", query))
+	}
+}
+
 // Tests token searches on Coding
 
 func (m *MongoSearchSuite) TestImagingStudyBodySiteQueryObjectBySystemAndCode(c *C) {
@@ -326,7 +456,69 @@ func (m *MongoSearchSuite) TestInvalidBooleanValuePanics(c *C) {
 	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"notgiven\" content is invalid"))
 }
 
-// TODO: Test token searches on code, string, and ContactPoint
+// Test token searches on ContactPoint
+
+func (m *MongoSearchSuite) TestContactPointQueryObject(c *C) {
+	q := Query{"Patient", "telecom=phone|(555) 123-4567"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"telecom": bson.M{
+			"$elemMatch": bson.M{
+				"system": bson.RegEx{Pattern: "^phone$", Options: "i"},
+				"value":  bson.RegEx{Pattern: `^\D*5\D*5\D*5\D*1\D*2\D*3\D*4\D*5\D*6\D*7\D*$`},
+			},
+		},
+	})
+
+	// The email and phone parameters only match their own system
+	q = Query{"Practitioner", "email=John@Example.com"}
+	o = m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"telecom": bson.M{
+			"$elemMatch": bson.M{
+				"system": bson.RegEx{Pattern: "^email$", Options: "i"},
+				"value":  bson.RegEx{Pattern: "^John@Example\\.com$", Options: "i"},
+			},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestContactPointQuery(c *C) {
+	var resourceMap map[string]interface{}
+	util.CheckErr(json.Unmarshal([]byte(`{
+		"resourceType": "Patient",
+		"id": "contact-test",
+		"telecom": [
+			{"system": "phone", "value": "(555) 123-4567", "use": "home"},
+			{"system": "email", "value": "john@example.com", "use": "work"}
+		]
+	}`), &resourceMap))
+	patients := m.Session.DB("fhir-test").C("patients")
+	util.CheckErr(patients.Insert(models.MapToResource(resourceMap, true)))
+	defer patients.RemoveId("contact-test")
+
+	counts := map[string]int{
+		"phone=5551234567":               1,
+		"phone=555.123.4567":             1,
+		"phone=5551234568":               0,
+		"phone=john@example.com":         0,
+		"email=JOHN@example.com":         1,
+		"email=5551234567":               0,
+		"telecom=phone|555-123-4567":     1,
+		"telecom=email|555-123-4567":     0,
+		"telecom=john@example.com":       1,
+		"telecom=5551234567":             1,
+		"telecom=home|5551234567":        0,
+		"telecom=email|john@example.com": 1,
+	}
+	for query, expected := range counts {
+		num, err := m.MongoSearcher.CreateQuery(Query{"Patient", query}).Count()
+		util.CheckErr(err)
+		c.Assert(num, Equals, expected, Commentf("%s", query))
+	}
+}
+
+// TODO: Test token searches on code and string
 
 // Tests reference searches by reference id
 
@@ -1063,6 +1255,46 @@ func (m *MongoSearchSuite) TestImmunizationDoseSequenceWrongNumberQuery(c *C) {
 	c.Assert(num, Equals, 0)
 }
 
+func (m *MongoSearchSuite) TestPrefixedNumberSelectors(c *C) {
+	selectors := map[string]bson.M{
+		"100":     bson.M{"$gte": float64(99.5), "$lt": float64(100.5)},
+		"eq100":   bson.M{"$gte": float64(99.5), "$lt": float64(100.5)},
+		"ne100":   bson.M{"$not": bson.M{"$gte": float64(99.5), "$lt": float64(100.5)}, "$exists": true},
+		"gt100":   bson.M{"$gt": float64(100)},
+		"lt100":   bson.M{"$lt": float64(100)},
+		"ge100":   bson.M{"$gte": float64(99.5)},
+		"le100":   bson.M{"$lt": float64(100.5)},
+		"sa100":   bson.M{"$gte": float64(100.5)},
+		"eb100":   bson.M{"$lt": float64(99.5)},
+		"ap100":   bson.M{"$gte": float64(90), "$lte": float64(110)},
+		"ap1":     bson.M{"$gte": float64(0.5), "$lte": float64(1.5)},
+		"ge0.8":   bson.M{"$gte": float64(0.75)},
+		"lt-2.50": bson.M{"$lt": float64(-2.5)},
+	}
+	for value, expected := range selectors {
+		prefix, number := ExtractPrefixAndValue(value)
+		c.Assert(numberSelector("foo", prefix, ParseNumber(number)), DeepEquals, expected, Commentf("%s", value))
+	}
+}
+
+func (m *MongoSearchSuite) TestPrefixedNumberQueryObject(c *C) {
+	q := Query{"Immunization", "dose-sequence=ge2"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"vaccinationProtocol.doseSequence": bson.M{"$gte": float64(1.5)},
+	})
+}
+
+func (m *MongoSearchSuite) TestPrefixedNumberQuery(c *C) {
+	counts := map[string]int{"gt0": 1, "gt1": 0, "ge1": 1, "lt1": 0, "le1": 1, "ne1": 0, "ne2": 1, "sa0": 1, "eb2": 1, "ap1": 1}
+	for value, expected := range counts {
+		q := Query{"Immunization", "dose-sequence=" + value}
+		num, err := m.MongoSearcher.CreateQuery(q).Count()
+		util.CheckErr(err)
+		c.Assert(num, Equals, expected, Commentf("dose-sequence=%s", value))
+	}
+}
+
 // TODO: Test number searches on decimal, integer, and unsignedInt
 
 // Test string searches on string
@@ -1166,6 +1398,55 @@ func (m *MongoSearchSuite) TestNonMatchingPatientNameStringQuery(c *C) {
 	c.Assert(num, Equals, 0)
 }
 
+func (m *MongoSearchSuite) TestPatientNameExactStringQueryObject(c *C) {
+	q := Query{"Patient", "name:exact=Peters"}
+
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$or": []bson.M{
+			bson.M{"name.text": "Peters"},
+			bson.M{"name.family": "Peters"},
+			bson.M{"name.given": "Peters"},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestPatientNameExactStringQuery(c *C) {
+	counts := map[string]int{"Peters": 2, "Sally": 1, "peters": 0, "Pete": 0}
+	for name, expected := range counts {
+		q := Query{"Patient", "name:exact=" + name}
+		num, err := m.MongoSearcher.CreateQuery(q).Count()
+		util.CheckErr(err)
+		c.Assert(num, Equals, expected, Commentf("name:exact=%s", name))
+	}
+}
+
+func (m *MongoSearchSuite) TestPatientAddressContainsStringQueryObject(c *C) {
+	q := Query{"Patient", "address:contains=broad"}
+
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$or": []bson.M{
+			bson.M{"address.text": bson.RegEx{Pattern: "broad", Options: "i"}},
+			bson.M{"address.line": bson.RegEx{Pattern: "broad", Options: "i"}},
+			bson.M{"address.city": bson.RegEx{Pattern: "broad", Options: "i"}},
+			bson.M{"address.state": bson.RegEx{Pattern: "broad", Options: "i"}},
+			bson.M{"address.postalCode": bson.RegEx{Pattern: "broad", Options: "i"}},
+			bson.M{"address.country": bson.RegEx{Pattern: "broad", Options: "i"}},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestPatientContainsStringQuery(c *C) {
+	counts := map[string]int{"name:contains=ETE": 2, "name:contains=ally": 1, "address:contains=town": 2, "name:contains=x": 0}
+	for query, expected := range counts {
+		q := Query{"Patient", query}
+		num, err := m.MongoSearcher.CreateQuery(q).Count()
+		util.CheckErr(err)
+		c.Assert(num, Equals, expected, Commentf("%s", query))
+	}
+}
+
 func (m *MongoSearchSuite) TestPatientSortByNameAscending(c *C) {
 	var patients []*models.Patient
 	q := Query{"Patient", "_sort=name"}
@@ -1354,6 +1635,26 @@ func (m *MongoSearchSuite) TestValueQuantityQueryByValueAndSystemAndWrongCode(c
 	c.Assert(num, Equals, 0)
 }
 
+func (m *MongoSearchSuite) TestPrefixedValueQuantityQueryObject(c *C) {
+	q := Query{"Observation", "value-quantity=gt140|http://unitsofmeasure.org|mm[Hg]"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"valueQuantity.value":  bson.M{"$gt": float64(140)},
+		"valueQuantity.code":   bson.RegEx{Pattern: "^mm\\[Hg\\]$", Options: "i"},
+		"valueQuantity.system": bson.RegEx{Pattern: "^http://unitsofmeasure\\.org$", Options: "i"},
+	})
+}
+
+func (m *MongoSearchSuite) TestPrefixedValueQuantityQuery(c *C) {
+	counts := map[string]int{"gt180": 1, "gt185": 0, "ge185": 1, "lt190": 1, "le184": 0, "ne185": 0, "ne180": 1, "ap170": 1, "ap160": 0}
+	for value, expected := range counts {
+		q := Query{"Observation", "value-quantity=" + value + "|http://unitsofmeasure.org|[lb_av]"}
+		num, err := m.MongoSearcher.CreateQuery(q).Count()
+		util.CheckErr(err)
+		c.Assert(num, Equals, expected, Commentf("value-quantity=%s", value))
+	}
+}
+
 func (m *MongoSearchSuite) TestObservationSortByValueQuantityAscending(c *C) {
 	var observations []*models.Observation
 	q := Query{"Observation", "_sort=value-quantity"}
@@ -1417,7 +1718,96 @@ func (m *MongoSearchSuite) TestSubscriptionURLQuery(c *C) {
 	c.Assert(num, Equals, 1)
 }
 
-// TODO: Test composite searches
+// Test composite searches
+
+func (m *MongoSearchSuite) TestCodeValueQuantityQueryObject(c *C) {
+	q := Query{"Observation", "code-value-quantity=http://loinc.org|3141-9$185||lbs"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"code.coding": bson.M{
+			"$elemMatch": bson.M{
+				"system": bson.RegEx{Pattern: "^http://loinc\\.org$", Options: "i"},
+				"code":   bson.RegEx{Pattern: "^3141-9$", Options: "i"},
+			},
+		},
+		"valueQuantity.value": bson.M{
+			"$gte": float64(184.5),
+			"$lt":  float64(185.5),
+		},
+		"$or": []bson.M{
+			bson.M{"valueQuantity.code": bson.RegEx{Pattern: "^lbs$", Options: "i"}},
+			bson.M{"valueQuantity.unit": bson.RegEx{Pattern: "^lbs$", Options: "i"}},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestCodeValueQuantityQuery(c *C) {
+	q := Query{"Observation", "code-value-quantity=http://loinc.org|3141-9$185||lbs"}
+	num, err := m.MongoSearcher.CreateQuery(q).Count()
+	util.CheckErr(err)
+	c.Assert(num, Equals, 1)
+
+	// The code and value must both match
+	q = Query{"Observation", "code-value-quantity=http://loinc.org|17856-6$185||lbs"}
+	num, err = m.MongoSearcher.CreateQuery(q).Count()
+	util.CheckErr(err)
+	c.Assert(num, Equals, 0)
+
+	// Either composite value may match
+	q = Query{"Observation", "code-value-quantity=http://loinc.org|17856-6$8||%25,http://loinc.org|3141-9$185||lbs"}
+	num, err = m.MongoSearcher.CreateQuery(q).Count()
+	util.CheckErr(err)
+	c.Assert(num, Equals, 2)
+}
+
+func (m *MongoSearchSuite) TestComponentCodeValueQuantityQueryObject(c *C) {
+	expected := bson.M{
+		"component": bson.M{
+			"$elemMatch": bson.M{
+				"code.coding": bson.M{
+					"$elemMatch": bson.M{
+						"system": bson.RegEx{Pattern: "^http://loinc\\.org$", Options: "i"},
+						"code":   bson.RegEx{Pattern: "^8480-6$", Options: "i"},
+					},
+				},
+				"valueQuantity.value": bson.M{
+					"$gte": float64(119.5),
+					"$lt":  float64(120.5),
+				},
+				"$or": []bson.M{
+					bson.M{"valueQuantity.code": bson.RegEx{Pattern: "^mmHg$", Options: "i"}},
+					bson.M{"valueQuantity.unit": bson.RegEx{Pattern: "^mmHg$", Options: "i"}},
+				},
+			},
+		},
+	}
+	// The component code and value must match within the same component
+	for _, name := range []string{"component-code-component-value-quantity", "component-code-value-quantity"} {
+		q := Query{"Observation", name + "=http://loinc.org|8480-6$120||mmHg"}
+		c.Assert(m.MongoSearcher.createQueryObject(q), DeepEquals, expected)
+	}
+}
+
+func (m *MongoSearchSuite) TestCharacteristicValueQueryObject(c *C) {
+	q := Query{"Group", "characteristic-value=gender$true"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"characteristic": bson.M{
+			"$elemMatch": bson.M{
+				"code.coding.code": bson.RegEx{Pattern: "^gender$", Options: "i"},
+				"$or": []bson.M{
+					bson.M{"valueBoolean": true},
+					bson.M{"valueCodeableConcept.coding.code": bson.RegEx{Pattern: "^true$", Options: "i"}},
+				},
+			},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestCompositeSearchWithWrongNumberOfValuesPanics(c *C) {
+	q := Query{"Observation", "code-value-quantity=http://loinc.org|3141-9"}
+	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"code-value-quantity\" content is invalid"))
+}
 
 // Test custom search
 
@@ -1584,7 +1974,112 @@ func (m *MongoSearchSuite) TestConditionTagQuery(c *C) {
 	c.Assert(cond, DeepEquals, cond2)
 }
 
-// TODO: Test special searches: _content, _lastUpdated, _profile, _query, _security, _text
+func (m *MongoSearchSuite) TestConditionTagNotQuery(c *C) {
+	// Tagged resources (e.g., test data) can be left out
+	q := Query{"Condition", "_tag:not=foo|bar"}
+	num, err := m.MongoSearcher.CreateQuery(q).Count()
+	util.CheckErr(err)
+	c.Assert(num, Equals, 5)
+}
+
+// Tests special searches on _profile and _security
+
+func (m *MongoSearchSuite) TestProfileQueryObject(c *C) {
+	q := Query{"Patient", "_profile=http://example.org/fhir/StructureDefinition/test-patient"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{"meta.profile": "http://example.org/fhir/StructureDefinition/test-patient"})
+}
+
+func (m *MongoSearchSuite) TestSecurityQueryObject(c *C) {
+	q := Query{"Patient", "_security=http://hl7.org/fhir/v3/Confidentiality|R"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"meta.security": bson.M{
+			"$elemMatch": bson.M{
+				"system": bson.RegEx{Pattern: "^http://hl7\\.org/fhir/v3/Confidentiality$", Options: "i"},
+				"code":   bson.RegEx{Pattern: "^R$", Options: "i"},
+			}},
+	})
+}
+
+func (m *MongoSearchSuite) TestProfileAndSecurityQuery(c *C) {
+	var resourceMap map[string]interface{}
+	util.CheckErr(json.Unmarshal([]byte(`{
+		"resourceType": "Patient",
+		"id": "meta-test",
+		"meta": {
+			"profile": ["http://example.org/fhir/StructureDefinition/test-patient"],
+			"security": [{"system": "http://hl7.org/fhir/v3/Confidentiality", "code": "R"}]
+		}
+	}`), &resourceMap))
+	patients := m.Session.DB("fhir-test").C("patients")
+	util.CheckErr(patients.Insert(models.MapToResource(resourceMap, true)))
+	defer patients.RemoveId("meta-test")
+
+	counts := map[string]int{
+		"_profile=http://example.org/fhir/StructureDefinition/test-patient": 1,
+		"_profile=http://example.org/fhir/StructureDefinition/other":        0,
+		"_security=http://hl7.org/fhir/v3/Confidentiality|R":                1,
+		"_security=R": 1,
+		"_security=http://hl7.org/fhir/v3/Confidentiality|N":     0,
+		"_security:not=http://hl7.org/fhir/v3/Confidentiality|R": 2,
+	}
+	for query, expected := range counts {
+		num, err := m.MongoSearcher.CreateQuery(Query{"Patient", query}).Count()
+		util.CheckErr(err)
+		c.Assert(num, Equals, expected, Commentf("%s", query))
+	}
+}
+
+// Tests special searches on _list
+
+func (m *MongoSearchSuite) TestListQuery(c *C) {
+	var resourceMap map[string]interface{}
+	util.CheckErr(json.Unmarshal([]byte(`{
+		"resourceType": "List",
+		"id": "list-test",
+		"status": "current",
+		"mode": "working",
+		"entry": [
+			{"item": {"reference": "Patient/4954037118555241963"}},
+			{"item": {"reference": "Patient/4954037118555579315"}, "deleted": true},
+			{"item": {"reference": "Condition/8664777288161038467"}}
+		]
+	}`), &resourceMap))
+	lists := m.Session.DB("fhir-test").C("lists")
+	util.CheckErr(lists.Insert(models.MapToResource(resourceMap, true)))
+	defer lists.RemoveId("list-test")
+
+	q := Query{"Patient", "_list=list-test"}
+	var patients []models.Patient
+	util.CheckErr(m.MongoSearcher.CreateQuery(q).All(&patients))
+	c.Assert(patients, HasLen, 1)
+	c.Assert(patients[0].Id, Equals, "4954037118555241963")
+
+	counts := map[string]int{
+		"_list=List/list-test":             1,
+		"_list=list-test&gender=female":    0,
+		"_list=list-test&gender=male":      1,
+		"_list=list-test&_tag:not=foo|bar": 1,
+	}
+	for query, expected := range counts {
+		num, err := m.MongoSearcher.CreateQuery(Query{"Patient", query}).Count()
+		util.CheckErr(err)
+		c.Assert(num, Equals, expected, Commentf("%s", query))
+	}
+
+	q = Query{"Condition", "_list=list-test"}
+	num, err := m.MongoSearcher.CreateQuery(q).Count()
+	util.CheckErr(err)
+	c.Assert(num, Equals, 1)
+}
+
+func (m *MongoSearchSuite) TestUnknownListPanics(c *C) {
+	q := Query{"Patient", "_list=does-not-exist"}
+	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_list\" content is invalid"))
+}
+
+// TODO: Test special searches: _lastUpdated, _query
 
 // Test searches with multiple values
 func (m *MongoSearchSuite) TestConditionMultipleCodesQueryObject(c *C) {
@@ -2109,6 +2604,30 @@ func (m *MongoSearchSuite) TestConditionQueryForIncludeWithTargets(c *C) {
 	c.Assert(practitioner.Id, Equals, "7045606679745586371")
 }
 
+func (m *MongoSearchSuite) TestObservationQueryForWildcardInclude(c *C) {
+	q := Query{"Observation", "_id=5637152931209212154&_include=*"}
+	var results []models.ObservationPlus
+	util.CheckErr(m.MongoSearcher.CreatePipeline(q).All(&results))
+	c.Assert(results, HasLen, 1)
+
+	obs := results[0]
+	patient, err := obs.GetIncludedPatientResourceReferencedByPatient()
+	util.CheckErr(err)
+	c.Assert(patient.Id, Equals, "4954037118555241963")
+	encounter, err := obs.GetIncludedEncounterResourceReferencedByEncounter()
+	util.CheckErr(err)
+	c.Assert(encounter.Id, Equals, "6648204100111387580")
+}
+
+func (m *MongoSearchSuite) TestQueryForIncludeFromOtherResource(c *C) {
+	// Includes from other resources only apply to included resources, so they don't add lookups to the pipeline
+	q := Query{"Observation", "_id=5637152931209212154&_include:recurse=Encounter:patient"}
+	var results []models.ObservationPlus
+	util.CheckErr(m.MongoSearcher.CreatePipeline(q).All(&results))
+	c.Assert(results, HasLen, 1)
+	c.Assert(results[0].GetIncludedResources(), HasLen, 0)
+}
+
 func (m *MongoSearchSuite) TestPatientGenderQueryOptionsForRevInclude(c *C) {
 	q := Query{"Patient", "gender=male&_revinclude=Condition:patient&_revinclude=Encounter:patient"}
 
@@ -2168,6 +2687,192 @@ func (m *MongoSearchSuite) TestPatientGenderQueryForRevInclude(c *C) {
 	}
 }
 
+// Test :missing searches
+
+func (m *MongoSearchSuite) TestMissingQueryObject(c *C) {
+	q := Query{"Patient", "birthdate:missing=true"}
+	c.Assert(m.MongoSearcher.createQueryObject(q), DeepEquals, bson.M{"birthDate": bson.M{"$exists": false}})
+
+	q = Query{"Condition", "code:missing=false"}
+	c.Assert(m.MongoSearcher.createQueryObject(q), DeepEquals, bson.M{"code": bson.M{"$exists": true}})
+
+	// Every path must be missing, or at least one must be present
+	q = Query{"Condition", "onset:missing=true"}
+	c.Assert(m.MongoSearcher.createQueryObject(q), DeepEquals, bson.M{
+		"$and": []bson.M{
+			bson.M{"onsetDateTime": bson.M{"$exists": false}},
+			bson.M{"onsetPeriod": bson.M{"$exists": false}},
+		},
+	})
+	q = Query{"Condition", "onset:missing=false"}
+	c.Assert(m.MongoSearcher.createQueryObject(q), DeepEquals, bson.M{
+		"$or": []bson.M{
+			bson.M{"onsetDateTime": bson.M{"$exists": true}},
+			bson.M{"onsetPeriod": bson.M{"$exists": true}},
+		},
+	})
+
+	q = Query{"Immunization", "dose-sequence:missing=true"}
+	c.Assert(m.MongoSearcher.createQueryObject(q), DeepEquals, bson.M{"vaccinationProtocol.doseSequence": bson.M{"$exists": false}})
+}
+
+func (m *MongoSearchSuite) TestMissingQuery(c *C) {
+	q := Query{"Patient", "birthdate:missing=false"}
+	num, err := m.MongoSearcher.CreateQuery(q).Count()
+	util.CheckErr(err)
+	c.Assert(num, Equals, 2)
+
+	q = Query{"Patient", "birthdate:missing=true"}
+	num, err = m.MongoSearcher.CreateQuery(q).Count()
+	util.CheckErr(err)
+	c.Assert(num, Equals, 0)
+}
+
+func (m *MongoSearchSuite) TestInvalidMissingValuePanics(c *C) {
+	q := Query{"Patient", "birthdate:missing=maybe"}
+	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"birthdate\" content is invalid"))
+}
+
+// Test reverse chained (_has) searches
+
+func (m *MongoSearchSuite) TestReverseChainedQueryObject(c *C) {
+	q := Query{"Patient", "_has:Condition:patient:code=27836007"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{"_id": bson.M{"$in": []string{"4954037118555579315"}}})
+
+	q = Query{"Patient", "_has:Condition:patient:code=foo"}
+	o = m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{"_id": bson.M{"$in": []string{}}})
+}
+
+func (m *MongoSearchSuite) TestReverseChainedQuery(c *C) {
+	q := Query{"Patient", "_has:Condition:patient:code=http://snomed.info/sct|123641001"}
+	mq := m.MongoSearcher.CreateQuery(q)
+	var patients []models.Patient
+	util.CheckErr(mq.All(&patients))
+	c.Assert(patients, HasLen, 1)
+	c.Assert(patients[0].Id, Equals, "4954037118555241963")
+
+	q = Query{"Patient", "_has:Observation:subject:code=17856-6"}
+	num, err := m.MongoSearcher.CreateQuery(q).Count()
+	util.CheckErr(err)
+	c.Assert(num, Equals, 1)
+
+	// Each _has parameter must be satisfied
+	q = Query{"Patient", "_has:Condition:patient:code=10725009&_has:Encounter:patient:_id=6648204100111387580"}
+	num, err = m.MongoSearcher.CreateQuery(q).Count()
+	util.CheckErr(err)
+	c.Assert(num, Equals, 1)
+
+	q = Query{"Patient", "_has:Condition:patient:code=27836007&_has:Encounter:patient:_id=6648204100111387580"}
+	num, err = m.MongoSearcher.CreateQuery(q).Count()
+	util.CheckErr(err)
+	c.Assert(num, Equals, 0)
+
+	q = Query{"Patient", "_has:Condition:patient:code=foo"}
+	num, err = m.MongoSearcher.CreateQuery(q).Count()
+	util.CheckErr(err)
+	c.Assert(num, Equals, 0)
+}
+
+func (m *MongoSearchSuite) TestInvalidReverseChainedSearchPanics(c *C) {
+	panicErr := createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_has\" content is invalid")
+
+	// The referencing parameter must exist, be a reference, and target the searched resource
+	q := Query{"Patient", "_has:Condition:foo:code=27836007"}
+	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, panicErr)
+	q = Query{"Patient", "_has:Condition:code:code=27836007"}
+	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, panicErr)
+	q = Query{"Encounter", "_has:Condition:patient:code=27836007"}
+	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, panicErr)
+
+	// There must be a chained parameter
+	q = Query{"Patient", "_has:Condition:patient=27836007"}
+	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, panicErr)
+}
+
+// Test full-text (_text and _content) searches
+
+func (m *MongoSearchSuite) TestFullTextQueryObject(c *C) {
+	q := Query{"Condition", "_content=metformin"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{"$text": bson.M{"$search": "metformin"}})
+
+	// The narrative must also contain the phrases and one of the words
+	q = Query{"Condition", "_text=heart \"artery disease\" -hypertension"}
+	o = m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$text": bson.M{"$search": "heart \"artery disease\" -hypertension"},
+		"$and": []bson.M{
+			bson.M{"text.div": bson.RegEx{Pattern: "artery disease", Options: "i"}},
+			bson.M{"text.div": bson.RegEx{Pattern: "heart", Options: "i"}},
+		},
+	})
+
+	q = Query{"Condition", "_text=fracture,sprain"}
+	o = m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$text": bson.M{"$search": "fracture,sprain"},
+		"$and": []bson.M{
+			bson.M{"$or": []bson.M{
+				bson.M{"text.div": bson.RegEx{Pattern: "fracture", Options: "i"}},
+				bson.M{"text.div": bson.RegEx{Pattern: "sprain", Options: "i"}},
+			}},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestFullTextQuery(c *C) {
+	conditions := m.Session.DB("fhir-test").C("conditions")
+	util.CheckErr(conditions.EnsureIndex(mgo.Index{Key: []string{"$text:$**"}}))
+	for _, data := range []string{
+		`{"resourceType": "Condition", "id": "fracture", "text": {"status": "generated", "div": "<div>Fracture of the left femur</div>"}}`,
+		`{"resourceType": "Condition", "id": "injury", "code": {"text": "Fracture"}, "text": {"status": "generated", "div": "<div>Injury</div>"}}`,
+	} {
+		var resourceMap map[string]interface{}
+		util.CheckErr(json.Unmarshal([]byte(data), &resourceMap))
+		util.CheckErr(conditions.Insert(models.MapToResource(resourceMap, true)))
+	}
+	defer conditions.RemoveId("fracture")
+	defer conditions.RemoveId("injury")
+
+	counts := map[string]int{
+		"_content=hypertension":           1,
+		"_content=disease":                2,
+		"_content=\"artery disease\"":     1,
+		"_content=heart -failure":         0,
+		"_content=fracture":               2,
+		"_text=fracture":                  1,
+		"_text=injury":                    1,
+		"_content=fracture&patient=12345": 0,
+	}
+	for query, expected := range counts {
+		num, err := m.MongoSearcher.CreateQuery(Query{"Condition", query}).Count()
+		util.CheckErr(err)
+		c.Assert(num, Equals, expected, Commentf("%s", query))
+	}
+}
+
+func (m *MongoSearchSuite) TestFullTextQueryRankedByRelevance(c *C) {
+	util.CheckErr(m.Session.DB("fhir-test").C("conditions").EnsureIndex(mgo.Index{Key: []string{"$text:$**"}}))
+
+	q := Query{"Condition", "_content=disease coronary artery"}
+	var conditions []models.Condition
+	util.CheckErr(m.MongoSearcher.CreateQuery(q).All(&conditions))
+	c.Assert(conditions, HasLen, 2)
+	c.Assert(conditions[0].Id, Equals, "5852315345721171557")
+
+	conditions = nil
+	util.CheckErr(m.MongoSearcher.CreatePipeline(q).All(&conditions))
+	c.Assert(conditions, HasLen, 2)
+	c.Assert(conditions[0].Id, Equals, "5852315345721171557")
+}
+
+func (m *MongoSearchSuite) TestRepeatedFullTextSearchPanics(c *C) {
+	q := Query{"Condition", "_content=heart&_text=failure"}
+	c.Assert(func() { m.MongoSearcher.createQueryObject(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_NO_REPEAT", "Parameter \"_text\" is not allowed to repeat"))
+}
+
 // Test that invalid search parameters PANIC (to ensure people know they are broken)
 func (m *MongoSearchSuite) TestInvalidSearchParameterPanics(c *C) {
 	q := Query{"Condition", "abatement=2012"}
@@ -2175,28 +2880,13 @@ func (m *MongoSearchSuite) TestInvalidSearchParameterPanics(c *C) {
 }
 
 // Test that unimplemented features PANIC (to ensure people know they are broken)
-func (m *MongoSearchSuite) TestCompositeSearchPanics(c *C) {
-	q := Query{"Group", "characteristic-value=gender$male"}
-	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, createInvalidSearchError("SEARCH_NONE", "Error: no processable search found for Group search parameters \"characteristic-value\""))
-}
-
 func (m *MongoSearchSuite) TestPrefixedDateSearchPanicsForUnsupportedPrefix(c *C) {
 	q := Query{"Condition", "onset=ap2012"}
 	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"onset\" content is invalid"))
 }
 
-func (m *MongoSearchSuite) TestPrefixedNumberSearchPanics(c *C) {
-	q := Query{"Immunization", "dose-sequence=gt1"}
-	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"dose-sequence\" content is invalid"))
-}
-
-func (m *MongoSearchSuite) TestPrefixedQuantitySearchPanics(c *C) {
-	q := Query{"Observation", "value-quantity=ap1||mg"}
-	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"value-quantity\" content is invalid"))
-}
-
 func (m *MongoSearchSuite) TestModifierSearchPanics(c *C) {
-	q := Query{"Condition", "code:text=headache"}
+	q := Query{"Condition", "code:subsumes=headache"}
 	c.Assert(func() { m.MongoSearcher.CreateQuery(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_MODIFIER_INVALID", "Parameter \"code\" modifier is invalid"))
 }
 
@@ -2,6 +2,7 @@ package search
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 )
 
@@ -61,6 +62,20 @@ func (r *Registry) LookupParameterInfo(resource, name string) (param SearchParam
 	return param, nil
 }
 
+// LookupParameterInfos looks up the search param info for all of the standard and custom search parameters supported
+// by a given resource, sorted by name.  If the resource has no search parameters, an empty slice is returned.
+func (r *Registry) LookupParameterInfos(resource string) []SearchParamInfo {
+	r.infosLock.RLock()
+	defer r.infosLock.RUnlock()
+	// SearchParameterDictionary contains both the standard parameters and those registered with the registry
+	params := make([]SearchParamInfo, 0, len(SearchParameterDictionary[resource]))
+	for _, param := range SearchParameterDictionary[resource] {
+		params = append(params, param)
+	}
+	sort.Sort(byParamName(params))
+	return params
+}
+
 // RegisterParameterParser registers a parameter parser for a given type name.
 func (r *Registry) RegisterParameterParser(paramType string, parser ParameterParser) {
 	r.parsersLock.Lock()
@@ -81,3 +96,9 @@ func (r *Registry) LookupParameterParser(paramType string) (parser ParameterPars
 
 // ParameterParser parses search parameter data into a SearchParam implementation.
 type ParameterParser func(info SearchParamInfo, data SearchParamData) (SearchParam, error)
+
+type byParamName []SearchParamInfo
+
+func (p byParamName) Len() int           { return len(p) }
+func (p byParamName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byParamName) Less(i, j int) bool { return p[i].Name < p[j].Name }
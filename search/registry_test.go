@@ -28,6 +28,22 @@ func (s *RegistrySuite) TestLookupNonExistingParameterInfo(c *C) {
 	c.Assert(obtained, DeepEquals, SearchParamInfo{}) // Zero Object
 }
 
+func (s *RegistrySuite) TestLookupParameterInfos(c *C) {
+	GlobalRegistry().RegisterParameterInfo(SearchParamInfo{Resource: "Blech", Name: "zed", Type: "string"})
+	GlobalRegistry().RegisterParameterInfo(SearchParamInfo{Resource: "Blech", Name: "alpha", Type: "token"})
+
+	obtained := GlobalRegistry().LookupParameterInfos("Blech")
+	c.Assert(obtained, HasLen, 2)
+	c.Assert(obtained[0].Name, Equals, "alpha")
+	c.Assert(obtained[1].Name, Equals, "zed")
+
+	// Standard parameters should be included too
+	patientParams := GlobalRegistry().LookupParameterInfos("Patient")
+	c.Assert(len(patientParams), Equals, len(SearchParameterDictionary["Patient"]))
+
+	c.Assert(GlobalRegistry().LookupParameterInfos("Nope"), HasLen, 0)
+}
+
 func (s *RegistrySuite) TestRegisterAndLookupParameterParser(c *C) {
 	info := SearchParamInfo{
 		Resource: "Blah",
@@ -5,9 +5,12 @@ import (
 	"math/big"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/intervention-engine/fhir/models"
 )
 
 // Constant values for search paramaters and search result parameters
@@ -21,6 +24,7 @@ const (
 	ContentParam       = "_content"
 	ListParam          = "_list"
 	QueryParam         = "_query"
+	HasParam           = "_has"
 	SortParam          = "_sort"
 	CountParam         = "_count"
 	IncludeParam       = "_include"
@@ -35,7 +39,7 @@ const (
 
 var globalSearchParams = map[string]bool{IDParam: true, LastUpdatedParam: true, TagParam: true,
 	ProfileParam: true, SecurityParam: true, TextParam: true, ContentParam: true, ListParam: true,
-	QueryParam: true}
+	QueryParam: true, HasParam: true}
 
 func isGlobalSearchParam(param string) bool {
 	_, found := globalSearchParams[param]
@@ -73,6 +77,21 @@ func (q *Query) Params() []SearchParam {
 			continue
 		}
 
+		// Some global parameters aren't in the dictionary, since they don't search a path of the resource
+		switch param {
+		case HasParam:
+			results = append(results, ParseReverseChainedParam(queryParam.Key, queryParam.Value, q.Resource))
+			continue
+		case ListParam:
+			info := SearchParamInfo{Resource: q.Resource, Name: param, Type: "list", Modifier: modifier}
+			results = append(results, ParseListMembershipParam(queryParam.Value, info))
+			continue
+		case TextParam, ContentParam:
+			info := SearchParamInfo{Resource: q.Resource, Name: param, Type: "text", Modifier: modifier}
+			results = append(results, ParseFullTextParam(queryParam.Value, info))
+			continue
+		}
+
 		info, ok := SearchParameterDictionary[q.Resource][param]
 		if ok {
 			info.Postfix = postfix
@@ -137,6 +156,24 @@ func (q *Query) Options() *QueryOptions {
 			}
 
 		case IncludeParam:
+			// _include:recurse (DSTU2) and _include:iterate (STU3) also apply the include to the included resources
+			iterate := false
+			switch modifier {
+			case "":
+			case RecurseModifier, IterateModifier:
+				iterate = true
+			default:
+				panic(createInvalidSearchError("MSG_PARAM_MODIFIER_INVALID", "Parameter \"_include\" modifier is invalid"))
+			}
+			if queryParam.Value == "*" {
+				if iterate {
+					// The included resources may be of any type, so the wildcard is resolved for each of them
+					options.Include = append(options.Include, IncludeOption{Resource: "*", Iterate: true, Wildcard: true})
+				} else {
+					options.Include = append(options.Include, WildcardIncludes(q.Resource)...)
+				}
+				continue
+			}
 			incls := strings.Split(queryParam.Value, ":")
 			if len(incls) < 2 || len(incls) > 3 {
 				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_include\" content is invalid"))
@@ -157,7 +194,7 @@ func (q *Query) Options() *QueryOptions {
 					panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_include\" content is invalid"))
 				}
 			}
-			options.Include = append(options.Include, IncludeOption{Resource: incls[0], Parameter: inclParam})
+			options.Include = append(options.Include, IncludeOption{Resource: incls[0], Parameter: inclParam, Iterate: iterate})
 
 		case RevIncludeParam:
 			incls := strings.Split(queryParam.Value, ":")
@@ -186,9 +223,29 @@ func (q *Query) Options() *QueryOptions {
 			}
 			options.RevInclude = append(options.RevInclude, RevIncludeOption{Resource: incls[0], Parameter: revInclParam})
 
+		case SummaryParam:
+			switch queryParam.Value {
+			case SummaryTrue, SummaryText, SummaryData, SummaryCount, SummaryFalse:
+				options.Summary = queryParam.Value
+			default:
+				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_summary\" content is invalid"))
+			}
+
+		case ElementsParam:
+			for _, element := range strings.Split(queryParam.Value, ",") {
+				element = strings.TrimSpace(element)
+				if element == "" || strings.ContainsAny(element, ".[]") {
+					panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_elements\" content is invalid"))
+				}
+				options.Elements = append(options.Elements, element)
+			}
+
 		case FormatParam:
-			if queryParam.Value != "json" && queryParam.Value != "application/json" && queryParam.Value != "application/json+fhir" {
-				// Currently we only support JSON
+			switch queryParam.Value {
+			case "json", "application/json", "application/json+fhir", "application/fhir+json",
+				"xml", "text/xml", "application/xml", "application/xml+fhir", "application/fhir+xml":
+				// Supported formats are handled by the content negotiation middleware
+			default:
 				panic(createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"_format\" content is invalid"))
 			}
 
@@ -245,6 +302,53 @@ type QueryOptions struct {
 	Include    []IncludeOption
 	RevInclude []RevIncludeOption
 	IsSTU3Sort bool
+	Summary    string
+	Elements   []string
+}
+
+// The values of the _summary parameter
+const (
+	SummaryTrue  = "true"
+	SummaryText  = "text"
+	SummaryData  = "data"
+	SummaryCount = "count"
+	SummaryFalse = "false"
+)
+
+// IsSubsetted indicates whether the _summary or _elements options call for only some of the elements of each
+// resource to be returned.  Resources returned in such a subset should be tagged as SUBSETTED.
+func (o *QueryOptions) IsSubsetted() bool {
+	switch o.Summary {
+	case SummaryTrue, SummaryText, SummaryData:
+		return true
+	case SummaryCount, SummaryFalse:
+		return false
+	}
+	return len(o.Elements) > 0
+}
+
+// SelectedElements returns the top-level elements of the given resource type to return according to the _summary
+// and _elements options.  If exclude is true, all elements except those returned should be returned instead.  If the
+// options don't call for a subset, no elements are returned.  The resourceType, id, and meta elements are always kept.
+func (o *QueryOptions) SelectedElements(resource string) (elements []string, exclude bool) {
+	if !o.IsSubsetted() {
+		return nil, false
+	}
+	mandatory := []string{"resourceType", "id", "meta"}
+	switch o.Summary {
+	case SummaryTrue:
+		return append(append(mandatory, "implicitRules"), models.SummaryElements(resource)...), false
+	case SummaryText:
+		return append(mandatory, "implicitRules", "text"), false
+	case SummaryData:
+		return []string{"text"}, true
+	}
+	for _, element := range o.Elements {
+		if element != "resourceType" && element != "id" && element != "meta" {
+			mandatory = append(mandatory, element)
+		}
+	}
+	return mandatory, false
 }
 
 // NewQueryOptions constructs a new QueryOptions with default values (offset = 0, Count = 100)
@@ -276,19 +380,68 @@ func (o *QueryOptions) URLQueryParameters() URLQueryParameters {
 	}
 	queryParams.Set(OffsetParam, strconv.Itoa(o.Offset))
 	queryParams.Set(CountParam, strconv.Itoa(o.Count))
+	wildcards := make(map[string]bool)
 	for _, incl := range o.Include {
-		queryParams.Add(IncludeParam, fmt.Sprintf("%s:%s", incl.Resource, incl.Parameter.Name))
+		inclParamKey := IncludeParam
+		if incl.Iterate {
+			inclParamKey += ":" + IterateModifier
+		}
+		if incl.Wildcard {
+			// The options _include=* stands for are represented by the wildcard
+			if !wildcards[inclParamKey] {
+				queryParams.Add(inclParamKey, "*")
+				wildcards[inclParamKey] = true
+			}
+			continue
+		}
+		queryParams.Add(inclParamKey, fmt.Sprintf("%s:%s", incl.Resource, incl.Parameter.Name))
 	}
 	for _, incl := range o.RevInclude {
 		queryParams.Add(RevIncludeParam, fmt.Sprintf("%s:%s", incl.Resource, incl.Parameter.Name))
 	}
+	if o.Summary != "" {
+		queryParams.Set(SummaryParam, o.Summary)
+	}
+	if len(o.Elements) > 0 {
+		queryParams.Set(ElementsParam, strings.Join(o.Elements, ","))
+	}
 	return queryParams
 }
 
-// IncludeOption describes the data that should be included in query results
+// The modifiers indicating that an _include applies to the included resources too.  DSTU2 calls this recurse, and
+// STU3 calls it iterate.
+const (
+	RecurseModifier = "recurse"
+	IterateModifier = "iterate"
+)
+
+// IncludeOption describes the data that should be included in query results.
+// If Iterate is true, the inclusion also applies to included resources (to a
+// bounded depth).  Wildcard indicates that the option is one of those that
+// _include=* stands for; an iterated wildcard applies to included resources
+// of any type, so its Resource is "*" and it has no Parameter.
 type IncludeOption struct {
 	Resource  string
 	Parameter SearchParamInfo
+	Iterate   bool
+	Wildcard  bool
+}
+
+// WildcardIncludes returns the options that _include=* stands for on the
+// resource: an option for each of its reference parameters, ordered by name.
+func WildcardIncludes(resource string) []IncludeOption {
+	var params []SearchParamInfo
+	for _, param := range SearchParameterDictionary[resource] {
+		if param.Type == "reference" {
+			params = append(params, param)
+		}
+	}
+	sort.Sort(byParamName(params))
+	includes := make([]IncludeOption, len(params))
+	for i, param := range params {
+		includes[i] = IncludeOption{Resource: resource, Parameter: param, Wildcard: true}
+	}
+	return includes
 }
 
 // RevIncludeOption describes the data that should be included in query results
@@ -335,6 +488,11 @@ type SearchParamInfo struct {
 // CreateSearchParam converts a singular string query value (e.g. "2012") into
 // a SearchParam object corresponding to the SearchParamInfo.
 func (s SearchParamInfo) CreateSearchParam(paramStr string) SearchParam {
+	// The :missing modifier applies to all parameter types, and its value is always true or false
+	if s.Modifier == MissingModifier {
+		return ParseMissingParam(paramStr, s)
+	}
+
 	if ors := escapeFriendlySplit(paramStr, ','); len(ors) > 1 {
 		return ParseOrParam(ors, s)
 	}
@@ -411,9 +569,14 @@ func (c *CompositeParam) getQueryParamAndValue() (string, string) {
 }
 
 // ParseCompositeParam parses a composite query string and returns a pointer to
-// a CompositeParam based on the query and the parameter definition.
+// a CompositeParam based on the query and the parameter definition.  There
+// must be a value for each of the parameter's components.
 func ParseCompositeParam(paramString string, info SearchParamInfo) *CompositeParam {
-	return &CompositeParam{info, escapeFriendlySplit(paramString, '$')}
+	values := escapeFriendlySplit(paramString, '$')
+	if len(values) != len(info.Composites) {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", info.Name)))
+	}
+	return &CompositeParam{info, values}
 }
 
 // DateParam represents a date-flavored search parameter.  The following
@@ -852,6 +1015,109 @@ type ChainedQueryReference struct {
 	ChainedQuery Query
 }
 
+// FullTextParam represents the _text and _content parameters, which search the
+// narrative of a resource and the entire content of a resource, respectively.
+// The text uses the search syntax of Mongo text indexes: words match any of
+// them, "quoted phrases" must match, and -words must not match.
+type FullTextParam struct {
+	SearchParamInfo
+	Text string
+}
+
+func (f *FullTextParam) getInfo() SearchParamInfo {
+	return f.SearchParamInfo
+}
+
+func (f *FullTextParam) getQueryParamAndValue() (string, string) {
+	return queryParamAndValue(f.SearchParamInfo, f.Text)
+}
+
+// ParseFullTextParam parses the text of a _text or _content parameter and
+// returns a pointer to a FullTextParam based on the text and the parameter
+// definition.
+func ParseFullTextParam(paramString string, info SearchParamInfo) *FullTextParam {
+	if strings.TrimSpace(paramString) == "" {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", info.Name)))
+	}
+	return &FullTextParam{info, paramString}
+}
+
+// ListMembershipParam represents the _list parameter, which selects the
+// resources that are entries of a List (e.g., /Patient?_list=42 selects the
+// patients in the List with id 42).  Functional lists, such as
+// $current-allergies, are not supported.
+type ListMembershipParam struct {
+	SearchParamInfo
+	ListID string
+}
+
+func (l *ListMembershipParam) getInfo() SearchParamInfo {
+	return l.SearchParamInfo
+}
+
+func (l *ListMembershipParam) getQueryParamAndValue() (string, string) {
+	return queryParamAndValue(l.SearchParamInfo, escape(l.ListID))
+}
+
+// ParseListMembershipParam parses a _list query string (the List's id) and
+// returns a pointer to a ListMembershipParam based on the query and the
+// parameter definition.
+func ParseListMembershipParam(paramStr string, info SearchParamInfo) *ListMembershipParam {
+	id := unescape(paramStr)
+	if strings.HasPrefix(id, "$") {
+		panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", info.Name)))
+	}
+	return &ListMembershipParam{info, strings.TrimPrefix(id, "List/")}
+}
+
+// ReverseChainedParam represents the _has parameter, which selects resources
+// by the properties of the resources referencing them.  For example, the
+// parameter in Patient?_has:Observation:patient:code=1234-5 selects the
+// patients referenced by the "patient" parameter of Observations with the code
+// 1234-5.  The following description is from the FHIR STU3 specification:
+//
+// The _has parameter provides limited support for reverse chaining - that is,
+// selecting resources based on the properties of resources that refer to them.
+type ReverseChainedParam struct {
+	SearchParamInfo
+	Reference    SearchParamInfo
+	ChainedQuery Query
+}
+
+func (r *ReverseChainedParam) getInfo() SearchParamInfo {
+	return r.SearchParamInfo
+}
+
+func (r *ReverseChainedParam) getQueryParamAndValue() (string, string) {
+	// As with chained references, first get the chained query param (e.g., "code=1234-5")
+	chainedParams := r.ChainedQuery.Params()
+	if len(chainedParams) != 1 {
+		panic(createInternalServerError("MSG_PARAM_CHAINED", fmt.Sprintf("Unknown chained parameter name \"%s\"", r.Name)))
+	}
+	cqParam, cqValue := chainedParams[0].getQueryParamAndValue()
+	// Then prefix it with the referencing resource and parameter (e.g., "_has:Observation:patient:code", "1234-5")
+	return fmt.Sprintf("%s:%s:%s:%s", HasParam, r.Reference.Resource, r.Reference.Name, cqParam), cqValue
+}
+
+// ParseReverseChainedParam parses a _has query parameter (e.g.,
+// "_has:Observation:patient:code") and its value, and returns a pointer to a
+// ReverseChainedParam searching on the given resource.  The referencing
+// resource's parameter must be a reference parameter targeting the resource.
+// The chained parameter may itself be a _has parameter.
+func ParseReverseChainedParam(paramString string, value string, resource string) *ReverseChainedParam {
+	info := SearchParamInfo{Resource: resource, Name: HasParam, Type: "reference"}
+	parts := strings.SplitN(paramString, ":", 4)
+	if len(parts) != 4 || parts[0] != HasParam {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", HasParam)))
+	}
+	refParam, ok := SearchParameterDictionary[parts[1]][parts[2]]
+	if !ok || refParam.Type != "reference" || !isValidTarget(resource, refParam) {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", HasParam)))
+	}
+	q := Query{Resource: parts[1], Query: url.QueryEscape(parts[3]) + "=" + url.QueryEscape(value)}
+	return &ReverseChainedParam{info, refParam, q}
+}
+
 // StringParam represents a string-flavored search parameter.  The
 // following description is from the FHIR DSTU2 specification:
 //
@@ -879,6 +1145,63 @@ func ParseStringParam(paramString string, info SearchParamInfo) *StringParam {
 	return &StringParam{info, unescape(paramString)}
 }
 
+// The modifiers supported for string search parameters.  By default, strings
+// match values that start with them, ignoring case.
+const (
+	ExactModifier    = "exact"
+	ContainsModifier = "contains"
+)
+
+// The modifiers supported for token search parameters.  The text modifier
+// searches the text or display associated with the code, in and not-in search
+// for codes in (or not in) the ValueSet with the given URL, and above and below
+// search for codes subsuming (or subsumed by) the code in a hierarchical code
+// system defined by a ValueSet.
+const (
+	TextModifier  = "text"
+	NotModifier   = "not"
+	InModifier    = "in"
+	NotInModifier = "not-in"
+	AboveModifier = "above"
+	BelowModifier = "below"
+)
+
+// MissingModifier is the modifier for searching on whether a parameter has a
+// value, which is supported on all parameter types.
+const MissingModifier = "missing"
+
+// MissingParam represents a search parameter with the :missing modifier.  The
+// following description is from the FHIR DSTU2 specification:
+//
+// For all parameters (except combination parameters), searching for
+// :missing=true returns resources that do not have a value for the parameter,
+// and :missing=false returns resources that do.
+type MissingParam struct {
+	SearchParamInfo
+	Missing bool
+}
+
+func (m *MissingParam) getInfo() SearchParamInfo {
+	return m.SearchParamInfo
+}
+
+func (m *MissingParam) getQueryParamAndValue() (string, string) {
+	return queryParamAndValue(m.SearchParamInfo, strconv.FormatBool(m.Missing))
+}
+
+// ParseMissingParam parses the value of a :missing parameter (true or false)
+// and returns a pointer to a MissingParam based on the value and the parameter
+// definition.
+func ParseMissingParam(paramString string, info SearchParamInfo) *MissingParam {
+	switch paramString {
+	case "true":
+		return &MissingParam{info, true}
+	case "false":
+		return &MissingParam{info, false}
+	}
+	panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", info.Name)))
+}
+
 // TokenParam represents a token-flavored search parameter.  The
 // following description is from the FHIR DSTU2 specification:
 //
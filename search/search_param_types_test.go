@@ -1,6 +1,7 @@
 package search
 
 import (
+	"regexp"
 	"time"
 
 	. "gopkg.in/check.v1"
@@ -98,6 +99,54 @@ func (s *SearchPTSuite) TestCompositeParamReconstitution(c *C) {
 	c.Assert(v, Equals, "abc$1\\$23")
 }
 
+func (s *SearchPTSuite) TestCompositeParamWithWrongNumberOfValues(c *C) {
+	c.Assert(func() { ParseCompositeParam("abc", compositeParamInfo) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"foo\" content is invalid"))
+	c.Assert(func() { ParseCompositeParam("abc$123$xyz", compositeParamInfo) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"foo\" content is invalid"))
+}
+
+func (s *SearchPTSuite) TestCompositeParamsInDictionary(c *C) {
+	info, ok := SearchParameterDictionary["Observation"]["code-value-quantity"]
+	c.Assert(ok, Equals, true)
+	c.Assert(info.Type, Equals, "composite")
+	c.Assert(info.Composites, DeepEquals, []string{"code", "value-quantity"})
+
+	// Each component must be one of the resource's search parameters
+	for _, info := range compositeSearchParams {
+		for _, name := range info.Composites {
+			_, ok := SearchParameterDictionary[info.Resource][name]
+			c.Assert(ok, Equals, true, Commentf("%s %s component %s", info.Resource, info.Name, name))
+		}
+	}
+}
+
+func (s *SearchPTSuite) TestContactParamsInDictionary(c *C) {
+	for _, resource := range []string{"Organization", "Patient", "Person", "Practitioner", "RelatedPerson"} {
+		for _, name := range []string{"email", "phone", "telecom"} {
+			info, ok := SearchParameterDictionary[resource][name]
+			c.Assert(ok, Equals, true, Commentf("%s %s", resource, name))
+			c.Assert(info.Type, Equals, "token")
+			c.Assert(info.Paths, DeepEquals, []SearchParamPath{{Path: "[]telecom", Type: "ContactPoint"}})
+		}
+	}
+}
+
+func (s *SearchPTSuite) TestPhoneNumberPattern(c *C) {
+	pattern, ok := phoneNumberPattern("(555) 123-4567")
+	c.Assert(ok, Equals, true)
+	re := regexp.MustCompile(pattern.Pattern)
+	for _, number := range []string{"5551234567", "555-123-4567", "(555) 123-4567", "555.123.4567"} {
+		c.Assert(re.MatchString(number), Equals, true, Commentf("%s", number))
+	}
+	for _, number := range []string{"15551234567", "555123456", "5551234568"} {
+		c.Assert(re.MatchString(number), Equals, false, Commentf("%s", number))
+	}
+
+	_, ok = phoneNumberPattern("john@example.com")
+	c.Assert(ok, Equals, false)
+	_, ok = phoneNumberPattern("()")
+	c.Assert(ok, Equals, false)
+}
+
 /******************************************************************************
  * DATE (Type)
  ******************************************************************************/
@@ -1375,6 +1424,192 @@ func (s *SearchPTSuite) TestURIReconstitution(c *C) {
 	c.Assert(v, Equals, "http://acme.org/fhir/ValueSet/123\\$45")
 }
 
+/******************************************************************************
+ * MISSING
+ ******************************************************************************/
+
+func (s *SearchPTSuite) TestMissingParam(c *C) {
+	info := dateParamInfo
+	info.Modifier = "missing"
+
+	m, ok := info.CreateSearchParam("true").(*MissingParam)
+	c.Assert(ok, Equals, true)
+	c.Assert(m.Name, Equals, "foo")
+	c.Assert(m.Missing, Equals, true)
+	p, v := m.getQueryParamAndValue()
+	c.Assert(p, Equals, "foo:missing")
+	c.Assert(v, Equals, "true")
+
+	m = ParseMissingParam("false", info)
+	c.Assert(m.Missing, Equals, false)
+
+	c.Assert(func() { ParseMissingParam("2012", info) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"foo\" content is invalid"))
+}
+
+/******************************************************************************
+ * ITERATED AND WILDCARD INCLUDES
+ ******************************************************************************/
+
+func (s *SearchPTSuite) TestIterateIncludeOptions(c *C) {
+	q := Query{"MedicationOrder", "_include=MedicationOrder:prescriber&_include:recurse=Practitioner:organization&_include:iterate=Organization:partof"}
+	o := q.Options()
+	c.Assert(o.Include, HasLen, 3)
+	c.Assert(o.Include[0].Resource, Equals, "MedicationOrder")
+	c.Assert(o.Include[0].Parameter.Name, Equals, "prescriber")
+	c.Assert(o.Include[0].Iterate, Equals, false)
+	c.Assert(o.Include[1].Resource, Equals, "Practitioner")
+	c.Assert(o.Include[1].Parameter.Name, Equals, "organization")
+	c.Assert(o.Include[1].Iterate, Equals, true)
+	c.Assert(o.Include[2].Resource, Equals, "Organization")
+	c.Assert(o.Include[2].Parameter.Name, Equals, "partof")
+	c.Assert(o.Include[2].Iterate, Equals, true)
+
+	queryParams := o.URLQueryParameters()
+	c.Assert(queryParams.All()[2:], DeepEquals, []URLQueryParameter{
+		{Key: "_include", Value: "MedicationOrder:prescriber"},
+		{Key: "_include:iterate", Value: "Practitioner:organization"},
+		{Key: "_include:iterate", Value: "Organization:partof"},
+	})
+
+	q = Query{"MedicationOrder", "_include:foo=MedicationOrder:prescriber"}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_MODIFIER_INVALID", "Parameter \"_include\" modifier is invalid"))
+}
+
+func (s *SearchPTSuite) TestWildcardIncludeOptions(c *C) {
+	q := Query{"MedicationOrder", "_include=*"}
+	o := q.Options()
+	var names []string
+	for _, incl := range o.Include {
+		c.Assert(incl.Resource, Equals, "MedicationOrder")
+		c.Assert(incl.Parameter.Type, Equals, "reference")
+		c.Assert(incl.Wildcard, Equals, true)
+		c.Assert(incl.Iterate, Equals, false)
+		names = append(names, incl.Parameter.Name)
+	}
+	c.Assert(names, DeepEquals, []string{"encounter", "medication", "patient", "prescriber"})
+
+	// Iterated wildcards apply to included resources of any type
+	q = Query{"MedicationOrder", "_include=*&_include:recurse=*"}
+	o = q.Options()
+	c.Assert(o.Include, HasLen, 5)
+	c.Assert(o.Include[4], DeepEquals, IncludeOption{Resource: "*", Iterate: true, Wildcard: true})
+
+	queryParams := o.URLQueryParameters()
+	c.Assert(queryParams.All()[2:], DeepEquals, []URLQueryParameter{
+		{Key: "_include", Value: "*"},
+		{Key: "_include:iterate", Value: "*"},
+	})
+}
+
+/******************************************************************************
+ * LIST (_list)
+ ******************************************************************************/
+
+func (s *SearchPTSuite) TestListMembershipParam(c *C) {
+	q := Query{"Patient", "_list=42"}
+	params := q.Params()
+	c.Assert(params, HasLen, 1)
+	l, ok := params[0].(*ListMembershipParam)
+	c.Assert(ok, Equals, true)
+	c.Assert(l.Resource, Equals, "Patient")
+	c.Assert(l.Name, Equals, "_list")
+	c.Assert(l.ListID, Equals, "42")
+	p, v := l.getQueryParamAndValue()
+	c.Assert(p, Equals, "_list")
+	c.Assert(v, Equals, "42")
+
+	// The List may be given as a reference
+	l = ParseListMembershipParam("List/42", l.SearchParamInfo)
+	c.Assert(l.ListID, Equals, "42")
+
+	// Functional lists aren't supported
+	q = Query{"Patient", "_list=$current-allergies"}
+	c.Assert(func() { q.Params() }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"_list\" content is invalid"))
+}
+
+/******************************************************************************
+ * FULL TEXT (_text and _content)
+ ******************************************************************************/
+
+func (s *SearchPTSuite) TestFullTextParams(c *C) {
+	q := Query{"DocumentReference", "_content=metformin&_count=10"}
+	params := q.Params()
+	c.Assert(params, HasLen, 1)
+	f, ok := params[0].(*FullTextParam)
+	c.Assert(ok, Equals, true)
+	c.Assert(f.Resource, Equals, "DocumentReference")
+	c.Assert(f.Name, Equals, "_content")
+	c.Assert(f.Text, Equals, "metformin")
+	c.Assert(q.Options().Count, Equals, 10)
+
+	// Commas don't separate values, since the text search syntax treats them as word separators
+	q = Query{"Condition", "_text=fracture,sprain"}
+	f, ok = q.Params()[0].(*FullTextParam)
+	c.Assert(ok, Equals, true)
+	c.Assert(f.Name, Equals, "_text")
+	c.Assert(f.Text, Equals, "fracture,sprain")
+	p, v := f.getQueryParamAndValue()
+	c.Assert(p, Equals, "_text")
+	c.Assert(v, Equals, "fracture,sprain")
+
+	q = Query{"Condition", "_text="}
+	c.Assert(func() { q.Params() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_text\" content is invalid"))
+}
+
+/******************************************************************************
+ * REVERSE CHAINED (_has)
+ ******************************************************************************/
+
+func (s *SearchPTSuite) TestReverseChainedParam(c *C) {
+	q := Query{"Patient", "_has:Observation:patient:code=http://loinc.org|1234-5"}
+	params := q.Params()
+	c.Assert(params, HasLen, 1)
+	r, ok := params[0].(*ReverseChainedParam)
+	c.Assert(ok, Equals, true)
+	c.Assert(r.Resource, Equals, "Patient")
+	c.Assert(r.Name, Equals, "_has")
+	c.Assert(r.Reference.Resource, Equals, "Observation")
+	c.Assert(r.Reference.Name, Equals, "patient")
+	c.Assert(r.ChainedQuery.Resource, Equals, "Observation")
+
+	chainedParams := r.ChainedQuery.Params()
+	c.Assert(chainedParams, HasLen, 1)
+	t, ok := chainedParams[0].(*TokenParam)
+	c.Assert(ok, Equals, true)
+	c.Assert(t.System, Equals, "http://loinc.org")
+	c.Assert(t.Code, Equals, "1234-5")
+
+	p, v := r.getQueryParamAndValue()
+	c.Assert(p, Equals, "_has:Observation:patient:code")
+	c.Assert(v, Equals, "http://loinc.org|1234-5")
+}
+
+func (s *SearchPTSuite) TestNestedReverseChainedParam(c *C) {
+	q := Query{"Patient", "_has:Observation:patient:_has:AuditEvent:reference:user=MyUserId"}
+	r, ok := q.Params()[0].(*ReverseChainedParam)
+	c.Assert(ok, Equals, true)
+	c.Assert(r.Reference.Resource, Equals, "Observation")
+
+	nested, ok := r.ChainedQuery.Params()[0].(*ReverseChainedParam)
+	c.Assert(ok, Equals, true)
+	c.Assert(nested.Resource, Equals, "Observation")
+	c.Assert(nested.Reference.Resource, Equals, "AuditEvent")
+	c.Assert(nested.Reference.Name, Equals, "reference")
+
+	p, v := r.getQueryParamAndValue()
+	c.Assert(p, Equals, "_has:Observation:patient:_has:AuditEvent:reference:user")
+	c.Assert(v, Equals, "MyUserId")
+}
+
+func (s *SearchPTSuite) TestReverseChainedParamIsNotAnOption(c *C) {
+	q := Query{"Patient", "_has:Observation:patient:code=1234-5&_count=10"}
+	c.Assert(q.Options().Count, Equals, 10)
+	queryParams := q.URLQueryParameters(false)
+	c.Assert(queryParams.All(), DeepEquals, []URLQueryParameter{
+		{Key: "_has:Observation:patient:code", Value: "1234-5"},
+	})
+}
+
 /******************************************************************************
  * OR
  ******************************************************************************/
@@ -1723,13 +1958,62 @@ func (s *SearchPTSuite) TestQueryOptionsInvalidRevIncludeParams(c *C) {
 }
 
 func (s *SearchPTSuite) TestQueryOptionsInvalidFormatParam(c *C) {
-	// Format that is not supported (XML)
-	q := Query{Resource: "Patient", Query: "_format=xml"}
+	// Format that is not supported (Turtle)
+	q := Query{Resource: "Patient", Query: "_format=text/turtle"}
 	c.Assert(func() { q.Options() }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"_format\" content is invalid"))
 
-	// Valid format (json)
+	// Valid formats (json and xml)
 	q = Query{Resource: "Patient", Query: "_format=json"}
 	q.Options()
+	q = Query{Resource: "Patient", Query: "_format=xml"}
+	q.Options()
+}
+
+func (s *SearchPTSuite) TestQueryOptionsSummaryAndElements(c *C) {
+	q := Query{Resource: "Patient", Query: "_summary=text"}
+	o := q.Options()
+	c.Assert(o.Summary, Equals, SummaryText)
+	c.Assert(o.IsSubsetted(), Equals, true)
+	elements, exclude := o.SelectedElements("Patient")
+	c.Assert(elements, DeepEquals, []string{"resourceType", "id", "meta", "implicitRules", "text"})
+	c.Assert(exclude, Equals, false)
+
+	q = Query{Resource: "Patient", Query: "_summary=true"}
+	o = q.Options()
+	c.Assert(o.Summary, Equals, SummaryTrue)
+	c.Assert(o.IsSubsetted(), Equals, true)
+	elements, exclude = o.SelectedElements("Patient")
+	c.Assert(elements, DeepEquals, []string{
+		"resourceType", "id", "meta", "implicitRules", "identifier", "active", "name", "telecom", "gender", "birthDate",
+		"deceasedBoolean", "deceasedDateTime", "address", "animal", "managingOrganization", "link",
+	})
+	c.Assert(exclude, Equals, false)
+
+	q = Query{Resource: "Patient", Query: "_summary=data"}
+	elements, exclude = q.Options().SelectedElements("Patient")
+	c.Assert(elements, DeepEquals, []string{"text"})
+	c.Assert(exclude, Equals, true)
+
+	q = Query{Resource: "Patient", Query: "_elements=gender,birthDate,id"}
+	o = q.Options()
+	c.Assert(o.Elements, DeepEquals, []string{"gender", "birthDate", "id"})
+	elements, exclude = o.SelectedElements("Patient")
+	c.Assert(elements, DeepEquals, []string{"resourceType", "id", "meta", "gender", "birthDate"})
+	c.Assert(exclude, Equals, false)
+
+	// Neither count nor false return a subset of the elements
+	for _, summary := range []string{"count", "false"} {
+		q = Query{Resource: "Patient", Query: "_summary=" + summary + "&_elements=gender"}
+		o = q.Options()
+		c.Assert(o.IsSubsetted(), Equals, false)
+		elements, _ = o.SelectedElements("Patient")
+		c.Assert(elements, HasLen, 0)
+	}
+
+	q = Query{Resource: "Patient", Query: "_summary=maybe"}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_summary\" content is invalid"))
+	q = Query{Resource: "Patient", Query: "_elements=name.family"}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_elements\" content is invalid"))
 }
 
 func (s *SearchPTSuite) TestReconstructQueryWithPassedInOptions(c *C) {
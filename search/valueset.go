@@ -0,0 +1,240 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/intervention-engine/fhir/models"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SystemCode is a code in a code system.  An empty code stands for every code in the system, and an empty system
+// for the code in any system.
+type SystemCode struct {
+	System string
+	Code   string
+}
+
+// ValueSetSource provides the ValueSets that value set expansions depend on.
+type ValueSetSource interface {
+	// ValueSet returns the ValueSet with the given URL (or reference, e.g. "ValueSet/123"), or nil if there is none.
+	ValueSet(url string) (*models.ValueSet, error)
+	// CodeSystems returns the ValueSets defining the code system with the given URL, or those defining any code
+	// system if the URL is empty.
+	CodeSystems(system string) ([]*models.ValueSet, error)
+}
+
+// ValueSetExpansion holds the codes in a value set.  If part of the value set could not be evaluated (an import that
+// could not be found, or a filter other than "is-a"), Complete is false and Codes holds the codes that could be.
+type ValueSetExpansion struct {
+	Codes    []SystemCode
+	Complete bool
+}
+
+// ExpandValueSet expands the ValueSet with the given URL (or reference, e.g. "ValueSet/123") into the codes it
+// contains.  The value set's code system, includes, imports, and expansion contribute codes, and its excludes remove
+// them.  Only "is-a" filters are supported, using the hierarchies of code systems defined in the source's value sets.
+// If there is no such ValueSet, nil is returned.
+func ExpandValueSet(source ValueSetSource, url string) (*ValueSetExpansion, error) {
+	vs, err := source.ValueSet(url)
+	if err != nil || vs == nil {
+		return nil, err
+	}
+	expansion := &ValueSetExpansion{Complete: true}
+	expansion.Codes, err = valueSetCodes(source, vs, expansion, map[string]bool{url: true})
+	if err != nil {
+		return nil, err
+	}
+	return expansion, nil
+}
+
+// valueSetCodes returns the codes in the value set, marking the expansion incomplete if any part of it cannot be
+// evaluated.  The visited URLs are the value sets being expanded, so circular imports are ignored.
+func valueSetCodes(source ValueSetSource, vs *models.ValueSet, expansion *ValueSetExpansion, visited map[string]bool) ([]SystemCode, error) {
+	var codes []SystemCode
+	if vs.CodeSystem != nil {
+		walkConcepts(vs.CodeSystem.Concept, func(concept models.ValueSetConceptDefinitionComponent, ancestors []string) {
+			codes = append(codes, SystemCode{vs.CodeSystem.System, concept.Code})
+		})
+	}
+	if vs.Compose != nil {
+		for _, url := range vs.Compose.Import {
+			if visited[url] {
+				continue
+			}
+			visited[url] = true
+			imported, err := source.ValueSet(url)
+			if err != nil {
+				return nil, err
+			} else if imported == nil {
+				expansion.Complete = false
+				continue
+			}
+			importedCodes, err := valueSetCodes(source, imported, expansion, visited)
+			if err != nil {
+				return nil, err
+			}
+			codes = append(codes, importedCodes...)
+		}
+		for _, include := range vs.Compose.Include {
+			included, err := conceptSetCodes(source, include, expansion)
+			if err != nil {
+				return nil, err
+			}
+			codes = append(codes, included...)
+		}
+		for _, exclude := range vs.Compose.Exclude {
+			excludedCodes, err := conceptSetCodes(source, exclude, expansion)
+			if err != nil {
+				return nil, err
+			}
+			excluded := make(map[SystemCode]bool)
+			for _, code := range excludedCodes {
+				excluded[code] = true
+			}
+			var remaining []SystemCode
+			for _, code := range codes {
+				if !excluded[code] && !excluded[SystemCode{System: code.System}] {
+					remaining = append(remaining, code)
+				}
+			}
+			codes = remaining
+		}
+	}
+	if vs.Expansion != nil {
+		codes = append(codes, expansionCodes(vs.Expansion.Contains)...)
+	}
+	return codes, nil
+}
+
+// conceptSetCodes returns the codes included (or excluded) by a value set's concept set
+func conceptSetCodes(source ValueSetSource, set models.ValueSetConceptSetComponent, expansion *ValueSetExpansion) ([]SystemCode, error) {
+	if len(set.Concept) == 0 && len(set.Filter) == 0 {
+		return []SystemCode{{System: set.System}}, nil
+	}
+	var codes []SystemCode
+	for _, concept := range set.Concept {
+		codes = append(codes, SystemCode{set.System, concept.Code})
+	}
+	for _, filter := range set.Filter {
+		if filter.Op != "is-a" {
+			expansion.Complete = false
+			continue
+		}
+		subsumed, err := hierarchyCodes(source, set.System, filter.Value, BelowModifier)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, subsumed...)
+	}
+	return codes, nil
+}
+
+func expansionCodes(contains []models.ValueSetExpansionContainsComponent) []SystemCode {
+	var codes []SystemCode
+	for _, c := range contains {
+		if c.Code != "" {
+			codes = append(codes, SystemCode{c.System, c.Code})
+		}
+		codes = append(codes, expansionCodes(c.Contains)...)
+	}
+	return codes
+}
+
+// hierarchyCodes returns the code and the codes subsumed by it (if modifier is BelowModifier) or subsuming it (if
+// modifier is AboveModifier) in the hierarchical code systems defined by the source's ValueSets.  If the system is
+// empty, the code systems of all of the ValueSets are searched.
+func hierarchyCodes(source ValueSetSource, system, code, modifier string) ([]SystemCode, error) {
+	valueSets, err := source.CodeSystems(system)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := []SystemCode{{system, code}}
+	for _, vs := range valueSets {
+		walkConcepts(vs.CodeSystem.Concept, func(concept models.ValueSetConceptDefinitionComponent, ancestors []string) {
+			if concept.Code != code {
+				return
+			}
+			if modifier == AboveModifier {
+				for _, ancestor := range ancestors {
+					codes = append(codes, SystemCode{vs.CodeSystem.System, ancestor})
+				}
+			} else {
+				walkConcepts(concept.Concept, func(descendant models.ValueSetConceptDefinitionComponent, _ []string) {
+					codes = append(codes, SystemCode{vs.CodeSystem.System, descendant.Code})
+				})
+			}
+		})
+	}
+	return codes, nil
+}
+
+// walkConcepts calls visit for each concept in the (hierarchical) code system definition, with the codes of the
+// concept's ancestors
+func walkConcepts(concepts []models.ValueSetConceptDefinitionComponent, visit func(models.ValueSetConceptDefinitionComponent, []string), ancestors ...string) {
+	for _, concept := range concepts {
+		visit(concept, ancestors)
+		walkConcepts(concept.Concept, visit, append(ancestors[:len(ancestors):len(ancestors)], concept.Code)...)
+	}
+}
+
+// mongoValueSetSource provides the ValueSets stored in the database
+type mongoValueSetSource struct {
+	db *mgo.Database
+}
+
+// ValueSet returns the stored ValueSet with the given URL or, failing that, the one the URL references
+func (s mongoValueSetSource) ValueSet(url string) (*models.ValueSet, error) {
+	c := s.db.C(models.PluralizeLowerResourceName("ValueSet"))
+	vs := &models.ValueSet{}
+	err := c.Find(bson.M{"url": url}).One(vs)
+	if err == mgo.ErrNotFound {
+		if i := strings.LastIndex(url, "ValueSet/"); i >= 0 {
+			err = c.FindId(strings.TrimPrefix(url[i:], "ValueSet/")).One(vs)
+		}
+	}
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+// CodeSystems returns the stored ValueSets defining the code system (or any code system, if it is empty)
+func (s mongoValueSetSource) CodeSystems(system string) ([]*models.ValueSet, error) {
+	query := bson.M{"codeSystem.system": system}
+	if system == "" {
+		query = bson.M{"codeSystem": bson.M{"$exists": true}}
+	}
+	var valueSets []*models.ValueSet
+	if err := s.db.C(models.PluralizeLowerResourceName("ValueSet")).Find(query).All(&valueSets); err != nil {
+		return nil, err
+	}
+	return valueSets, nil
+}
+
+// valueSetCodes expands the value set a token's :in (or :not-in) modifier refers to.  If there is no such value set,
+// the search is invalid, and if the value set can't be fully expanded, the search is unsupported.
+func (m *MongoSearcher) valueSetCodes(t *TokenParam) []SystemCode {
+	expansion, err := ExpandValueSet(mongoValueSetSource{m.db}, t.Code)
+	if err != nil {
+		panic(createInternalServerError("", err.Error()))
+	} else if expansion == nil {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", t.Name)))
+	} else if !expansion.Complete {
+		panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", t.Name)))
+	}
+	return expansion.Codes
+}
+
+// hierarchyCodes returns the codes a token's :above or :below modifier matches
+func (m *MongoSearcher) hierarchyCodes(t *TokenParam) []SystemCode {
+	codes, err := hierarchyCodes(mongoValueSetSource{m.db}, t.System, t.Code, t.Modifier)
+	if err != nil {
+		panic(createInternalServerError("", err.Error()))
+	}
+	return codes
+}
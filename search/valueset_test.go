@@ -0,0 +1,103 @@
+package search
+
+import (
+	"encoding/json"
+
+	"github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type ValueSetSuite struct{}
+
+var _ = Suite(&ValueSetSuite{})
+
+// mapValueSetSource provides the ValueSets in a map, by URL
+type mapValueSetSource map[string]*models.ValueSet
+
+func (s mapValueSetSource) ValueSet(url string) (*models.ValueSet, error) {
+	return s[url], nil
+}
+
+func (s mapValueSetSource) CodeSystems(system string) ([]*models.ValueSet, error) {
+	var valueSets []*models.ValueSet
+	for _, vs := range s {
+		if vs.CodeSystem != nil && (system == "" || vs.CodeSystem.System == system) {
+			valueSets = append(valueSets, vs)
+		}
+	}
+	return valueSets, nil
+}
+
+func (s mapValueSetSource) add(data string) {
+	vs := &models.ValueSet{}
+	util.CheckErr(json.Unmarshal([]byte(data), vs))
+	s[vs.Url] = vs
+}
+
+func (v *ValueSetSuite) TestExpandValueSet(c *C) {
+	source := make(mapValueSetSource)
+	source.add(`{
+		"resourceType": "ValueSet",
+		"url": "http://example.org/fhir/ValueSet/cardiac",
+		"codeSystem": {"system": "http://snomed.info/sct", "concept": [
+			{"code": "49601007", "concept": [
+				{"code": "10091002", "concept": [{"code": "981000124106"}]},
+				{"code": "123641001"}
+			]}
+		]}
+	}`)
+	source.add(`{
+		"resourceType": "ValueSet",
+		"url": "http://example.org/fhir/ValueSet/icd-9-heart",
+		"compose": {"include": [{"system": "http://hl7.org/fhir/sid/icd-9"}]}
+	}`)
+	source.add(`{
+		"resourceType": "ValueSet",
+		"url": "http://example.org/fhir/ValueSet/heart",
+		"compose": {
+			"import": ["http://example.org/fhir/ValueSet/icd-9-heart", "http://example.org/fhir/ValueSet/heart"],
+			"include": [
+				{"system": "http://snomed.info/sct", "filter": [{"property": "concept", "op": "is-a", "value": "10091002"}]},
+				{"system": "http://loinc.org", "concept": [{"code": "8480-6"}, {"code": "8462-4"}]}
+			],
+			"exclude": [{"system": "http://loinc.org", "concept": [{"code": "8462-4"}]}]
+		},
+		"expansion": {"contains": [{"system": "http://loinc.org", "code": "8867-4"}]}
+	}`)
+
+	expansion, err := ExpandValueSet(source, "http://example.org/fhir/ValueSet/heart")
+	util.CheckErr(err)
+	c.Assert(expansion.Complete, Equals, true)
+	c.Assert(expansion.Codes, DeepEquals, []SystemCode{
+		{"http://hl7.org/fhir/sid/icd-9", ""},
+		{"http://snomed.info/sct", "10091002"},
+		{"http://snomed.info/sct", "981000124106"},
+		{"http://loinc.org", "8480-6"},
+		{"http://loinc.org", "8867-4"},
+	})
+
+	expansion, err = ExpandValueSet(source, "http://example.org/fhir/ValueSet/unknown")
+	util.CheckErr(err)
+	c.Assert(expansion, IsNil)
+}
+
+func (v *ValueSetSuite) TestExpandIncompleteValueSet(c *C) {
+	source := make(mapValueSetSource)
+	source.add(`{
+		"resourceType": "ValueSet",
+		"url": "http://example.org/fhir/ValueSet/partial",
+		"compose": {
+			"import": ["http://example.org/fhir/ValueSet/unknown"],
+			"include": [
+				{"system": "http://loinc.org", "concept": [{"code": "8480-6"}]},
+				{"system": "http://snomed.info/sct", "filter": [{"property": "code", "op": "regex", "value": "^1"}]}
+			]
+		}
+	}`)
+
+	expansion, err := ExpandValueSet(source, "http://example.org/fhir/ValueSet/partial")
+	util.CheckErr(err)
+	c.Assert(expansion.Complete, Equals, false)
+	c.Assert(expansion.Codes, DeepEquals, []SystemCode{{"http://loinc.org", "8480-6"}})
+}
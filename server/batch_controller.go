@@ -8,10 +8,9 @@ import (
 	"reflect"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
-	"gopkg.in/mgo.v2/bson"
-
 	"github.com/gin-gonic/gin"
 	"github.com/intervention-engine/fhir/models"
 	"github.com/intervention-engine/fhir/search"
@@ -27,7 +26,11 @@ func NewBatchController(dal DataAccessLayer) *BatchController {
 	return &BatchController{DAL: dal}
 }
 
-// Post processes and incoming batch request
+// Post processes and incoming batch request.  The entries of a batch are processed independently, so an entry that
+// fails gets its own error response (with an OperationOutcome as the entry's resource) while the rest of the entries
+// are still processed.  The entries of a transaction succeed or fail as a whole, so the first failure rolls back any
+// changes already made and fails the entire request.  The return preference in the request's Prefer header determines
+// the resources returned in the responses to successful POST, PUT, and PATCH entries.
 func (b *BatchController) Post(c *gin.Context) {
 	bundle := &models.Bundle{}
 	err := FHIRBind(c, bundle)
@@ -38,55 +41,92 @@ func (b *BatchController) Post(c *gin.Context) {
 
 	// TODO: If type is batch, ensure there are no interdependent resources
 
-	// Loop through the entries, ensuring they have a request and that we support the method,
-	// while also creating a new entries array that can be sorted by method.
-	entries := make([]*models.BundleEntryComponent, len(bundle.Entry))
+	// Remember each entry's position in the bundle so failures can be reported against it
+	positions := make(map[*models.BundleEntryComponent]int, len(bundle.Entry))
 	for i := range bundle.Entry {
-		if bundle.Entry[i].Request == nil {
-			c.AbortWithError(http.StatusBadRequest, errors.New("Entries in a batch operation require a request"))
+		positions[&bundle.Entry[i]] = i
+	}
+
+	// In a batch, a failed entry is marked as such and skipped from then on.  In a transaction, the first failure is
+	// kept so it can be reported once the remaining processing is abandoned.
+	isTransaction := bundle.Type == "transaction"
+	failed := make(map[*models.BundleEntryComponent]bool)
+	var txFailure *entryFailure
+	fail := func(entry *models.BundleEntryComponent, status int, err error) {
+		if isTransaction {
+			if txFailure == nil {
+				txFailure = newEntryFailure(positions[entry], entry, status, err)
+			}
 			return
 		}
+		failed[entry] = true
+		setEntryFailure(entry, status, err)
+	}
 
-		switch bundle.Entry[i].Request.Method {
-		default:
-			c.AbortWithError(http.StatusNotImplemented,
-				errors.New("Operation currently unsupported in batch requests: "+bundle.Entry[i].Request.Method))
-			return
-		case "DELETE":
-			if bundle.Entry[i].Request.Url == "" {
-				c.AbortWithError(http.StatusBadRequest, errors.New("Batch DELETE must have a URL"))
-				return
-			}
-		case "POST":
-			if bundle.Entry[i].Resource == nil {
-				c.AbortWithError(http.StatusBadRequest, errors.New("Batch POST must have a resource body"))
-				return
+	// Loop through the entries, ensuring they have a request and that we support the method,
+	// while also creating a new entries array that can be sorted by method.
+	entries := make([]*models.BundleEntryComponent, 0, len(bundle.Entry))
+	for i := range bundle.Entry {
+		if err := validateEntry(&bundle.Entry[i]); err != nil {
+			status := http.StatusBadRequest
+			if err == errUnsupportedMethod {
+				status = http.StatusNotImplemented
+				err = errors.New("Operation currently unsupported in batch requests: " + bundle.Entry[i].Request.Method)
 			}
-		case "PUT":
-			if bundle.Entry[i].Resource == nil {
-				c.AbortWithError(http.StatusBadRequest, errors.New("Batch PUT must have a resource body"))
-				return
+			fail(&bundle.Entry[i], status, err)
+			continue
+		}
+		if method := bundle.Entry[i].Request.Method; method == "POST" || method == "PUT" {
+			if err := validateProfiles(b.DAL, requestConfig(c), bundle.Entry[i].Resource); err != nil {
+				status := http.StatusInternalServerError
+				if _, ok := err.(*models.OperationOutcome); ok {
+					status = http.StatusUnprocessableEntity
+				}
+				fail(&bundle.Entry[i], status, err)
+				continue
 			}
 		}
-		entries[i] = &bundle.Entry[i]
+		entries = append(entries, &bundle.Entry[i])
+	}
+	if txFailure != nil {
+		abortTransaction(c, nil, txFailure)
+		return
 	}
-
 	sort.Sort(byRequestMethod(entries))
 
 	// Now loop through the entries, assigning new IDs to those that are POST or Conditional PUT and fixing any
 	// references to reference the new ID.
 	refMap := make(map[string]models.Reference)
 	newIDs := make([]string, len(entries))
+	existing := make([]bool, len(entries))
 	for i, entry := range entries {
 		if entry.Request.Method == "POST" {
-			// Create a new ID and add it to the reference map
-			id := bson.NewObjectId().Hex()
+			// Create a new ID (or, for conditional creates, use the matching resource's ID) and add it to the
+			// reference map
+			id, err := b.DAL.NewID(entry.Request.Url)
+			if err != nil {
+				fail(entry, statusForError(err), err)
+				continue
+			}
+			if entry.Request.IfNoneExist != "" {
+				existingID, err := findIfNoneExistMatch(b.DAL, entry.Request.Url, entry.Request.IfNoneExist)
+				if err != nil {
+					fail(entry, statusForError(err), err)
+					continue
+				}
+				if existingID != "" {
+					id = existingID
+					existing[i] = true
+				}
+			}
 			newIDs[i] = id
-			refMap[entry.FullUrl] = models.Reference{
-				Reference:    entry.Request.Url + "/" + id,
-				Type:         entry.Request.Url,
-				ReferencedID: id,
-				External:     new(bool),
+			for _, key := range referenceKeys(entry.FullUrl) {
+				refMap[key] = models.Reference{
+					Reference:    entry.Request.Url + "/" + id,
+					Type:         entry.Request.Url,
+					ReferencedID: id,
+					External:     new(bool),
+				}
 			}
 
 			// Rewrite the FullUrl using the new ID
@@ -98,32 +138,47 @@ func (b *BatchController) Post(c *gin.Context) {
 			}
 
 			if err := b.resolveConditionalPut(c.Request, i, entry, newIDs, refMap); err != nil {
-				c.AbortWithError(http.StatusInternalServerError, err)
-				return
+				fail(entry, statusForError(err), err)
 			}
 		}
 	}
+	if txFailure != nil {
+		abortTransaction(c, nil, txFailure)
+		return
+	}
 
 	// Second pass to take care of conditionals referencing temporary IDs.  Known limitation: if a conditional
 	// references a temp ID also defined by a conditional, we error out if it hasn't been resolved yet -- too many
 	// rabbit holes.
 	for i, entry := range entries {
-		if entry.Request.Method == "PUT" && isConditional(entry) {
+		if !failed[entry] && entry.Request.Method == "PUT" && isConditional(entry) {
 			// Use a regex to swap out the temp IDs with the new IDs
 			for oldID, ref := range refMap {
-				re := regexp.MustCompile("([=,])(" + oldID + "|" + url.QueryEscape(oldID) + ")(&|,|$)")
+				re := regexp.MustCompile("([=,])(" + regexp.QuoteMeta(oldID) + "|" + regexp.QuoteMeta(url.QueryEscape(oldID)) + ")(&|,|$)")
 				entry.Request.Url = re.ReplaceAllString(entry.Request.Url, "${1}"+ref.Reference+"${3}")
 			}
 
 			if strings.Contains(entry.Request.Url, "urn:uuid:") || strings.Contains(entry.Request.Url, "urn%3Auuid%3A") {
-				c.AbortWithError(http.StatusNotImplemented,
-					errors.New("Cannot resolve conditionals referencing other conditionals"))
-				return
+				fail(entry, http.StatusNotImplemented, errors.New("Cannot resolve conditionals referencing other conditionals"))
+				continue
 			}
 
 			if err := b.resolveConditionalPut(c.Request, i, entry, newIDs, refMap); err != nil {
-				c.AbortWithError(http.StatusInternalServerError, err)
-				return
+				fail(entry, statusForError(err), err)
+			}
+		}
+	}
+	if txFailure != nil {
+		abortTransaction(c, nil, txFailure)
+		return
+	}
+
+	// Searches may also reference temporary IDs, so swap those out as well
+	for _, entry := range entries {
+		if !failed[entry] && entry.Request.Method == "GET" && strings.Contains(entry.Request.Url, "?") {
+			for oldID, ref := range refMap {
+				re := regexp.MustCompile("([=,])(" + regexp.QuoteMeta(oldID) + "|" + regexp.QuoteMeta(url.QueryEscape(oldID)) + ")(&|,|$)")
+				entry.Request.Url = re.ReplaceAllString(entry.Request.Url, "${1}"+ref.Reference+"${3}")
 			}
 		}
 	}
@@ -131,89 +186,376 @@ func (b *BatchController) Post(c *gin.Context) {
 	// Update all the references to the entries (to reflect newly assigned IDs)
 	updateAllReferences(entries, refMap)
 
-	// Then make the changes in the database and update the entry response
+	// Then make the changes in the database and update the entry response.  If this is a transaction, the changes
+	// are made within a database transaction so they can be rolled back if any entry fails.
+	dal := b.DAL
+	var tx Transaction
+	if isTransaction {
+		tx = b.DAL.StartTransaction()
+		dal = tx
+	}
+	preference := returnPreference(c.Request)
 	for i, entry := range entries {
-		switch entry.Request.Method {
-		case "DELETE":
-			if !isConditional(entry) {
-				// It's a normal DELETE
-				parts := strings.SplitN(entry.Request.Url, "/", 2)
-				if len(parts) != 2 {
-					c.AbortWithError(http.StatusInternalServerError,
-						fmt.Errorf("Couldn't identify resource and id to delete from %s", entry.Request.Url))
-					return
-				}
-				if err := b.DAL.Delete(parts[1], parts[0]); err != nil && err != ErrNotFound {
-					c.AbortWithError(http.StatusInternalServerError, err)
-					return
-				}
-			} else {
-				// It's a conditional (query-based) delete
-				parts := strings.SplitN(entry.Request.Url, "?", 2)
-				query := search.Query{Resource: parts[0], Query: parts[1]}
-				if _, err := b.DAL.ConditionalDelete(query); err != nil {
-					c.AbortWithError(http.StatusInternalServerError, err)
-					return
-				}
+		if failed[entry] {
+			continue
+		}
+		method, reqURL := entry.Request.Method, entry.Request.Url
+		if status, err := b.processEntry(c.Request, requestConfig(c), dal, entry, newIDs[i], existing[i]); err != nil {
+			if tx != nil {
+				abortTransaction(c, tx, &entryFailure{positions[entry], method, reqURL, status, err})
+				return
 			}
+			fail(entry, status, err)
+		} else if method == "POST" || method == "PUT" || method == "PATCH" {
+			applyReturnPreference(entry, preference, method)
+		}
+	}
+	if tx != nil {
+		tx.Commit()
+	}
 
-			entry.Request = nil
-			entry.Response = &models.BundleEntryResponseComponent{
-				Status: "204",
+	total := uint32(len(bundle.Entry))
+	bundle.Total = &total
+	bundle.Type = fmt.Sprintf("%s-response", bundle.Type)
+
+	c.Set("Bundle", bundle)
+	c.Set("Resource", "Bundle")
+	c.Set("Action", "batch")
+
+	// Send the response
+
+	c.Header("Access-Control-Allow-Origin", "*")
+	if preference != "" {
+		c.Header("Preference-Applied", "return="+preference)
+	}
+	c.JSON(http.StatusOK, bundle)
+}
+
+var errUnsupportedMethod = errors.New("Unsupported method")
+
+// validateEntry ensures the entry has a request with a supported method and the URL or resource that method requires
+func validateEntry(entry *models.BundleEntryComponent) error {
+	if entry.Request == nil {
+		return errors.New("Entries in a batch operation require a request")
+	}
+
+	switch entry.Request.Method {
+	default:
+		return errUnsupportedMethod
+	case "GET":
+		if entry.Request.Url == "" {
+			return errors.New("Batch GET must have a URL")
+		}
+	case "DELETE":
+		if entry.Request.Url == "" {
+			return errors.New("Batch DELETE must have a URL")
+		}
+	case "POST":
+		if entry.Resource == nil {
+			return errors.New("Batch POST must have a resource body")
+		}
+	case "PUT":
+		if entry.Resource == nil {
+			return errors.New("Batch PUT must have a resource body")
+		}
+	case "PATCH":
+		if entry.Request.Url == "" || entry.Resource == nil {
+			return errors.New("Batch PATCH must have a URL and a Binary or Parameters resource containing the patch")
+		}
+	}
+	return nil
+}
+
+// entryFailure describes the failure of a bundle entry, identified by its position in the bundle and its request
+type entryFailure struct {
+	position int
+	method   string
+	url      string
+	status   int
+	err      error
+}
+
+func newEntryFailure(position int, entry *models.BundleEntryComponent, status int, err error) *entryFailure {
+	f := &entryFailure{position: position, status: status, err: err}
+	if entry.Request != nil {
+		f.method, f.url = entry.Request.Method, entry.Request.Url
+	}
+	return f
+}
+
+// setEntryFailure replaces the entry's request with a response carrying the failure status.  DSTU2 bundle responses
+// have no outcome element, so the OperationOutcome describing the failure is returned as the entry's resource.
+func setEntryFailure(entry *models.BundleEntryComponent, status int, err error) {
+	entry.Request = nil
+	if outcome, ok := err.(*models.OperationOutcome); ok {
+		entry.Resource = outcome
+	} else if pe, ok := err.(*PatchError); ok {
+		entry.Resource = pe.OperationOutcome
+	} else if se, ok := err.(*search.Error); ok && se.OperationOutcome != nil {
+		entry.Resource = se.OperationOutcome
+	} else {
+		entry.Resource = models.NewOperationOutcome("error", issueTypeForStatus(status), err.Error())
+	}
+	entry.Response = &models.BundleEntryResponseComponent{
+		Status: strconv.Itoa(status),
+	}
+}
+
+// abortTransaction rolls back the transaction (if any changes were made) and responds with an OperationOutcome
+// describing the entry that caused the transaction to fail.
+func abortTransaction(c *gin.Context, tx Transaction, f *entryFailure) {
+	status := f.status
+	entry := fmt.Sprintf("Bundle.entry[%d]", f.position)
+	if f.method != "" {
+		entry += fmt.Sprintf(" (%s %s)", f.method, f.url)
+	}
+	diagnostics := fmt.Sprintf("%s failed: %s", entry, f.err.Error())
+	if tx != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			status = http.StatusInternalServerError
+			diagnostics += fmt.Sprintf(".  The transaction could not be rolled back: %s", rollbackErr.Error())
+		} else {
+			diagnostics += ".  The transaction was rolled back."
+		}
+	}
+	c.JSON(status, models.NewOperationOutcome("error", issueTypeForStatus(status), diagnostics))
+	c.Abort()
+}
+
+// processEntry makes the change requested by the entry in the database and replaces the entry's request with the
+// response.  The newID is the ID assigned to the entry (for POSTs), and existing indicates if a conditional create
+// matched an existing resource.  The config is the server's Config.  If the change fails, the HTTP status describing
+// the failure is returned with the error.
+func (b *BatchController) processEntry(request *http.Request, config Config, dal DataAccessLayer, entry *models.BundleEntryComponent, newID string, existing bool) (int, error) {
+	switch entry.Request.Method {
+	case "DELETE":
+		if !isConditional(entry) {
+			// It's a normal DELETE
+			parts := strings.SplitN(entry.Request.Url, "/", 2)
+			if len(parts) != 2 {
+				return http.StatusBadRequest, fmt.Errorf("Couldn't identify resource and id to delete from %s", entry.Request.Url)
 			}
-		case "POST":
-			if err := b.DAL.PostWithID(newIDs[i], entry.Resource); err != nil {
-				c.AbortWithError(http.StatusInternalServerError, err)
-				return
+			var err error
+			if entry.Request.IfMatch != "" {
+				err = dal.DeleteIfMatch(parts[1], parseETag(entry.Request.IfMatch), parts[0])
+			} else {
+				err = dal.Delete(parts[1], parts[0])
 			}
-			entry.Request = nil
-			entry.Response = &models.BundleEntryResponseComponent{
-				Status:   "201",
-				Location: entry.FullUrl,
+			if err != nil && err != ErrNotFound {
+				return statusForError(err), err
 			}
-			if meta, ok := models.GetResourceMeta(entry.Resource); ok {
-				entry.Response.LastModified = meta.LastUpdated
+		} else {
+			// It's a conditional (query-based) delete
+			parts := strings.SplitN(entry.Request.Url, "?", 2)
+			query := search.Query{Resource: parts[0], Query: parts[1]}
+			if _, err := dal.ConditionalDelete(query); err != nil {
+				return statusForError(err), err
 			}
-		case "PUT":
-			// Because we pre-process conditional PUTs, we know this is always a normal PUT operation
-			entry.FullUrl = responseURL(c.Request, entry.Request.Url).String()
-			parts := strings.SplitN(entry.Request.Url, "/", 2)
-			if len(parts) != 2 {
-				c.AbortWithError(http.StatusInternalServerError,
-					fmt.Errorf("Couldn't identify resource and id to put from %s", entry.Request.Url))
-				return
+		}
+
+		entry.Request = nil
+		entry.Response = &models.BundleEntryResponseComponent{
+			Status: "204",
+		}
+	case "POST":
+		status := "201"
+		if existing {
+			// It's a conditional create that matched an existing resource, so return that resource instead
+			resource, err := dal.Get(newID, entry.Request.Url)
+			if err != nil {
+				return statusForError(err), err
 			}
-			createdNew, err := b.DAL.Put(parts[1], entry.Resource)
+			entry.Resource = resource
+			status = "200"
+		} else if err := dal.PostWithID(newID, entry.Resource); err != nil {
+			return statusForError(err), err
+		}
+		entry.Request = nil
+		entry.Response = &models.BundleEntryResponseComponent{
+			Status:   status,
+			Location: entry.FullUrl,
+		}
+		if meta, ok := models.GetResourceMeta(entry.Resource); ok {
+			entry.Response.LastModified = meta.LastUpdated
+			entry.Response.Etag = weakETag(meta.VersionId)
+		}
+	case "PUT":
+		// Because we pre-process conditional PUTs, we know this is always a normal PUT operation
+		entry.FullUrl = responseURL(request, entry.Request.Url).String()
+		parts := strings.SplitN(entry.Request.Url, "/", 2)
+		if len(parts) != 2 {
+			return http.StatusBadRequest, fmt.Errorf("Couldn't identify resource and id to put from %s", entry.Request.Url)
+		}
+		var createdNew bool
+		var err error
+		if entry.Request.IfMatch != "" {
+			err = dal.PutIfMatch(parts[1], parseETag(entry.Request.IfMatch), entry.Resource)
+		} else {
+			createdNew, err = dal.Put(parts[1], entry.Resource)
+		}
+		if err != nil {
+			return statusForError(err), err
+		}
+		entry.Request = nil
+		entry.Response = new(models.BundleEntryResponseComponent)
+		entry.Response.Location = entry.FullUrl
+		if createdNew {
+			entry.Response.Status = "201"
+		} else {
+			entry.Response.Status = "200"
+		}
+		if meta, ok := models.GetResourceMeta(entry.Resource); ok {
+			entry.Response.LastModified = meta.LastUpdated
+			entry.Response.Etag = weakETag(meta.VersionId)
+		}
+	case "PATCH":
+		patch, err := parsePatchResource(entry.Resource)
+		if err != nil {
+			return statusForError(err), err
+		}
+		parts := strings.SplitN(entry.Request.Url, "?", 2)
+		resourceType, id := parts[0], ""
+		if len(parts) == 2 {
+			// It's a conditional patch, so find the one resource it applies to
+			IDs, err := dal.FindIDs(search.Query{Resource: parts[0], Query: parts[1]})
 			if err != nil {
-				c.AbortWithError(http.StatusInternalServerError, err)
-				return
+				return statusForError(err), err
 			}
-			entry.Request = nil
-			entry.Response = new(models.BundleEntryResponseComponent)
-			entry.Response.Location = entry.FullUrl
-			if createdNew {
-				entry.Response.Status = "201"
-			} else {
-				entry.Response.Status = "200"
+			switch len(IDs) {
+			case 0:
+				return http.StatusNotFound, ErrNotFound
+			case 1:
+				id = IDs[0]
+			default:
+				return http.StatusPreconditionFailed, ErrMultipleMatches
 			}
-			if meta, ok := models.GetResourceMeta(entry.Resource); ok {
-				entry.Response.LastModified = meta.LastUpdated
+		} else if parts = strings.SplitN(parts[0], "/", 2); len(parts) == 2 {
+			resourceType, id = parts[0], parts[1]
+		} else {
+			return http.StatusBadRequest, fmt.Errorf("Couldn't identify resource and id to patch from %s", entry.Request.Url)
+		}
+
+		resource, err := patchResource(dal, config, resourceType, id, entry.Request.IfMatch, patch)
+		if err != nil {
+			return statusForError(err), err
+		}
+		entry.FullUrl = responseURL(request, resourceType, id).String()
+		entry.Resource = resource
+		entry.Request = nil
+		entry.Response = &models.BundleEntryResponseComponent{
+			Status:   "200",
+			Location: entry.FullUrl,
+		}
+		if meta, ok := models.GetResourceMeta(resource); ok {
+			entry.Response.LastModified = meta.LastUpdated
+			entry.Response.Etag = weakETag(meta.VersionId)
+		}
+	case "GET":
+		// Since entries are sorted by method, reads happen after all of the bundle's changes have been made
+		if status, err := b.readEntry(request, dal, entry); err != nil {
+			return status, err
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// readEntry performs the read, vread, history, or search requested by a GET entry and places the result in the
+// entry's resource.  If it fails, the HTTP status describing the failure is returned with the error.
+func (b *BatchController) readEntry(request *http.Request, dal DataAccessLayer, entry *models.BundleEntryComponent) (status int, err error) {
+	// Search errors are reported by panicking, so recover them the same way the IndexHandler does
+	defer func() {
+		if r := recover(); r != nil {
+			switch x := r.(type) {
+			case *search.Error:
+				status, err = x.HTTPStatus, x.OperationOutcome
+			default:
+				status, err = http.StatusInternalServerError, fmt.Errorf("%v", r)
 			}
 		}
+	}()
+
+	parts := strings.SplitN(entry.Request.Url, "?", 2)
+	path := strings.Split(strings.Trim(parts[0], "/"), "/")
+	var query string
+	if len(parts) == 2 {
+		query = parts[1]
 	}
 
-	total := uint32(len(entries))
-	bundle.Total = &total
-	bundle.Type = fmt.Sprintf("%s-response", bundle.Type)
+	var result interface{}
+	var isInstance bool
+	switch {
+	case len(path) == 1:
+		result, err = dal.Search(*responseURL(request, path[0]), search.Query{Resource: path[0], Query: query})
+	case len(path) == 2 && path[1] == "_history":
+		result, err = dal.History(*responseURL(request, path[0], "_history"), HistoryQuery{Resource: path[0], Query: query})
+	case len(path) == 2:
+		entry.FullUrl = responseURL(request, path[0], path[1]).String()
+		isInstance = true
+		result, err = dal.Get(path[1], path[0])
+	case len(path) == 3 && path[2] == "_history":
+		result, err = dal.History(*responseURL(request, path[0], path[1], "_history"), HistoryQuery{Resource: path[0], ID: path[1], Query: query})
+	case len(path) == 4 && path[2] == "_history":
+		entry.FullUrl = responseURL(request, path[0], path[1]).String()
+		isInstance = true
+		result, err = dal.GetVersion(path[1], path[3], path[0])
+	default:
+		return http.StatusBadRequest, fmt.Errorf("Couldn't identify resource to get from %s", entry.Request.Url)
+	}
+	if err != nil {
+		return statusForError(err), err
+	}
 
-	c.Set("Bundle", bundle)
-	c.Set("Resource", "Bundle")
-	c.Set("Action", "batch")
+	entry.Resource = result
+	entry.Request = nil
+	entry.Response = &models.BundleEntryResponseComponent{
+		Status: "200",
+	}
+	if meta, ok := models.GetResourceMeta(result); ok && meta != nil && isInstance {
+		entry.Response.LastModified = meta.LastUpdated
+		entry.Response.Etag = weakETag(meta.VersionId)
+	}
+	return http.StatusOK, nil
+}
 
-	// Send the response
+// statusForError returns the HTTP status corresponding to an error returned by the DataAccessLayer
+func statusForError(err error) int {
+	switch err {
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrDeleted:
+		return http.StatusGone
+	case ErrPreconditionFailed, ErrMultipleMatches:
+		return http.StatusPreconditionFailed
+	case ErrConflict:
+		return http.StatusConflict
+	}
+	if _, ok := err.(*models.OperationOutcome); ok {
+		return http.StatusBadRequest
+	} else if pe, ok := err.(*PatchError); ok {
+		return pe.HTTPStatus
+	} else if oe, ok := err.(*OperationError); ok {
+		return oe.HTTPStatus
+	} else if se, ok := err.(*search.Error); ok {
+		return se.HTTPStatus
+	}
+	return http.StatusInternalServerError
+}
 
-	c.Header("Access-Control-Allow-Origin", "*")
-	c.JSON(http.StatusOK, bundle)
+// issueTypeForStatus returns the OperationOutcome issue type best describing the HTTP status of a failure
+func issueTypeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid"
+	case http.StatusNotFound, http.StatusGone:
+		return "not-found"
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return "conflict"
+	case http.StatusUnprocessableEntity:
+		return "processing"
+	case http.StatusNotImplemented, http.StatusMethodNotAllowed:
+		return "not-supported"
+	}
+	return "exception"
 }
 
 func (b *BatchController) resolveConditionalPut(request *http.Request, entryIndex int, entry *models.BundleEntryComponent, newIDs []string, refMap map[string]models.Reference) error {
@@ -225,7 +567,9 @@ func (b *BatchController) resolveConditionalPut(request *http.Request, entryInde
 	if IDs, err := b.DAL.FindIDs(query); err == nil {
 		switch len(IDs) {
 		case 0:
-			id = bson.NewObjectId().Hex()
+			if id, err = b.DAL.NewID(query.Resource); err != nil {
+				return err
+			}
 		case 1:
 			id = IDs[0]
 		default:
@@ -240,11 +584,13 @@ func (b *BatchController) resolveConditionalPut(request *http.Request, entryInde
 
 	// Add the new ID to the reference map
 	newIDs[entryIndex] = id
-	refMap[entry.FullUrl] = models.Reference{
-		Reference:    entry.Request.Url,
-		Type:         query.Resource,
-		ReferencedID: id,
-		External:     new(bool),
+	for _, key := range referenceKeys(entry.FullUrl) {
+		refMap[key] = models.Reference{
+			Reference:    entry.Request.Url,
+			Type:         query.Resource,
+			ReferencedID: id,
+			External:     new(bool),
+		}
 	}
 
 	// Rewrite the FullUrl using the new ID
@@ -253,6 +599,25 @@ func (b *BatchController) resolveConditionalPut(request *http.Request, entryInde
 	return nil
 }
 
+// restfulURLPattern matches the resource type and ID at the end of a RESTful URL (e.g., http://example.org/Patient/123)
+var restfulURLPattern = regexp.MustCompile(`(?:^|/)([A-Z][A-Za-z]+)/([A-Za-z0-9\-\.]{1,64})$`)
+
+// referenceKeys returns the references that identify the entry with the given fullUrl: the fullUrl itself and, if it
+// is a RESTful URL, the equivalent relative reference (e.g., Patient/123), since references within a bundle are
+// resolved relative to the bundle's base.
+func referenceKeys(fullURL string) []string {
+	if fullURL == "" {
+		return nil
+	}
+	keys := []string{fullURL}
+	if m := restfulURLPattern.FindStringSubmatch(fullURL); m != nil && models.StructForResourceName(m[1]) != nil {
+		if relative := m[1] + "/" + m[2]; relative != fullURL {
+			keys = append(keys, relative)
+		}
+	}
+	return keys
+}
+
 func updateAllReferences(entries []*models.BundleEntryComponent, refMap map[string]models.Reference) {
 	// First, get all the references by reflecting through the fields of each model
 	var refs []*models.Reference
@@ -322,6 +687,6 @@ func (e byRequestMethod) Swap(i, j int) {
 	e[i], e[j] = e[j], e[i]
 }
 func (e byRequestMethod) Less(i, j int) bool {
-	methodMap := map[string]int{"DELETE": 0, "POST": 1, "PUT": 2, "GET": 3}
+	methodMap := map[string]int{"DELETE": 0, "POST": 1, "PUT": 2, "PATCH": 2, "GET": 3}
 	return methodMap[e[i].Request.Method] < methodMap[e[j].Request.Method]
 }
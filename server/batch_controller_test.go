@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -40,7 +41,7 @@ func (s *BatchControllerSuite) SetUpSuite(c *C) {
 
 	// Build routes for testing
 	s.Engine = gin.New()
-	RegisterRoutes(s.Engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.Database), Config{})
+	RegisterServerRoutes(s.Engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.Database), Config{})
 
 	// Create httptest server
 	s.Server = httptest.NewServer(s.Engine)
@@ -145,7 +146,7 @@ func (s *BatchControllerSuite) TestDeleteEntriesBundle(c *C) {
 		// Everything else in the response should be nil / zero value
 		c.Assert(entry.Response.LastModified, IsNil)
 		c.Assert(entry.Response.Location, Equals, "")
-		c.Assert(entry.Response.Etag, Equals, "") // Since we don't support versioning
+		c.Assert(entry.Response.Etag, Equals, "") // Since deletions do not produce a resource version
 	}
 
 	// Now check that the first condition and both encounters were deleted (leaving the 2nd condition)
@@ -247,7 +248,7 @@ func (s *BatchControllerSuite) TestConditionalDeleteEntriesBundle(c *C) {
 	// Everything else in the response should be nil / zero value
 	c.Assert(entry.Response.LastModified, IsNil)
 	c.Assert(entry.Response.Location, Equals, "")
-	c.Assert(entry.Response.Etag, Equals, "") // Since we don't support versioning
+	c.Assert(entry.Response.Etag, Equals, "") // Since deletions do not produce a resource version
 
 	// Now check that the right encounters were deleted
 	count, err = encCollection.FindId("56afe6b85cdc7ec329dfe6b1").Count()
@@ -665,7 +666,7 @@ func (s *BatchControllerSuite) TestAllSupportedMethodsBundle(c *C) {
 		// Everything else in the response should be nil / zero value
 		c.Assert(entry.Response.LastModified, IsNil)
 		c.Assert(entry.Response.Location, Equals, "")
-		c.Assert(entry.Response.Etag, Equals, "") // Since we don't support versioning
+		c.Assert(entry.Response.Etag, Equals, "") // Since deletions do not produce a resource version
 	}
 
 	count, err = condCollection.FindId("56afe6b85cdc7ec329dfe6a5").Count()
@@ -759,6 +760,495 @@ func (s *BatchControllerSuite) TestAllSupportedMethodsBundle(c *C) {
 	s.checkReference(c, responseBundle.Entry[4].Resource.(*models.Condition).Patient, patientID, "Patient")
 }
 
+func (s *BatchControllerSuite) TestPutEntryIfMatch(c *C) {
+	// Create a patient so there is a version to match against
+	dal := NewMongoDataAccessLayer(s.Database)
+	patient := &models.Patient{Gender: "male"}
+	util.CheckErr(dal.PostWithID("56afe6b85cdc7ec329dfe6a9", patient))
+
+	bundle := &models.Bundle{
+		Type: "transaction",
+		Entry: []models.BundleEntryComponent{
+			{
+				Resource: &models.Patient{Gender: "female"},
+				Request: &models.BundleEntryRequestComponent{
+					Method:  "PUT",
+					Url:     "Patient/56afe6b85cdc7ec329dfe6a9",
+					IfMatch: "W/\"2\"",
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(bundle)
+	util.CheckErr(err)
+
+	// A stale version should fail the precondition
+	res, err := http.Post(s.Server.URL+"/", "application/json", bytes.NewReader(data))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusPreconditionFailed)
+
+	// The current version should succeed
+	bundle.Entry[0].Request.IfMatch = "W/\"1\""
+	data, err = json.Marshal(bundle)
+	util.CheckErr(err)
+	res, err = http.Post(s.Server.URL+"/", "application/json", bytes.NewReader(data))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+
+	responseBundle := &models.Bundle{}
+	err = json.NewDecoder(res.Body).Decode(responseBundle)
+	util.CheckErr(err)
+	c.Assert(responseBundle.Entry, HasLen, 1)
+	c.Assert(responseBundle.Entry[0].Response.Status, Equals, "200")
+	c.Assert(responseBundle.Entry[0].Response.Etag, Equals, "W/\"2\"")
+
+	updated := &models.Patient{}
+	err = s.Database.C("patients").FindId("56afe6b85cdc7ec329dfe6a9").One(updated)
+	util.CheckErr(err)
+	c.Assert(updated.Gender, Equals, "female")
+}
+
+func (s *BatchControllerSuite) TestConditionalCreateEntry(c *C) {
+	bundle := &models.Bundle{
+		Type: "batch",
+		Entry: []models.BundleEntryComponent{
+			{
+				FullUrl: "urn:uuid:61ebe359-bfdc-4613-8bf2-c5e300945f0a",
+				Resource: &models.Patient{
+					Identifier: []models.Identifier{{System: "http://example.org/mrn", Value: "12345"}},
+				},
+				Request: &models.BundleEntryRequestComponent{
+					Method:      "POST",
+					Url:         "Patient",
+					IfNoneExist: "identifier=http://example.org/mrn|12345",
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(bundle)
+	util.CheckErr(err)
+
+	// The first time, the patient should be created
+	res, err := http.Post(s.Server.URL+"/", "application/json", bytes.NewReader(data))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	responseBundle := &models.Bundle{}
+	err = json.NewDecoder(res.Body).Decode(responseBundle)
+	util.CheckErr(err)
+	c.Assert(responseBundle.Entry, HasLen, 1)
+	c.Assert(responseBundle.Entry[0].Response.Status, Equals, "201")
+	createdID := s.getResourceID(responseBundle.Entry[0])
+
+	// The second time, the existing patient should be returned
+	res, err = http.Post(s.Server.URL+"/", "application/json", bytes.NewReader(data))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	responseBundle = &models.Bundle{}
+	err = json.NewDecoder(res.Body).Decode(responseBundle)
+	util.CheckErr(err)
+	c.Assert(responseBundle.Entry, HasLen, 1)
+	c.Assert(responseBundle.Entry[0].Response.Status, Equals, "200")
+	c.Assert(s.getResourceID(responseBundle.Entry[0]), Equals, createdID)
+	c.Assert(responseBundle.Entry[0].Response.Location, Equals, s.Server.URL+"/Patient/"+createdID)
+
+	count, err := s.Database.C("patients").Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 1)
+}
+
+func (s *BatchControllerSuite) TestConditionalCreateEntryInvalidCriteria(c *C) {
+	bundle := &models.Bundle{
+		Type: "batch",
+		Entry: []models.BundleEntryComponent{
+			{
+				Resource: &models.Patient{},
+				Request: &models.BundleEntryRequestComponent{
+					Method:      "POST",
+					Url:         "Patient",
+					IfNoneExist: "foo=bar",
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(bundle)
+	util.CheckErr(err)
+
+	res, err := http.Post(s.Server.URL+"/", "application/json", bytes.NewReader(data))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	responseBundle := &models.Bundle{}
+	err = json.NewDecoder(res.Body).Decode(responseBundle)
+	util.CheckErr(err)
+	c.Assert(responseBundle.Entry, HasLen, 1)
+	c.Assert(responseBundle.Entry[0].Response.Status, Equals, "400")
+	outcome, ok := responseBundle.Entry[0].Resource.(*models.OperationOutcome)
+	c.Assert(ok, Equals, true)
+	c.Assert(outcome.Issue, HasLen, 1)
+
+	count, err := s.Database.C("patients").Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 0)
+}
+
+func (s *BatchControllerSuite) TestClientAssignedIDsAndRESTfulFullUrls(c *C) {
+	bundle := &models.Bundle{
+		Type: "transaction",
+		Entry: []models.BundleEntryComponent{
+			{
+				FullUrl:  "http://example.org/fhir/Patient/patient-123",
+				Resource: &models.Patient{Gender: "male"},
+				Request:  &models.BundleEntryRequestComponent{Method: "POST", Url: "Patient"},
+			},
+			{
+				Resource: &models.Condition{Patient: &models.Reference{Reference: "Patient/patient-123"}},
+				Request:  &models.BundleEntryRequestComponent{Method: "PUT", Url: "Condition/condition.1"},
+			},
+			{
+				Request: &models.BundleEntryRequestComponent{Method: "GET", Url: "Condition?patient=Patient/patient-123"},
+			},
+		},
+	}
+	data, err := json.Marshal(bundle)
+	util.CheckErr(err)
+
+	res, err := http.Post(s.Server.URL+"/", "application/json", bytes.NewReader(data))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	responseBundle := &models.Bundle{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(responseBundle))
+	c.Assert(responseBundle.Entry, HasLen, 3)
+	patientID := s.getResourceID(responseBundle.Entry[0])
+
+	// The relative reference to the patient's fullUrl is rewritten, in the condition and in the search
+	condition := &models.Condition{}
+	util.CheckErr(s.Database.C("conditions").FindId("condition.1").One(condition))
+	c.Assert(condition.Patient.Reference, Equals, "Patient/"+patientID)
+	searchBundle := responseBundle.Entry[2].Resource.(*models.Bundle)
+	c.Assert(*searchBundle.Total, Equals, uint32(1))
+}
+
+func (s *BatchControllerSuite) TestTransactionRollback(c *C) {
+	// Create some patients to update and delete
+	dal := NewMongoDataAccessLayer(s.Database)
+	util.CheckErr(dal.PostWithID("56afe6b85cdc7ec329dfe6b1", &models.Patient{Gender: "male"}))
+	util.CheckErr(dal.PostWithID("56afe6b85cdc7ec329dfe6b2", &models.Patient{Gender: "male"}))
+	util.CheckErr(dal.PostWithID("56afe6b85cdc7ec329dfe6b3", &models.Patient{Gender: "male"}))
+
+	bundle := &models.Bundle{
+		Type: "transaction",
+		Entry: []models.BundleEntryComponent{
+			{
+				FullUrl:  "urn:uuid:8f4a2a58-6a8b-4d0a-9b1e-6a3c3f0d2c11",
+				Resource: &models.Patient{Gender: "female"},
+				Request:  &models.BundleEntryRequestComponent{Method: "POST", Url: "Patient"},
+			},
+			{
+				Resource: &models.Patient{Gender: "female"},
+				Request:  &models.BundleEntryRequestComponent{Method: "PUT", Url: "Patient/56afe6b85cdc7ec329dfe6b1"},
+			},
+			{
+				Request: &models.BundleEntryRequestComponent{Method: "DELETE", Url: "Patient/56afe6b85cdc7ec329dfe6b2"},
+			},
+			{
+				// This entry fails, so the whole transaction should be rolled back
+				Resource: &models.Patient{Gender: "female"},
+				Request: &models.BundleEntryRequestComponent{
+					Method:  "PUT",
+					Url:     "Patient/56afe6b85cdc7ec329dfe6b3",
+					IfMatch: "W/\"5\"",
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(bundle)
+	util.CheckErr(err)
+
+	res, err := http.Post(s.Server.URL+"/", "application/json", bytes.NewReader(data))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusPreconditionFailed)
+	outcome := &models.OperationOutcome{}
+	err = json.NewDecoder(res.Body).Decode(outcome)
+	util.CheckErr(err)
+	c.Assert(outcome.Issue, HasLen, 1)
+	c.Assert(outcome.Issue[0].Code, Equals, "conflict")
+	c.Assert(strings.HasPrefix(outcome.Issue[0].Diagnostics, "Bundle.entry[3] (PUT Patient/56afe6b85cdc7ec329dfe6b3) failed"), Equals, true)
+
+	// Everything should be back the way it was
+	patients := s.Database.C("patients")
+	count, err := patients.Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 3)
+	for _, id := range []string{"56afe6b85cdc7ec329dfe6b1", "56afe6b85cdc7ec329dfe6b2", "56afe6b85cdc7ec329dfe6b3"} {
+		patient := &models.Patient{}
+		err = patients.FindId(id).One(patient)
+		util.CheckErr(err)
+		c.Assert(patient.Gender, Equals, "male")
+		c.Assert(patient.Meta.VersionId, Equals, "1")
+	}
+	count, err = s.Database.C("history").Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 3)
+}
+
+func (s *BatchControllerSuite) TestTransactionRollbackWhenRecordingVersionFails(c *C) {
+	dal := NewMongoDataAccessLayer(s.Database)
+	util.CheckErr(dal.PostWithID("56afe6b85cdc7ec329dfe6b1", &models.Patient{Gender: "male"}))
+
+	// A conflicting version in the history makes recording the update's version fail after the patient is updated
+	history := s.Database.C("history")
+	util.CheckErr(history.EnsureIndex(mgo.Index{Key: []string{"resourceType", "resourceId", "versionId"}, Unique: true}))
+	util.CheckErr(history.Insert(&historyEntry{
+		ID:           bson.NewObjectId(),
+		ResourceType: "Patient",
+		ResourceID:   "56afe6b85cdc7ec329dfe6b1",
+		VersionID:    "2",
+		Method:       "PUT",
+	}))
+
+	t := dal.StartTransaction()
+	_, err := t.Put("56afe6b85cdc7ec329dfe6b1", &models.Patient{Gender: "female"})
+	c.Assert(err, NotNil)
+	util.CheckErr(t.Rollback())
+
+	patient := &models.Patient{}
+	util.CheckErr(s.Database.C("patients").FindId("56afe6b85cdc7ec329dfe6b1").One(patient))
+	c.Assert(patient.Gender, Equals, "male")
+	c.Assert(patient.Meta.VersionId, Equals, "1")
+}
+
+func (s *BatchControllerSuite) TestTransactionCommit(c *C) {
+	dal := NewMongoDataAccessLayer(s.Database)
+	util.CheckErr(dal.PostWithID("56afe6b85cdc7ec329dfe6b1", &models.Patient{Gender: "male"}))
+
+	bundle := &models.Bundle{
+		Type: "transaction",
+		Entry: []models.BundleEntryComponent{
+			{
+				FullUrl:  "urn:uuid:8f4a2a58-6a8b-4d0a-9b1e-6a3c3f0d2c11",
+				Resource: &models.Patient{Gender: "female"},
+				Request:  &models.BundleEntryRequestComponent{Method: "POST", Url: "Patient"},
+			},
+			{
+				Resource: &models.Patient{Gender: "female"},
+				Request:  &models.BundleEntryRequestComponent{Method: "PUT", Url: "Patient/56afe6b85cdc7ec329dfe6b1"},
+			},
+		},
+	}
+	data, err := json.Marshal(bundle)
+	util.CheckErr(err)
+
+	res, err := http.Post(s.Server.URL+"/", "application/json", bytes.NewReader(data))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	responseBundle := &models.Bundle{}
+	err = json.NewDecoder(res.Body).Decode(responseBundle)
+	util.CheckErr(err)
+	c.Assert(responseBundle.Type, Equals, "transaction-response")
+
+	count, err := s.Database.C("patients").Find(bson.M{"gender": "female"}).Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 2)
+}
+
+func (s *BatchControllerSuite) TestBatchEntryFailures(c *C) {
+	dal := NewMongoDataAccessLayer(s.Database)
+	util.CheckErr(dal.PostWithID("56afe6b85cdc7ec329dfe6b1", &models.Patient{Gender: "male"}))
+	util.CheckErr(dal.PostWithID("56afe6b85cdc7ec329dfe6b2", &models.Patient{Gender: "male"}))
+
+	bundle := &models.Bundle{
+		Type: "batch",
+		Entry: []models.BundleEntryComponent{
+			{
+				FullUrl:  "urn:uuid:8f4a2a58-6a8b-4d0a-9b1e-6a3c3f0d2c11",
+				Resource: &models.Patient{Gender: "female"},
+				Request:  &models.BundleEntryRequestComponent{Method: "POST", Url: "Patient"},
+			},
+			{
+				// Stale version
+				Resource: &models.Patient{Gender: "female"},
+				Request: &models.BundleEntryRequestComponent{
+					Method:  "PUT",
+					Url:     "Patient/56afe6b85cdc7ec329dfe6b1",
+					IfMatch: "W/\"5\"",
+				},
+			},
+			{
+				// No request
+				Resource: &models.Patient{Gender: "female"},
+			},
+			{
+				// No resource
+				Request: &models.BundleEntryRequestComponent{Method: "PUT", Url: "Patient/56afe6b85cdc7ec329dfe6b1"},
+			},
+			{
+				Request: &models.BundleEntryRequestComponent{Method: "DELETE", Url: "Patient/56afe6b85cdc7ec329dfe6b2"},
+			},
+		},
+	}
+	data, err := json.Marshal(bundle)
+	util.CheckErr(err)
+
+	res, err := http.Post(s.Server.URL+"/", "application/json", bytes.NewReader(data))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	responseBundle := &models.Bundle{}
+	err = json.NewDecoder(res.Body).Decode(responseBundle)
+	util.CheckErr(err)
+	c.Assert(responseBundle.Type, Equals, "batch-response")
+	c.Assert(responseBundle.Entry, HasLen, 5)
+
+	// The failed entries should each have their own status and outcome
+	for i, status := range []string{"201", "412", "400", "400", "204"} {
+		c.Assert(responseBundle.Entry[i].Request, IsNil)
+		c.Assert(responseBundle.Entry[i].Response.Status, Equals, status)
+	}
+	for _, i := range []int{1, 2, 3} {
+		outcome, ok := responseBundle.Entry[i].Resource.(*models.OperationOutcome)
+		c.Assert(ok, Equals, true)
+		c.Assert(outcome.Issue, HasLen, 1)
+	}
+	c.Assert(responseBundle.Entry[1].Resource.(*models.OperationOutcome).Issue[0].Code, Equals, "conflict")
+	c.Assert(responseBundle.Entry[2].Resource.(*models.OperationOutcome).Issue[0].Diagnostics, Equals, "Entries in a batch operation require a request")
+
+	// The successful entries should have been processed
+	patients := s.Database.C("patients")
+	count, err := patients.Find(bson.M{"gender": "female"}).Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 1)
+	count, err = patients.FindId("56afe6b85cdc7ec329dfe6b2").Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 0)
+	unchanged := &models.Patient{}
+	err = patients.FindId("56afe6b85cdc7ec329dfe6b1").One(unchanged)
+	util.CheckErr(err)
+	c.Assert(unchanged.Gender, Equals, "male")
+}
+
+func (s *BatchControllerSuite) TestGetEntries(c *C) {
+	dal := NewMongoDataAccessLayer(s.Database)
+	util.CheckErr(dal.PostWithID("56afe6b85cdc7ec329dfe6b1", &models.Patient{Gender: "male"}))
+	util.CheckErr(dal.PostWithID("56afe6b85cdc7ec329dfe6c1", &models.Condition{
+		Patient:            &models.Reference{Reference: "Patient/56afe6b85cdc7ec329dfe6b1"},
+		VerificationStatus: "confirmed",
+	}))
+
+	bundle := &models.Bundle{
+		Type: "batch",
+		Entry: []models.BundleEntryComponent{
+			{
+				Request: &models.BundleEntryRequestComponent{Method: "GET", Url: "Patient/56afe6b85cdc7ec329dfe6b1"},
+			},
+			{
+				Request: &models.BundleEntryRequestComponent{Method: "GET", Url: "Condition?patient=56afe6b85cdc7ec329dfe6b1"},
+			},
+			{
+				Request: &models.BundleEntryRequestComponent{Method: "GET", Url: "Patient/56afe6b85cdc7ec329dfe6b1/_history/1"},
+			},
+			{
+				Request: &models.BundleEntryRequestComponent{Method: "GET", Url: "Patient/56afe6b85cdc7ec329dfe6b9"},
+			},
+		},
+	}
+	data, err := json.Marshal(bundle)
+	util.CheckErr(err)
+
+	res, err := http.Post(s.Server.URL+"/", "application/json", bytes.NewReader(data))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	responseBundle := &models.Bundle{}
+	err = json.NewDecoder(res.Body).Decode(responseBundle)
+	util.CheckErr(err)
+	c.Assert(responseBundle.Entry, HasLen, 4)
+
+	// Read
+	c.Assert(responseBundle.Entry[0].Response.Status, Equals, "200")
+	c.Assert(responseBundle.Entry[0].Response.Etag, Equals, "W/\"1\"")
+	c.Assert(responseBundle.Entry[0].FullUrl, Equals, s.Server.URL+"/Patient/56afe6b85cdc7ec329dfe6b1")
+	patient, ok := responseBundle.Entry[0].Resource.(*models.Patient)
+	c.Assert(ok, Equals, true)
+	c.Assert(patient.Gender, Equals, "male")
+
+	// Search
+	c.Assert(responseBundle.Entry[1].Response.Status, Equals, "200")
+	searchBundle, ok := responseBundle.Entry[1].Resource.(*models.Bundle)
+	c.Assert(ok, Equals, true)
+	c.Assert(searchBundle.Type, Equals, "searchset")
+	c.Assert(*searchBundle.Total, Equals, uint32(1))
+	c.Assert(s.getResourceID(searchBundle.Entry[0]), Equals, "56afe6b85cdc7ec329dfe6c1")
+
+	// VRead
+	c.Assert(responseBundle.Entry[2].Response.Status, Equals, "200")
+	c.Assert(s.getResourceID(responseBundle.Entry[2]), Equals, "56afe6b85cdc7ec329dfe6b1")
+
+	// Not found
+	c.Assert(responseBundle.Entry[3].Response.Status, Equals, "404")
+	_, ok = responseBundle.Entry[3].Resource.(*models.OperationOutcome)
+	c.Assert(ok, Equals, true)
+}
+
+func (s *BatchControllerSuite) TestPatchEntries(c *C) {
+	dal := NewMongoDataAccessLayer(s.Database)
+	util.CheckErr(dal.PostWithID("56afe6b85cdc7ec329dfe6d1", &models.Encounter{Status: "in-progress"}))
+	util.CheckErr(dal.PostWithID("56afe6b85cdc7ec329dfe6d2", &models.Encounter{Status: "in-progress"}))
+
+	operation := models.ParametersParameterComponent{
+		Name: "operation",
+		Part: []models.ParametersParameterComponent{
+			{Name: "type", ValueCode: "replace"},
+			{Name: "path", ValueString: "Encounter.status"},
+			{Name: "value", ValueCode: "cancelled"},
+		},
+	}
+	bundle := &models.Bundle{
+		Type: "batch",
+		Entry: []models.BundleEntryComponent{
+			{
+				Resource: &models.Binary{
+					ContentType: "application/json-patch+json",
+					Content:     base64.StdEncoding.EncodeToString([]byte(`[{"op": "replace", "path": "/status", "value": "finished"}]`)),
+				},
+				Request: &models.BundleEntryRequestComponent{Method: "PATCH", Url: "Encounter/56afe6b85cdc7ec329dfe6d1"},
+			},
+			{
+				Resource: &models.Parameters{Parameter: []models.ParametersParameterComponent{operation}},
+				Request:  &models.BundleEntryRequestComponent{Method: "PATCH", Url: "Encounter/56afe6b85cdc7ec329dfe6d2"},
+			},
+			{
+				// Stale version
+				Resource: &models.Parameters{Parameter: []models.ParametersParameterComponent{operation}},
+				Request: &models.BundleEntryRequestComponent{
+					Method:  "PATCH",
+					Url:     "Encounter/56afe6b85cdc7ec329dfe6d1",
+					IfMatch: "W/\"5\"",
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(bundle)
+	util.CheckErr(err)
+
+	res, err := http.Post(s.Server.URL+"/", "application/json", bytes.NewReader(data))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	responseBundle := &models.Bundle{}
+	err = json.NewDecoder(res.Body).Decode(responseBundle)
+	util.CheckErr(err)
+	c.Assert(responseBundle.Entry, HasLen, 3)
+	c.Assert(responseBundle.Entry[0].Response.Status, Equals, "200")
+	c.Assert(responseBundle.Entry[0].Response.Etag, Equals, "W/\"2\"")
+	c.Assert(responseBundle.Entry[0].Resource.(*models.Encounter).Status, Equals, "finished")
+	c.Assert(responseBundle.Entry[1].Response.Status, Equals, "200")
+	c.Assert(responseBundle.Entry[2].Response.Status, Equals, "412")
+
+	encounters := s.Database.C("encounters")
+	enc1 := &models.Encounter{}
+	util.CheckErr(encounters.FindId("56afe6b85cdc7ec329dfe6d1").One(enc1))
+	c.Assert(enc1.Status, Equals, "finished")
+	enc2 := &models.Encounter{}
+	util.CheckErr(encounters.FindId("56afe6b85cdc7ec329dfe6d2").One(enc2))
+	c.Assert(enc2.Status, Equals, "cancelled")
+}
+
 func (s *BatchControllerSuite) checkReference(c *C, ref *models.Reference, id string, typ string) {
 	c.Assert(ref.ReferencedID, Equals, id)
 	c.Assert(ref.Type, Equals, typ)
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -16,5 +18,17 @@ func FHIRBind(c *gin.Context, obj interface{}) error {
 		return c.BindJSON(obj)
 	}
 
+	if strings.Contains(c.ContentType(), "xml") {
+		data, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			return err
+		}
+		jsonData, err := ConvertXMLToJSON(data)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(jsonData, obj)
+	}
+
 	return c.Bind(obj)
 }
@@ -17,15 +17,19 @@ type BindSuite struct {
 var _ = Suite(&BindSuite{})
 
 func (b *BindSuite) TestJSONBinding(c *C) {
-	testBinding(c, "application/json")
+	testBinding(c, "../fixtures/condition.json", "application/json")
 }
 
 func (b *BindSuite) TestJSONFHIRBinding(c *C) {
-	testBinding(c, "application/json+fhir")
+	testBinding(c, "../fixtures/condition.json", "application/json+fhir")
 }
 
-func testBinding(c *C, contentType string) {
-	data, _ := os.Open("../fixtures/condition.json")
+func (b *BindSuite) TestXMLFHIRBinding(c *C) {
+	testBinding(c, "../fixtures/condition.xml", "application/xml+fhir")
+}
+
+func testBinding(c *C, fileName string, contentType string) {
+	data, _ := os.Open(fileName)
 
 	r, _ := http.NewRequest("POST", "/Condition", data)
 	r.Header.Add("Content-Type", contentType)
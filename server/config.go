@@ -11,10 +11,11 @@ var Database *mgo.Database
 
 // DefaultConfig is the default server configuration
 var DefaultConfig = Config{
-	ServerURL:       "http://localhost:3001",
-	IndexConfigPath: "config/indexes.conf",
-	DatabaseName:    "fhir",
-	Auth:            auth.None(),
+	ServerURL:               "http://localhost:3001",
+	IndexConfigPath:         "config/indexes.conf",
+	DatabaseName:            "fhir",
+	Auth:                    auth.None(),
+	EnableValidateOperation: true,
 }
 
 // Config is used to hold information about the configuration of the FHIR
@@ -32,4 +33,19 @@ type Config struct {
 	// DatabaseName is the name of the mongo database used for the fhir database.
 	// Typically this will be the DefaultDatabaseName
 	DatabaseName string
+	// ValidateResources determines if resources are validated (as they are by the
+	// $validate operation) before they are created or updated
+	ValidateResources bool
+	// EnableValidateOperation determines if the $validate operation is supported on
+	// every resource type and instance
+	EnableValidateOperation bool
+	// RequiredProfiles maps resource types to the URLs of profiles (StructureDefinition
+	// resources stored by the server) that resources of that type must conform to when
+	// they are created or updated.  Resources are always validated against the profiles
+	// they claim (in meta.profile), when the server has them.
+	RequiredProfiles map[string]string
+	// IDGenerator generates the IDs assigned to resources created by the server (e.g.,
+	// ObjectIDGenerator, UUIDGenerator, or SequentialIDGenerator).  If nil, BSON ObjectIds
+	// are assigned.
+	IDGenerator IDGenerator
 }
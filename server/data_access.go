@@ -14,16 +14,27 @@ type DataAccessLayer interface {
 	Get(id, resourceType string) (result interface{}, err error)
 	// Post creates a resource instance, returning its new ID.
 	Post(resource interface{}) (id string, err error)
-	// PostWithID creates a resource instance with the given ID.
+	// PostWithID creates a resource instance with the given ID.  If a resource with the ID already exists,
+	// ErrConflict is returned.
 	PostWithID(id string, resource interface{}) error
+	// NewID generates a new server-assigned ID for a resource of the given type, for use with PostWithID.
+	NewID(resourceType string) (id string, err error)
 	// Put creates or updates a resource instance with the given ID.
 	Put(id string, resource interface{}) (createdNew bool, err error)
+	// PutIfMatch updates the resource instance with the given ID, but only if its current version ID matches the
+	// passed in version ID (or the version ID is AnyVersion).  If it doesn't match (or the resource doesn't exist),
+	// ErrPreconditionFailed is returned.
+	PutIfMatch(id, versionID string, resource interface{}) error
 	// ConditionalPut creates or updates a resource based on search criteria.  If the criteria results in zero matches,
 	// the resource is created.  If the criteria results in one match, it is updated.  Otherwise, a ErrMultipleMatches
 	// error is returned.
 	ConditionalPut(query search.Query, resource interface{}) (id string, createdNew bool, err error)
 	// Delete removes the resource instance with the given ID.  This operation cannot be undone.
 	Delete(id, resourceType string) error
+	// DeleteIfMatch removes the resource instance with the given ID, but only if its current version ID matches the
+	// passed in version ID (or the version ID is AnyVersion).  If it doesn't match (or the resource doesn't exist),
+	// ErrPreconditionFailed is returned.
+	DeleteIfMatch(id, versionID, resourceType string) error
 	// ConditionalDelete removes zero or more resources matching the passed in search criteria.  This operation cannot
 	// be undone.
 	ConditionalDelete(query search.Query) (count int, err error)
@@ -33,10 +44,46 @@ type DataAccessLayer interface {
 	// search options that don't make sense in this context: _include, _revinclude, _summary, _elements, _contained,
 	// and _containedType.  It honors search options such as _count, _sort, and _offset.
 	FindIDs(searchQuery search.Query) (result []string, err error)
+	// GetVersion retrieves a specific version of a resource instance identified by its resource type, ID, and
+	// version ID.  If the requested version represents a deletion, ErrDeleted is returned.
+	GetVersion(id, versionID, resourceType string) (result interface{}, err error)
+	// History returns a history bundle containing the versions of the resources identified by the historyQuery,
+	// newest first.  The baseURL is used to construct the paging links.
+	History(baseURL url.URL, historyQuery HistoryQuery) (result *models.Bundle, err error)
+	// Everything returns a searchset bundle containing the resources in the patient compartment of the patient (or
+	// patients) identified by the everythingQuery, followed by the resources they reference.  The baseURL is used to
+	// construct the paging links.
+	Everything(baseURL url.URL, everythingQuery EverythingQuery) (result *models.Bundle, err error)
+	// StartTransaction returns a Transaction whose changes can be rolled back.  Starting a transaction within a
+	// transaction creates a savepoint within the existing transaction.
+	StartTransaction() Transaction
+}
+
+// Transaction is a DataAccessLayer that keeps track of the changes made through it so that they can all be undone if
+// one of them fails.  Changes are visible to other requests before the transaction is committed.
+type Transaction interface {
+	DataAccessLayer
+	// Commit ends the transaction, keeping all of its changes.
+	Commit()
+	// Rollback undoes all of the changes made in the transaction, in the reverse order they were made.
+	Rollback() error
 }
 
 // ErrNotFound indicates an error
 var ErrNotFound = errors.New("Resource Not Found")
 
-// ErrMultipleMatches indicates that the conditional update query returned multiple matches
+// ErrMultipleMatches indicates that a conditional query (e.g., for an update or create) returned multiple matches
 var ErrMultipleMatches = errors.New("Multiple Matches")
+
+// ErrDeleted indicates that the requested resource (or resource version) has been deleted
+var ErrDeleted = errors.New("Resource Deleted")
+
+// AnyVersion is the version ID that matches any current version of a resource, as requested by "If-Match: *"
+const AnyVersion = "*"
+
+// ErrPreconditionFailed indicates that the version given in an If-Match precondition is not the current version
+var ErrPreconditionFailed = errors.New("Precondition Failed")
+
+// ErrConflict indicates that the resource was modified by another request while it was being updated or deleted, or
+// that a resource being created with a given ID already exists
+var ErrConflict = errors.New("Resource Version Conflict")
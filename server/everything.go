@@ -0,0 +1,131 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/intervention-engine/fhir/models"
+	"github.com/intervention-engine/fhir/search"
+)
+
+// Parameters of the Patient $everything operation, used to limit results to care provided within a date range
+const (
+	StartParam = "start"
+	EndParam   = "end"
+)
+
+// EverythingQuery describes a request for the Patient $everything operation, which returns every resource in the
+// patient compartment along with the practitioners, organizations, medications, and locations those resources
+// reference.  For example, the URL http://acme.com/Patient/123/$everything?start=2016 should be represented as:
+// 	EverythingQuery { ID: "123", Query: "start=2016" }
+// ID is left blank to request the records of all patients.
+type EverythingQuery struct {
+	ID    string
+	Query string
+}
+
+// EverythingOptions contains the parsed options for an $everything query.
+type EverythingOptions struct {
+	Count  int
+	Offset int
+	Start  string
+	End    string
+	Since  *time.Time
+}
+
+// everythingReferencedTypes are the types of resources, outside of the patient compartment, that are returned by
+// $everything when they are referenced by resources in the compartment.
+var everythingReferencedTypes = []string{"Practitioner", "Organization", "Medication", "Location"}
+
+// fhirDatePattern matches FHIR dates and dateTimes of any precision
+var fhirDatePattern = regexp.MustCompile(`^[0-9]{4}(-(0[1-9]|1[0-2])(-(0[1-9]|[12][0-9]|3[01])(T[0-9]{2}:[0-9]{2}(:[0-9]{2}(\.[0-9]+)?)?(Z|[+-][0-9]{2}:[0-9]{2})?)?)?)?$`)
+
+// Options parses the query string and returns the EverythingOptions.  The query supports the start, end, _since,
+// _count, and _offset parameters.  An OperationOutcome is returned as the error if the query contains an unsupported
+// parameter or an invalid value.
+func (e *EverythingQuery) Options() (*EverythingOptions, error) {
+	defaults := search.NewQueryOptions()
+	options := &EverythingOptions{Count: defaults.Count, Offset: defaults.Offset}
+	queryParams, err := search.ParseQuery(e.Query)
+	if err != nil {
+		return nil, models.NewOperationOutcome("error", "processing", err.Error())
+	}
+	for _, param := range queryParams.All() {
+		switch param.Key {
+		case search.CountParam:
+			count, err := strconv.Atoi(param.Value)
+			if err != nil {
+				return nil, models.NewOperationOutcome("error", "processing", "Parameter \"_count\" content is invalid")
+			}
+			if count >= 0 {
+				options.Count = count
+			}
+		case search.OffsetParam:
+			offset, err := strconv.Atoi(param.Value)
+			if err != nil {
+				return nil, models.NewOperationOutcome("error", "processing", "Parameter \"_offset\" content is invalid")
+			}
+			if offset >= 0 {
+				options.Offset = offset
+			}
+		case StartParam, EndParam:
+			if !fhirDatePattern.MatchString(param.Value) {
+				return nil, models.NewOperationOutcome("error", "processing", fmt.Sprintf("Parameter \"%s\" content is invalid", param.Key))
+			}
+			if param.Key == StartParam {
+				options.Start = param.Value
+			} else {
+				options.End = param.Value
+			}
+		case SinceParam:
+			since, err := time.Parse(time.RFC3339Nano, param.Value)
+			if err != nil {
+				return nil, models.NewOperationOutcome("error", "processing", "Parameter \"_since\" content is invalid")
+			}
+			options.Since = &since
+		case search.FormatParam:
+			// Handled by the content negotiation middleware
+		default:
+			return nil, models.NewOperationOutcome("error", "not-supported", fmt.Sprintf("Parameter \"%s\" not understood", param.Key))
+		}
+	}
+	return options, nil
+}
+
+// URLQueryParameters reconstructs the URL-encoded query based on the parsed options.
+func (o *EverythingOptions) URLQueryParameters() search.URLQueryParameters {
+	var queryParams search.URLQueryParameters
+	if o.Start != "" {
+		queryParams.Set(StartParam, o.Start)
+	}
+	if o.End != "" {
+		queryParams.Set(EndParam, o.End)
+	}
+	if o.Since != nil {
+		queryParams.Set(SinceParam, o.Since.Format(time.RFC3339Nano))
+	}
+	queryParams.Set(search.OffsetParam, strconv.Itoa(o.Offset))
+	queryParams.Set(search.CountParam, strconv.Itoa(o.Count))
+	return queryParams
+}
+
+// compartmentQuery returns the search query limiting resources of the given type to those matching the options.  The
+// start and end dates are applied to the resource's "date" search parameter, so resources without one (such as the
+// Patient) are not limited by date.
+func (o *EverythingOptions) compartmentQuery(resourceType string) search.Query {
+	var queryParams search.URLQueryParameters
+	if _, hasDate := search.SearchParameterDictionary[resourceType]["date"]; hasDate {
+		if o.Start != "" {
+			queryParams.Add("date", "ge"+o.Start)
+		}
+		if o.End != "" {
+			queryParams.Add("date", "le"+o.End)
+		}
+	}
+	if o.Since != nil {
+		queryParams.Add("_lastUpdated", "ge"+o.Since.Format(time.RFC3339Nano))
+	}
+	return search.Query{Resource: resourceType, Query: queryParams.Encode()}
+}
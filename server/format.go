@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NegotiateFormat is middleware that determines the format (JSON or XML) of the response based on the _format
+// parameter or, if it is not present, the Accept header (falling back to the format of the request).  Requests for
+// any other format receive a 406 Not Acceptable status.  When XML is requested, JSON responses are converted to XML
+// before they are sent.
+func NegotiateFormat(c *gin.Context) {
+	format, ok := requestedFormat(c.Request)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotAcceptable)
+		return
+	}
+	if format != "xml" {
+		c.Next()
+		return
+	}
+
+	writer := &bufferedResponseWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	c.Next()
+	c.Writer = writer.ResponseWriter
+
+	body := writer.body.Bytes()
+	if len(body) > 0 && strings.Contains(c.Writer.Header().Get("Content-Type"), "json") {
+		if xmlBody, err := ConvertJSONToXML(body); err == nil {
+			c.Writer.Header().Set("Content-Type", "application/xml+fhir; charset=utf-8")
+			body = xmlBody
+		}
+	}
+	c.Writer.Write(body)
+}
+
+// requestedFormat returns "json" or "xml" based on the _format parameter or the Accept header.  The media ranges in
+// the Accept header are weighed by their quality values, with ties going to the range listed first.  If no format is
+// specified (or a wildcard is preferred), the format of the request's content is used, defaulting to JSON.  If only
+// unsupported formats are requested, ok is false.
+func requestedFormat(r *http.Request) (format string, ok bool) {
+	if f := r.URL.Query().Get("_format"); f != "" {
+		switch f {
+		case "json", "application/json", "application/json+fhir", "application/fhir+json":
+			return "json", true
+		case "xml", "text/xml", "application/xml", "application/xml+fhir", "application/fhir+xml":
+			return "xml", true
+		}
+		return "", false
+	}
+
+	preferred := "json"
+	if strings.Contains(strings.ToLower(r.Header.Get("Content-Type")), "xml") {
+		preferred = "xml"
+	}
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return preferred, true
+	}
+
+	// Find the quality of each format, letting ranges naming the format take precedence over wildcards
+	acceptable := map[string]*acceptedFormat{"json": nil, "xml": nil}
+	for i, mediaRange := range strings.Split(accept, ",") {
+		mediaType, quality, valid := parseMediaRange(mediaRange)
+		if !valid {
+			continue
+		}
+		switch {
+		case strings.Contains(mediaType, "json"), strings.Contains(mediaType, "xml"):
+			f := "json"
+			if strings.Contains(mediaType, "xml") {
+				f = "xml"
+			}
+			if a := acceptable[f]; a == nil || a.wildcard || quality > a.quality {
+				acceptable[f] = &acceptedFormat{quality: quality, position: i}
+			}
+		case mediaType == "*/*", mediaType == "application/*":
+			for f, a := range acceptable {
+				if a == nil || (a.wildcard && quality > a.quality) {
+					acceptable[f] = &acceptedFormat{quality: quality, position: i, wildcard: true}
+				}
+			}
+		}
+	}
+
+	other := "xml"
+	if preferred == "xml" {
+		other = "json"
+	}
+	p, o := acceptable[preferred], acceptable[other]
+	switch {
+	case o.isAcceptable() && (!p.isAcceptable() || o.quality > p.quality || (o.quality == p.quality && o.position < p.position)):
+		return other, true
+	case p.isAcceptable():
+		return preferred, true
+	}
+	return "", false
+}
+
+// acceptedFormat is the quality of a format in an Accept header, and the position of the media range it came from
+type acceptedFormat struct {
+	quality  float64
+	position int
+	wildcard bool
+}
+
+func (a *acceptedFormat) isAcceptable() bool {
+	return a != nil && a.quality > 0
+}
+
+// parseMediaRange returns the lowercase media type and the quality value (defaulting to 1) of a media range in an
+// Accept header, such as "application/xml;q=0.5".  If the quality value is invalid, valid is false.
+func parseMediaRange(mediaRange string) (mediaType string, quality float64, valid bool) {
+	parts := strings.Split(mediaRange, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(parts[0]))
+	quality = 1
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 && strings.ToLower(strings.TrimSpace(kv[0])) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+			if err != nil || q < 0 || q > 1 {
+				return "", 0, false
+			}
+			quality = q
+		}
+	}
+	return mediaType, quality, mediaType != ""
+}
+
+// bufferedResponseWriter holds the response body so it can be converted before it is written.
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type FormatSuite struct{}
+
+var _ = Suite(&FormatSuite{})
+
+func (f *FormatSuite) TestRequestedFormat(c *C) {
+	tests := []struct {
+		url, accept, contentType, format string
+	}{
+		{"/Patient", "", "", "json"},
+		{"/Patient?_format=xml", "application/json", "", "xml"},
+		{"/Patient", "application/xml+fhir", "", "xml"},
+		{"/Patient", "application/json+fhir, application/xml+fhir", "", "json"},
+		{"/Patient", "application/xml, application/json", "", "xml"},
+		{"/Patient", "application/xml;q=0.1, application/json", "", "json"},
+		{"/Patient", "application/json;q=0.5, application/xml;q=0.8", "", "xml"},
+		{"/Patient", "application/xml, */*;q=0.5", "", "xml"},
+		{"/Patient", "*/*", "", "json"},
+		{"/Patient", "application/json;q=0, */*", "", "xml"},
+		{"/Patient", "", "application/xml+fhir", "xml"},
+		{"/Patient", "*/*", "application/xml+fhir; charset=utf-8", "xml"},
+		{"/Patient", "application/json", "application/xml+fhir", "json"},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.url, nil)
+		util.CheckErr(err)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		format, ok := requestedFormat(req)
+		comment := Commentf("Accept: %s, Content-Type: %s", test.accept, test.contentType)
+		c.Assert(ok, Equals, true, comment)
+		c.Assert(format, Equals, test.format, comment)
+	}
+}
+
+func (f *FormatSuite) TestRequestedFormatNotAcceptable(c *C) {
+	for _, accept := range []string{"text/turtle", "application/json;q=0", "application/json;q=0, application/xml;q=0, text/html"} {
+		req, err := http.NewRequest("GET", "/Patient", nil)
+		util.CheckErr(err)
+		req.Header.Set("Accept", accept)
+		_, ok := requestedFormat(req)
+		c.Assert(ok, Equals, false, Commentf("%s", accept))
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/intervention-engine/fhir/models"
+	"github.com/intervention-engine/fhir/search"
+)
+
+// SinceParam is the history parameter used to limit results to versions created at or after a given instant
+const SinceParam = "_since"
+
+// HistoryQuery describes a request for the version history of the whole system, of a resource type, or of a single
+// resource instance.  For example, the URL http://acme.com/Patient/123/_history?_count=10 should be represented as:
+// 	HistoryQuery { Resource: "Patient", ID: "123", Query: "_count=10" }
+// Resource and ID are left blank for system-level history, and ID is left blank for type-level history.
+type HistoryQuery struct {
+	Resource string
+	ID       string
+	Query    string
+}
+
+// HistoryOptions contains the parsed options for a history query.
+type HistoryOptions struct {
+	Count  int
+	Offset int
+	Since  *time.Time
+}
+
+// Options parses the query string and returns the HistoryOptions.  Unlike search queries, history queries only
+// support the _count, _offset, and _since parameters.  An OperationOutcome is returned as the error if the query
+// contains an unsupported parameter or an invalid value.
+func (h *HistoryQuery) Options() (*HistoryOptions, error) {
+	defaults := search.NewQueryOptions()
+	options := &HistoryOptions{Count: defaults.Count, Offset: defaults.Offset}
+	queryParams, err := search.ParseQuery(h.Query)
+	if err != nil {
+		return nil, models.NewOperationOutcome("error", "processing", err.Error())
+	}
+	for _, param := range queryParams.All() {
+		switch param.Key {
+		case search.CountParam:
+			count, err := strconv.Atoi(param.Value)
+			if err != nil {
+				return nil, models.NewOperationOutcome("error", "processing", "Parameter \"_count\" content is invalid")
+			}
+			if count >= 0 {
+				options.Count = count
+			}
+		case search.OffsetParam:
+			offset, err := strconv.Atoi(param.Value)
+			if err != nil {
+				return nil, models.NewOperationOutcome("error", "processing", "Parameter \"_offset\" content is invalid")
+			}
+			if offset >= 0 {
+				options.Offset = offset
+			}
+		case SinceParam:
+			since, err := time.Parse(time.RFC3339Nano, param.Value)
+			if err != nil {
+				return nil, models.NewOperationOutcome("error", "processing", "Parameter \"_since\" content is invalid")
+			}
+			options.Since = &since
+		case search.FormatParam:
+			// Handled by the content negotiation middleware
+		default:
+			return nil, models.NewOperationOutcome("error", "not-supported", fmt.Sprintf("Parameter \"%s\" not understood", param.Key))
+		}
+	}
+	return options, nil
+}
+
+// URLQueryParameters reconstructs the URL-encoded query based on the parsed options.
+func (o *HistoryOptions) URLQueryParameters() search.URLQueryParameters {
+	var queryParams search.URLQueryParameters
+	if o.Since != nil {
+		queryParams.Set(SinceParam, o.Since.Format(time.RFC3339Nano))
+	}
+	queryParams.Set(search.OffsetParam, strconv.Itoa(o.Offset))
+	queryParams.Set(search.CountParam, strconv.Itoa(o.Count))
+	return queryParams
+}
+
+// versionID returns the versionId recorded in the resource's meta, or an empty string if there is none.
+func versionID(resource interface{}) string {
+	if meta, ok := models.GetResourceMeta(resource); ok && meta != nil {
+		return meta.VersionId
+	}
+	return ""
+}
+
+// nextVersionID returns the versionId that follows the passed in versionId.  Version IDs are assigned by the server
+// as sequential integers, starting with "1" for a resource's first version.
+func nextVersionID(current string) string {
+	v, _ := strconv.Atoi(current)
+	return strconv.Itoa(v + 1)
+}
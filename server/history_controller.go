@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/intervention-engine/fhir/models"
+)
+
+// HistoryController handles requests for the version history of all resources on the server.  Type and instance level
+// history is handled by the ResourceController.
+type HistoryController struct {
+	DAL DataAccessLayer
+}
+
+// NewHistoryController creates a new HistoryController based on the passed in DAL
+func NewHistoryController(dal DataAccessLayer) *HistoryController {
+	return &HistoryController{DAL: dal}
+}
+
+// Get handles requests for the system-level history
+func (h *HistoryController) Get(c *gin.Context) {
+	historyQuery := HistoryQuery{Query: c.Request.URL.RawQuery}
+	bundle, err := h.DAL.History(*responseURL(c.Request, "_history"), historyQuery)
+	if oo, ok := err.(*models.OperationOutcome); ok {
+		c.JSON(http.StatusBadRequest, oo)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Set("bundle", bundle)
+	c.Set("Action", "history")
+
+	c.JSON(http.StatusOK, bundle)
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// IDGenerator generates the IDs the server assigns to newly created resources.  Clients may still assign their own
+// IDs (e.g., using update as create), so generated IDs may collide with existing ones; the data access layer skips
+// over any such IDs.
+type IDGenerator interface {
+	NewID(resourceType string) (string, error)
+}
+
+// ObjectIDGenerator generates BSON ObjectIds (as 24 character hex strings).  It is the default IDGenerator.
+type ObjectIDGenerator struct{}
+
+func (g ObjectIDGenerator) NewID(resourceType string) (string, error) {
+	return bson.NewObjectId().Hex(), nil
+}
+
+// UUIDGenerator generates random (version 4) UUIDs.
+type UUIDGenerator struct{}
+
+func (g UUIDGenerator) NewID(resourceType string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// SequentialIDGenerator generates sequential integer IDs (1, 2, 3, ...) for each resource type.  The last ID assigned
+// to each type is kept in the Mongo database, so sequences continue where they left off when the server restarts.  If
+// the Database is nil, the mongo data access layer using the generator sets it to its own database.
+type SequentialIDGenerator struct {
+	Database *mgo.Database
+}
+
+// sequenceCollection is the name of the collection holding the last ID assigned to each resource type
+const sequenceCollection = "sequences"
+
+func (g *SequentialIDGenerator) NewID(resourceType string) (string, error) {
+	var sequence struct {
+		Last int64 `bson:"last"`
+	}
+	change := mgo.Change{Update: bson.M{"$inc": bson.M{"last": 1}}, Upsert: true, ReturnNew: true}
+	if _, err := g.Database.C(sequenceCollection).FindId(resourceType).Apply(change, &sequence); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(sequence.Last, 10), nil
+}
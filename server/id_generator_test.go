@@ -0,0 +1,48 @@
+package server
+
+import (
+	"regexp"
+
+	. "gopkg.in/check.v1"
+)
+
+type IDGeneratorSuite struct{}
+
+var _ = Suite(&IDGeneratorSuite{})
+
+func (s *IDGeneratorSuite) TestObjectIDGenerator(c *C) {
+	id, err := ObjectIDGenerator{}.NewID("Patient")
+	c.Assert(err, IsNil)
+	c.Assert(id, Matches, "[0-9a-f]{24}")
+	c.Assert(validateID(id), IsNil)
+}
+
+func (s *IDGeneratorSuite) TestUUIDGenerator(c *C) {
+	uuid := regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$")
+	ids := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id, err := UUIDGenerator{}.NewID("Patient")
+		c.Assert(err, IsNil)
+		c.Assert(uuid.MatchString(id), Equals, true, Commentf("%s is not a version 4 UUID", id))
+		c.Assert(validateID(id), IsNil)
+		ids[id] = true
+	}
+	c.Assert(ids, HasLen, 100)
+}
+
+func (s *IDGeneratorSuite) TestValidateID(c *C) {
+	for _, id := range []string{"example", "patient-123", "v1.2", "56afe6b85cdc7ec329dfe6a9"} {
+		c.Assert(validateID(id), IsNil)
+	}
+	for _, id := range []string{"", "not_valid", "a/b", "urn:uuid:1", string(make([]byte, 65))} {
+		c.Assert(validateID(id), NotNil)
+	}
+}
+
+func (s *IDGeneratorSuite) TestReferenceKeys(c *C) {
+	c.Assert(referenceKeys(""), HasLen, 0)
+	c.Assert(referenceKeys("urn:uuid:61ebe359-bfdc-4613-8bf2-c5e300945f0a"), DeepEquals, []string{"urn:uuid:61ebe359-bfdc-4613-8bf2-c5e300945f0a"})
+	c.Assert(referenceKeys("http://example.org/fhir/Patient/patient-123"), DeepEquals, []string{"http://example.org/fhir/Patient/patient-123", "Patient/patient-123"})
+	c.Assert(referenceKeys("Patient/patient-123"), DeepEquals, []string{"Patient/patient-123"})
+	c.Assert(referenceKeys("http://example.org/fhir/Widget/1"), DeepEquals, []string{"http://example.org/fhir/Widget/1"})
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/intervention-engine/fhir/auth"
+	"github.com/intervention-engine/fhir/models"
+	"github.com/intervention-engine/fhir/search"
+)
+
+// MetadataController handles requests for the server's Conformance statement.
+type MetadataController struct {
+	Engine *gin.Engine
+	Config Config
+}
+
+// NewMetadataController creates a new MetadataController that describes the routes registered with the passed in
+// engine.
+func NewMetadataController(e *gin.Engine, config Config) *MetadataController {
+	return &MetadataController{Engine: e, Config: config}
+}
+
+// Get handles requests for the server's Conformance statement.
+func (m *MetadataController) Get(c *gin.Context) {
+	conformance := m.Conformance()
+
+	c.Set("Conformance", conformance)
+	c.Set("Resource", "Conformance")
+	c.Set("Action", "read")
+
+	c.JSON(http.StatusOK, conformance)
+}
+
+// Conformance builds a Conformance statement describing the server's capabilities.  The supported resources and
+// interactions are determined by the routes registered with the engine, the supported search parameters are
+// determined by the search parameter registry, and the extended operations by the operation registry.  Since it is
+// rebuilt on each call, the statement reflects any search parameters or routes registered after the server has
+// started.
+func (m *MetadataController) Conformance() *models.Conformance {
+	conformance := &models.Conformance{
+		Url:            strings.TrimSuffix(m.Config.ServerURL, "/") + "/metadata",
+		Name:           "Intervention Engine FHIR Server",
+		Status:         "active",
+		Date:           &models.FHIRDateTime{Time: time.Now(), Precision: models.Timestamp},
+		Kind:           "instance",
+		Software:       &models.ConformanceSoftwareComponent{Name: "Intervention Engine FHIR Server"},
+		Implementation: &models.ConformanceImplementationComponent{Description: "Intervention Engine FHIR Server", Url: m.Config.ServerURL},
+		FhirVersion:    "1.0.2",
+		AcceptUnknown:  "no",
+		Format:         []string{"application/json+fhir", "json", "application/xml+fhir", "xml"},
+	}
+
+	rest := models.ConformanceRestComponent{
+		Mode:     "server",
+		Security: conformanceSecurity(m.Config.Auth),
+	}
+
+	// Group the registered routes by resource, keeping track of the system-level routes as well
+	resourceRoutes := make(map[string]map[string]bool)
+	systemRoutes := make(map[string]bool)
+	for _, route := range m.Engine.Routes() {
+		parts := strings.SplitN(strings.TrimPrefix(route.Path, "/"), "/", 2)
+		if !isResourceName(parts[0]) {
+			systemRoutes[route.Method+" "+route.Path] = true
+			continue
+		}
+		if resourceRoutes[parts[0]] == nil {
+			resourceRoutes[parts[0]] = make(map[string]bool)
+		}
+		resourceRoutes[parts[0]][route.Method+" "+strings.TrimPrefix(route.Path, "/"+parts[0])] = true
+	}
+
+	resourceNames := make([]string, 0, len(resourceRoutes))
+	for name := range resourceRoutes {
+		resourceNames = append(resourceNames, name)
+	}
+	sort.Strings(resourceNames)
+
+	for _, name := range resourceNames {
+		rest.Resource = append(rest.Resource, conformanceResource(name, resourceRoutes[name]))
+	}
+
+	for _, i := range systemInteractions {
+		if systemRoutes[i.route] {
+			rest.Interaction = append(rest.Interaction, models.ConformanceSystemInteractionComponent{Code: i.code})
+		}
+	}
+	if systemRoutes["POST /"] {
+		rest.TransactionMode = "both"
+	}
+
+	if resourceRoutes["Patient"]["GET /:id/$everything"] {
+		rest.Operation = append(rest.Operation, models.ConformanceRestOperationComponent{
+			Name:       "everything",
+			Definition: &models.Reference{Reference: "http://hl7.org/fhir/OperationDefinition/Patient-everything"},
+		})
+	}
+	for _, op := range serverOperations(m.Config) {
+		rest.Operation = append(rest.Operation, conformanceOperation(op.Definition))
+	}
+
+	conformance.Rest = []models.ConformanceRestComponent{rest}
+	return conformance
+}
+
+// interaction maps a route (method and path) to the FHIR interaction it supports
+type interaction struct {
+	route string
+	code  string
+}
+
+// resourceInteractions are the type and instance level interactions, with routes relative to the resource's base
+var resourceInteractions = []interaction{
+	{"GET /:id", "read"},
+	{"GET /:id/_history/:vid", "vread"},
+	{"PUT /:id", "update"},
+	{"DELETE /:id", "delete"},
+	{"GET /:id/_history", "history-instance"},
+	// Type-level history (GET /Type/_history) is dispatched from the read route, which ShowHandler handles
+	{"GET /:id", "history-type"},
+	{"POST ", "create"},
+	{"GET ", "search-type"},
+}
+
+// systemInteractions are the whole system interactions
+var systemInteractions = []interaction{
+	{"POST /", "transaction"},
+	{"GET /_history", "history-system"},
+}
+
+func conformanceResource(name string, routes map[string]bool) models.ConformanceRestResourceComponent {
+	resource := models.ConformanceRestResourceComponent{Type: name}
+	supported := true
+	for _, i := range resourceInteractions {
+		if routes[i.route] {
+			resource.Interaction = append(resource.Interaction, models.ConformanceResourceInteractionComponent{Code: i.code})
+		}
+	}
+
+	if routes["PUT /:id"] {
+		resource.Versioning = "versioned-update"
+		resource.UpdateCreate = &supported
+	}
+	if routes["GET /:id/_history/:vid"] {
+		resource.ReadHistory = &supported
+	}
+	if routes["POST "] {
+		resource.ConditionalCreate = &supported
+	}
+	if routes["PUT "] {
+		resource.ConditionalUpdate = &supported
+	}
+	if routes["DELETE "] {
+		resource.ConditionalDelete = "multiple"
+	}
+
+	if routes["GET "] {
+		for _, param := range search.GlobalRegistry().LookupParameterInfos(name) {
+			resource.SearchParam = append(resource.SearchParam, models.ConformanceRestResourceSearchParamComponent{
+				Name:   param.Name,
+				Type:   param.Type,
+				Target: param.Targets,
+			})
+			if param.Type == "reference" {
+				resource.SearchInclude = append(resource.SearchInclude, name+":"+param.Name)
+			}
+		}
+	}
+
+	return resource
+}
+
+// conformanceOperation describes a registered operation, referring to its definition by its canonical URL or, if it
+// has none, by its ID
+func conformanceOperation(definition *models.OperationDefinition) models.ConformanceRestOperationComponent {
+	operation := models.ConformanceRestOperationComponent{Name: definition.Code}
+	if definition.Url != "" {
+		operation.Definition = &models.Reference{Reference: definition.Url}
+	} else if definition.Id != "" {
+		operation.Definition = &models.Reference{Reference: "OperationDefinition/" + definition.Id}
+	}
+	return operation
+}
+
+// conformanceSecurity describes the security settings in the passed in auth configuration
+func conformanceSecurity(config auth.Config) *models.ConformanceRestSecurityComponent {
+	cors := true
+	security := &models.ConformanceRestSecurityComponent{Cors: &cors}
+
+	oauth := models.CodeableConcept{
+		Coding: []models.Coding{{System: "http://hl7.org/fhir/restful-security-service", Code: "OAuth"}},
+		Text:   "OAuth",
+	}
+	switch config.Method {
+	case auth.AuthTypeNone:
+		security.Description = "No authentication or authorization is required"
+	case auth.AuthTypeOIDC:
+		security.Service = []models.CodeableConcept{oauth}
+		security.Description = "OpenID Connect authentication and OAuth 2.0 token introspection.  Authorization URL: " +
+			config.AuthorizationURL + ", Token URL: " + config.TokenURL
+	case auth.AuthTypeHEART:
+		security.Service = []models.CodeableConcept{oauth}
+		security.Description = "HEART profiled OpenID Connect authentication and OAuth 2.0 token introspection.  " +
+			"OpenID Connect Provider: " + config.OPURL
+	}
+
+	return security
+}
+
+// isResourceName determines if the first segment of a route is a resource name rather than a system-level path (e.g.,
+// "_history" or "metadata").  All FHIR resource names start with an upper case letter.
+func isResourceName(segment string) bool {
+	return len(segment) > 0 && segment[0] >= 'A' && segment[0] <= 'Z'
+}
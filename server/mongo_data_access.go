@@ -1,9 +1,14 @@
 package server
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"net/url"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/intervention-engine/fhir/models"
@@ -12,24 +17,44 @@ import (
 	"gopkg.in/mgo.v2/bson"
 )
 
-// NewMongoDataAccessLayer returns an implementation of DataAccessLayer that is backed by a Mongo database
+// NewMongoDataAccessLayer returns an implementation of DataAccessLayer that is backed by a Mongo database and assigns
+// BSON ObjectIds to new resources
 func NewMongoDataAccessLayer(db *mgo.Database) DataAccessLayer {
-	return &mongoDataAccessLayer{Database: db}
+	return NewMongoDataAccessLayerWithIDGenerator(db, nil)
+}
+
+// NewMongoDataAccessLayerWithIDGenerator returns an implementation of DataAccessLayer that is backed by a Mongo
+// database and assigns new resources the IDs generated by the passed in IDGenerator.  If the IDGenerator is nil,
+// BSON ObjectIds are assigned.
+func NewMongoDataAccessLayerWithIDGenerator(db *mgo.Database, idGenerator IDGenerator) DataAccessLayer {
+	if idGenerator == nil {
+		idGenerator = ObjectIDGenerator{}
+	} else if g, ok := idGenerator.(*SequentialIDGenerator); ok && g.Database == nil {
+		g.Database = db
+	}
+	return &mongoDataAccessLayer{Database: db, idGenerator: idGenerator}
 }
 
 type mongoDataAccessLayer struct {
-	Database *mgo.Database
+	Database    *mgo.Database
+	idGenerator IDGenerator
+	journal     *transactionJournal
 }
 
+// maxGeneratedIDAttempts is the number of generated IDs tried when creating a resource, in case the IDs have already
+// been assigned by clients
+const maxGeneratedIDAttempts = 10
+
 func (dal *mongoDataAccessLayer) Get(id, resourceType string) (result interface{}, err error) {
-	bsonID, err := convertIDToBsonID(id)
-	if err != nil {
-		return nil, convertMongoErr(err)
+	if err = validateID(id); err != nil {
+		return nil, err
 	}
 
 	collection := dal.Database.C(models.PluralizeLowerResourceName(resourceType))
 	result = models.NewStructForResourceName(resourceType)
-	if err = collection.FindId(bsonID.Hex()).One(result); err != nil {
+	if err = collection.FindId(id).One(result); err == mgo.ErrNotFound && dal.isDeleted(resourceType, id) {
+		return nil, ErrDeleted
+	} else if err != nil {
 		return nil, convertMongoErr(err)
 	}
 
@@ -37,46 +62,113 @@ func (dal *mongoDataAccessLayer) Get(id, resourceType string) (result interface{
 }
 
 func (dal *mongoDataAccessLayer) Post(resource interface{}) (id string, err error) {
-	id = bson.NewObjectId().Hex()
-	err = convertMongoErr(dal.PostWithID(id, resource))
-	return
+	resourceType := reflect.TypeOf(resource).Elem().Name()
+	for attempt := 0; attempt < maxGeneratedIDAttempts; attempt++ {
+		if id, err = dal.NewID(resourceType); err != nil {
+			return "", err
+		}
+		if err = dal.PostWithID(id, resource); err != ErrConflict {
+			return id, err
+		}
+	}
+	return "", fmt.Errorf("Unable to generate an unused ID after %d attempts", maxGeneratedIDAttempts)
 }
 
 func (dal *mongoDataAccessLayer) PostWithID(id string, resource interface{}) error {
-	bsonID, err := convertIDToBsonID(id)
-	if err != nil {
-		return convertMongoErr(err)
+	if err := validateID(id); err != nil {
+		return err
 	}
 
-	reflect.ValueOf(resource).Elem().FieldByName("Id").SetString(bsonID.Hex())
+	reflect.ValueOf(resource).Elem().FieldByName("Id").SetString(id)
 	resourceType := reflect.TypeOf(resource).Elem().Name()
 	collection := dal.Database.C(models.PluralizeLowerResourceName(resourceType))
-	updateLastUpdatedDate(resource)
-	return convertMongoErr(collection.Insert(resource))
+	latestVersion, err := dal.latestVersionID(resourceType, id)
+	if err != nil {
+		return err
+	}
+	updateResourceMeta(resource, nextVersionID(latestVersion))
+	if err := collection.Insert(resource); mgo.IsDup(err) {
+		return ErrConflict
+	} else if err != nil {
+		return convertMongoErr(err)
+	}
+	return dal.saveVersion(resourceType, id, "POST", resource, nil)
+}
+
+func (dal *mongoDataAccessLayer) NewID(resourceType string) (string, error) {
+	return dal.idGenerator.NewID(resourceType)
 }
 
 func (dal *mongoDataAccessLayer) Put(id string, resource interface{}) (createdNew bool, err error) {
-	bsonID, err := convertIDToBsonID(id)
-	if err != nil {
-		return false, convertMongoErr(err)
+	return dal.put(id, resource, nil)
+}
+
+func (dal *mongoDataAccessLayer) PutIfMatch(id, versionID string, resource interface{}) error {
+	_, err := dal.put(id, resource, &versionID)
+	return err
+}
+
+// put creates or updates the resource with the given ID.  If ifMatch is not nil, the resource must already exist and
+// its current version must match ifMatch, else ErrPreconditionFailed is returned.  The version check and the update
+// are performed in a single operation, so the check cannot be defeated by concurrent updates.
+func (dal *mongoDataAccessLayer) put(id string, resource interface{}, ifMatch *string) (createdNew bool, err error) {
+	if err = validateID(id); err != nil {
+		return false, err
 	}
 
 	resourceType := reflect.TypeOf(resource).Elem().Name()
 	collection := dal.Database.C(models.PluralizeLowerResourceName(resourceType))
-	reflect.ValueOf(resource).Elem().FieldByName("Id").SetString(bsonID.Hex())
-	updateLastUpdatedDate(resource)
-	info, err := collection.UpsertId(bsonID.Hex(), resource)
-	if err == nil {
-		createdNew = (info.Updated == 0)
+	reflect.ValueOf(resource).Elem().FieldByName("Id").SetString(id)
+
+	var previous *bson.Raw
+	currentVersion, err := dal.currentVersionID(collection, id)
+	if ifMatch != nil && (err == ErrNotFound || (err == nil && !versionMatches(currentVersion, *ifMatch))) {
+		return false, ErrPreconditionFailed
 	}
-	return createdNew, convertMongoErr(err)
+	if err == ErrNotFound {
+		// It doesn't exist yet, so this is an update-as-create.  It may have existed before, though, in which case the
+		// version numbering continues from its deletion.
+		createdNew = true
+		var latestVersion string
+		if latestVersion, err = dal.latestVersionID(resourceType, id); err != nil {
+			return false, err
+		}
+		updateResourceMeta(resource, nextVersionID(latestVersion))
+		if err = collection.Insert(resource); mgo.IsDup(err) {
+			// Someone else created it in the meantime
+			return false, ErrConflict
+		}
+	} else if err == nil {
+		if previous, err = dal.journal.snapshot(collection, id); err != nil {
+			return false, convertMongoErr(err)
+		}
+		// Only replace the version we just looked at, so concurrent updates can't be lost
+		updateResourceMeta(resource, nextVersionID(currentVersion))
+		if err = collection.Update(versionSelector(id, currentVersion), resource); err == mgo.ErrNotFound {
+			if ifMatch != nil && *ifMatch != AnyVersion {
+				return false, ErrPreconditionFailed
+			}
+			return false, ErrConflict
+		}
+	}
+	if err != nil {
+		return false, convertMongoErr(err)
+	}
+
+	method := "PUT"
+	if createdNew {
+		method = "POST"
+	}
+	return createdNew, dal.saveVersion(resourceType, id, method, resource, previous)
 }
 
 func (dal *mongoDataAccessLayer) ConditionalPut(query search.Query, resource interface{}) (id string, createdNew bool, err error) {
 	if IDs, err := dal.FindIDs(query); err == nil {
 		switch len(IDs) {
 		case 0:
-			id = bson.NewObjectId().Hex()
+			if id, err = dal.NewID(query.Resource); err != nil {
+				return "", false, err
+			}
 		case 1:
 			id = IDs[0]
 		default:
@@ -91,34 +183,86 @@ func (dal *mongoDataAccessLayer) ConditionalPut(query search.Query, resource int
 }
 
 func (dal *mongoDataAccessLayer) Delete(id, resourceType string) error {
-	bsonID, err := convertIDToBsonID(id)
+	return dal.delete(id, resourceType, nil)
+}
+
+func (dal *mongoDataAccessLayer) DeleteIfMatch(id, versionID, resourceType string) error {
+	return dal.delete(id, resourceType, &versionID)
+}
+
+// delete removes the resource with the given ID.  If ifMatch is not nil, the resource must exist and its current
+// version must match ifMatch, else ErrPreconditionFailed is returned.
+func (dal *mongoDataAccessLayer) delete(id, resourceType string, ifMatch *string) error {
+	if err := validateID(id); err != nil {
+		return err
+	}
+
+	collection := dal.Database.C(models.PluralizeLowerResourceName(resourceType))
+	currentVersion, err := dal.currentVersionID(collection, id)
+	if ifMatch != nil && (err == ErrNotFound || (err == nil && !versionMatches(currentVersion, *ifMatch))) {
+		return ErrPreconditionFailed
+	} else if err != nil {
+		return err
+	}
+	previous, err := dal.journal.snapshot(collection, id)
 	if err != nil {
 		return convertMongoErr(err)
 	}
+	if err = collection.Remove(versionSelector(id, currentVersion)); err == mgo.ErrNotFound {
+		if ifMatch != nil && *ifMatch != AnyVersion {
+			return ErrPreconditionFailed
+		}
+		return ErrConflict
+	} else if err != nil {
+		return convertMongoErr(err)
+	}
 
-	collection := dal.Database.C(models.PluralizeLowerResourceName(resourceType))
-	return convertMongoErr(collection.RemoveId(bsonID.Hex()))
+	return dal.saveDeletedVersion(resourceType, id, nextVersionID(currentVersion), previous)
 }
 
 func (dal *mongoDataAccessLayer) ConditionalDelete(query search.Query) (count int, err error) {
+	// Delete the matches one at a time so that each deletion is recorded in the history
 	searcher := search.NewMongoSearcher(dal.Database)
-	queryObject := searcher.CreateQueryObject(query)
+	var results []struct {
+		ID string `bson:"_id"`
+	}
+	if err = searcher.CreateQueryWithoutOptions(query).Select(bson.M{"_id": 1}).All(&results); err != nil {
+		return 0, convertMongoErr(err)
+	}
 
-	collection := dal.Database.C(models.PluralizeLowerResourceName(query.Resource))
-	info, err := collection.RemoveAll(queryObject)
-	if info != nil {
-		count = info.Removed
+	for _, result := range results {
+		if err = dal.Delete(result.ID, query.Resource); err == nil {
+			count++
+		} else if err != ErrNotFound {
+			return count, err
+		}
 	}
-	return count, convertMongoErr(err)
+	return count, nil
 }
 
 func (dal *mongoDataAccessLayer) Search(baseURL url.URL, searchQuery search.Query) (*models.Bundle, error) {
 	searcher := search.NewMongoSearcher(dal.Database)
+	options := searchQuery.Options()
+
+	// A count-only summary doesn't need any of the resources, only the total
+	if options.Summary == search.SummaryCount {
+		intTotal, err := searcher.CreateQueryWithoutOptions(searchQuery).Count()
+		if err != nil {
+			return nil, convertSearchErr(err, searchQuery.Resource)
+		}
+		total := uint32(intTotal)
+		var bundle models.Bundle
+		bundle.Id = bson.NewObjectId().Hex()
+		bundle.Type = "searchset"
+		bundle.Total = &total
+		bundle.Link = generatePagingLinks(baseURL, searchQuery, total)
+		return &bundle, nil
+	}
 
 	var result interface{}
 	var err error
-	usesIncludes := len(searchQuery.Options().Include) > 0
-	usesRevIncludes := len(searchQuery.Options().RevInclude) > 0
+	usesIncludes := len(options.Include) > 0
+	usesRevIncludes := len(options.RevInclude) > 0
 	// Only use (slower) pipeline if it is needed
 	if usesIncludes || usesRevIncludes {
 		result = models.NewSlicePlusForResourceName(searchQuery.Resource, 0, 0)
@@ -128,28 +272,39 @@ func (dal *mongoDataAccessLayer) Search(baseURL url.URL, searchQuery search.Quer
 		err = searcher.CreateQuery(searchQuery).All(result)
 	}
 	if err != nil {
-		return nil, convertMongoErr(err)
+		return nil, convertSearchErr(err, searchQuery.Resource)
 	}
 
+	// The included resources are keyed by type and ID, since resources of different types may share an ID
 	includesMap := make(map[string]interface{})
+	var matches []interface{}
 	var entryList []models.BundleEntryComponent
 	resultVal := reflect.ValueOf(result).Elem()
 	for i := 0; i < resultVal.Len(); i++ {
 		var entry models.BundleEntryComponent
 		entry.Resource = resultVal.Index(i).Addr().Interface()
 		entry.Search = &models.BundleEntrySearchComponent{Mode: "match"}
+		if options.IsSubsetted() {
+			markSubsetted(entry.Resource)
+		}
 		entryList = append(entryList, entry)
 
 		if usesIncludes || usesRevIncludes {
+			// The resource itself is embedded in the first field of the ResourcePlus struct
+			matches = append(matches, resultVal.Index(i).Field(0).Addr().Interface())
 			rpi, ok := entry.Resource.(ResourcePlusRelatedResources)
 			if ok {
-				for k, v := range rpi.GetIncludedAndRevIncludedResources() {
-					includesMap[k] = v
+				for _, v := range rpi.GetIncludedAndRevIncludedResources() {
+					includesMap[resourceKey(v)] = v
 				}
 			}
 		}
 	}
 
+	if err := dal.addIteratedIncludes(options, matches, includesMap); err != nil {
+		return nil, convertMongoErr(err)
+	}
+
 	for _, v := range includesMap {
 		var entry models.BundleEntryComponent
 		entry.Resource = v
@@ -162,15 +317,13 @@ func (dal *mongoDataAccessLayer) Search(baseURL url.URL, searchQuery search.Quer
 	bundle.Type = "searchset"
 	bundle.Entry = entryList
 
-	options := searchQuery.Options()
-
 	// Need to get the true total (not just how many were returned in this response)
 	var total uint32
 	if resultVal.Len() == options.Count || resultVal.Len() == 0 {
 		// Need to get total count from the server, since there may be more or the offset was too high
 		intTotal, err := searcher.CreateQueryWithoutOptions(searchQuery).Count()
 		if err != nil {
-			return nil, convertMongoErr(err)
+			return nil, convertSearchErr(err, searchQuery.Resource)
 		}
 		total = uint32(intTotal)
 	} else {
@@ -185,6 +338,132 @@ func (dal *mongoDataAccessLayer) Search(baseURL url.URL, searchQuery search.Quer
 	return &bundle, nil
 }
 
+// maxIncludeDepth bounds how many times the iterated includes (_include:recurse or _include:iterate) are applied to
+// the resources they include
+const maxIncludeDepth = 4
+
+// addIteratedIncludes applies the iterated includes to the matching and included resources, adding the resources they
+// include to the includesMap, and then applies them to those resources, and so on, to a depth of maxIncludeDepth.
+// Matching resources are never added to the includesMap.
+func (dal *mongoDataAccessLayer) addIteratedIncludes(options *search.QueryOptions, matches []interface{}, includesMap map[string]interface{}) error {
+	var iterated []search.IncludeOption
+	for _, incl := range options.Include {
+		if incl.Iterate {
+			iterated = append(iterated, incl)
+		}
+	}
+	if len(iterated) == 0 {
+		return nil
+	}
+
+	matched := make(map[string]bool)
+	included := make([]interface{}, 0, len(matches)+len(includesMap))
+	for _, resource := range matches {
+		matched[resourceKey(resource)] = true
+		included = append(included, resource)
+	}
+	for _, resource := range includesMap {
+		included = append(included, resource)
+	}
+	for depth := 0; depth < maxIncludeDepth && len(included) > 0; depth++ {
+		// Collect the IDs of the not yet included resources referenced by the included resources, by type
+		referencedIDs := make(map[string][]string)
+		for _, resource := range included {
+			resourceType := reflect.TypeOf(resource).Elem().Name()
+			var includes []search.IncludeOption
+			for _, incl := range iterated {
+				if incl.Resource == "*" {
+					includes = append(includes, search.WildcardIncludes(resourceType)...)
+				} else if incl.Resource == resourceType {
+					includes = append(includes, incl)
+				}
+			}
+			if len(includes) == 0 {
+				continue
+			}
+			refs, err := includedReferences(resource, includes)
+			if err != nil {
+				return err
+			}
+			for _, ref := range refs {
+				key := ref.Type + "/" + ref.ReferencedID
+				if _, ok := includesMap[key]; !ok && !matched[key] {
+					referencedIDs[ref.Type] = append(referencedIDs[ref.Type], ref.ReferencedID)
+				}
+			}
+		}
+
+		// Then get them, and apply the includes to them next
+		included = nil
+		for resourceType, ids := range referencedIDs {
+			result := models.NewSliceForResourceName(resourceType, 0, 0)
+			if err := dal.Database.C(models.PluralizeLowerResourceName(resourceType)).Find(bson.M{"_id": bson.M{"$in": ids}}).All(result); err != nil {
+				return err
+			}
+			resultVal := reflect.ValueOf(result).Elem()
+			for i := 0; i < resultVal.Len(); i++ {
+				resource := resultVal.Index(i).Addr().Interface()
+				key := resourceKey(resource)
+				if _, ok := includesMap[key]; !ok {
+					includesMap[key] = resource
+					included = append(included, resource)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resourceKey returns the type and ID identifying a resource (e.g., "Patient/123")
+func resourceKey(resource interface{}) string {
+	v := reflect.ValueOf(resource).Elem()
+	return v.Type().Name() + "/" + v.FieldByName("Id").String()
+}
+
+// includedReferences returns the local references in the paths of the include options' parameters to resources of
+// the types the parameters target
+func includedReferences(resource interface{}, includes []search.IncludeOption) ([]models.Reference, error) {
+	b, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err = json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	var refs []models.Reference
+	for _, incl := range includes {
+		targets := make(map[string]bool)
+		for _, target := range incl.Parameter.Targets {
+			targets[target] = true
+		}
+		for _, path := range incl.Parameter.Paths {
+			if path.Type != "Reference" {
+				continue
+			}
+			for _, value := range valuesAtPath(doc, strings.Replace(path.Path, "[]", "", -1)) {
+				// The JSON only has the reference, so the Reference works out its type and ID again
+				b, err := json.Marshal(value)
+				if err != nil {
+					return nil, err
+				}
+				var ref models.Reference
+				if err = json.Unmarshal(b, &ref); err != nil {
+					continue
+				}
+				if ref.External != nil && *ref.External || ref.Type == "" || ref.ReferencedID == "" {
+					continue
+				}
+				if targets[ref.Type] || targets["Any"] {
+					refs = append(refs, ref)
+				}
+			}
+		}
+	}
+	return refs, nil
+}
+
 func (dal *mongoDataAccessLayer) FindIDs(searchQuery search.Query) (IDs []string, err error) {
 	// First create a new query with the unsupported query options filtered out
 	oldParams := searchQuery.URLQueryParameters(false)
@@ -207,7 +486,7 @@ func (dal *mongoDataAccessLayer) FindIDs(searchQuery search.Query) (IDs []string
 		ID string `bson:"_id"`
 	}{}
 	if err := mgoQuery.All(&results); err != nil {
-		return nil, err
+		return nil, convertSearchErr(err, searchQuery.Resource)
 	}
 	IDs = make([]string, len(results))
 	for i := range results {
@@ -217,6 +496,397 @@ func (dal *mongoDataAccessLayer) FindIDs(searchQuery search.Query) (IDs []string
 	return IDs, nil
 }
 
+func (dal *mongoDataAccessLayer) GetVersion(id, versionID, resourceType string) (result interface{}, err error) {
+	if err = validateID(id); err != nil {
+		return nil, err
+	}
+
+	var version historyEntry
+	selector := bson.M{"resourceType": resourceType, "resourceId": id, "versionId": versionID}
+	if err = dal.Database.C(historyCollection).Find(selector).One(&version); err != nil {
+		return nil, convertMongoErr(err)
+	}
+	if version.Method == "DELETE" {
+		return nil, ErrDeleted
+	}
+
+	result = models.NewStructForResourceName(resourceType)
+	if err = version.Resource.Unmarshal(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (dal *mongoDataAccessLayer) History(baseURL url.URL, historyQuery HistoryQuery) (*models.Bundle, error) {
+	options, err := historyQuery.Options()
+	if err != nil {
+		return nil, err
+	}
+
+	selector := bson.M{}
+	if historyQuery.Resource != "" {
+		selector["resourceType"] = historyQuery.Resource
+	}
+	if historyQuery.ID != "" {
+		selector["resourceId"] = historyQuery.ID
+	}
+	if options.Since != nil {
+		selector["lastUpdated"] = bson.M{"$gte": *options.Since}
+	}
+
+	// The history is returned newest first
+	query := dal.Database.C(historyCollection).Find(selector).Sort("-lastUpdated", "-_id")
+	intTotal, err := query.Count()
+	if err != nil {
+		return nil, convertMongoErr(err)
+	}
+	var versions []historyEntry
+	if err = query.Skip(options.Offset).Limit(options.Count).All(&versions); err != nil {
+		return nil, convertMongoErr(err)
+	}
+
+	entryList := make([]models.BundleEntryComponent, len(versions))
+	for i := range versions {
+		entry, err := versions[i].bundleEntry(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		entryList[i] = entry
+	}
+
+	var bundle models.Bundle
+	bundle.Id = bson.NewObjectId().Hex()
+	bundle.Type = "history"
+	bundle.Entry = entryList
+	total := uint32(intTotal)
+	bundle.Total = &total
+	bundle.Link = pagingLinks(baseURL, options.URLQueryParameters(), total)
+
+	return &bundle, nil
+}
+
+func (dal *mongoDataAccessLayer) Everything(baseURL url.URL, everythingQuery EverythingQuery) (*models.Bundle, error) {
+	options, err := everythingQuery.Options()
+	if err != nil {
+		return nil, err
+	}
+
+	var patient interface{}
+	if everythingQuery.ID != "" {
+		if _, err := dal.Get(everythingQuery.ID, "Patient"); err != nil {
+			return nil, err
+		}
+		patient = search.LocalReference{Type: "Patient", ID: everythingQuery.ID}
+	} else {
+		patient = search.ChainedQueryReference{Type: "Patient", ChainedQuery: search.Query{Resource: "Patient"}}
+	}
+
+	// Find the resources in the compartment, starting with the patient(s) themselves
+	resourceTypes := make([]string, 0, len(search.PatientCompartment))
+	for resourceType := range search.PatientCompartment {
+		if resourceType != "Patient" {
+			resourceTypes = append(resourceTypes, resourceType)
+		}
+	}
+	sort.Strings(resourceTypes)
+	resourceTypes = append([]string{"Patient"}, resourceTypes...)
+
+	// Count the resources of each type in the compartment, only loading the ones on the requested page, and collect
+	// the references to resources outside of the compartment (loading only the elements holding references)
+	searcher := search.NewMongoSearcher(dal.Database)
+	var entryList []models.BundleEntryComponent
+	matches := 0
+	referenced := make(map[string]map[string]bool)
+	for _, resourceType := range resourceTypes {
+		compartmentQuery := options.compartmentQuery(resourceType)
+		count, err := searcher.CreatePatientCompartmentQuery(compartmentQuery, patient).Count()
+		if err != nil {
+			return nil, convertMongoErr(err)
+		}
+		if count == 0 {
+			continue
+		}
+
+		skip := options.Offset - matches
+		if skip < 0 {
+			skip = 0
+		}
+		if skip < count && len(entryList) < options.Count {
+			result := models.NewSliceForResourceName(resourceType, 0, 0)
+			query := searcher.CreatePatientCompartmentQuery(compartmentQuery, patient)
+			if err := query.Sort("_id").Skip(skip).Limit(options.Count - len(entryList)).All(result); err != nil {
+				return nil, convertMongoErr(err)
+			}
+			resultVal := reflect.ValueOf(result).Elem()
+			for i := 0; i < resultVal.Len(); i++ {
+				var entry models.BundleEntryComponent
+				entry.Resource = resultVal.Index(i).Addr().Interface()
+				entry.Search = &models.BundleEntrySearchComponent{Mode: "match"}
+				entryList = append(entryList, entry)
+			}
+		}
+		matches += count
+
+		query := searcher.CreatePatientCompartmentQuery(compartmentQuery, patient)
+		if err := addReferencedIDs(query, resourceType, referenced); err != nil {
+			return nil, convertMongoErr(err)
+		}
+	}
+
+	// Then find the referenced resources that exist, only loading the ones on the requested page
+	type includedResource struct {
+		resourceType string
+		id           string
+	}
+	var included []includedResource
+	for _, resourceType := range everythingReferencedTypes {
+		var ids []string
+		for id := range referenced[resourceType] {
+			ids = append(ids, id)
+		}
+		var results []struct {
+			ID string `bson:"_id"`
+		}
+		collection := dal.Database.C(models.PluralizeLowerResourceName(resourceType))
+		if err := collection.Find(bson.M{"_id": bson.M{"$in": ids}}).Select(bson.M{"_id": 1}).Sort("_id").All(&results); err != nil {
+			return nil, convertMongoErr(err)
+		}
+		for i := range results {
+			included = append(included, includedResource{resourceType, results[i].ID})
+		}
+	}
+
+	total := uint32(matches + len(included))
+	skip := options.Offset - matches
+	if skip < 0 {
+		skip = 0
+	}
+	for i := skip; i < len(included) && len(entryList) < options.Count; i++ {
+		resource, err := dal.Get(included[i].id, included[i].resourceType)
+		if err != nil {
+			return nil, err
+		}
+		entryList = append(entryList, models.BundleEntryComponent{
+			Resource: resource,
+			Search:   &models.BundleEntrySearchComponent{Mode: "include"},
+		})
+	}
+
+	var bundle models.Bundle
+	bundle.Id = bson.NewObjectId().Hex()
+	bundle.Type = "searchset"
+	bundle.Entry = entryList
+	bundle.Total = &total
+	bundle.Link = pagingLinks(baseURL, options.URLQueryParameters(), total)
+
+	return &bundle, nil
+}
+
+// addReferencedIDs adds the IDs of the local resources referenced by the resources of the given type found by the
+// query to the referenced IDs, by type.  Only the elements that may hold references are loaded.
+func addReferencedIDs(query *mgo.Query, resourceType string, referenced map[string]map[string]bool) error {
+	projection := bson.M{"_id": 1}
+	for _, path := range referencePaths(reflect.TypeOf(models.StructForResourceName(resourceType)), "", make(map[reflect.Type]bool)) {
+		projection[path] = 1
+	}
+	iter := query.Select(projection).Iter()
+	resource := models.NewStructForResourceName(resourceType)
+	for iter.Next(resource) {
+		for _, ref := range findRefsInValue(reflect.ValueOf(resource)) {
+			if ref.ReferencedID != "" && (ref.External == nil || !*ref.External) {
+				if referenced[ref.Type] == nil {
+					referenced[ref.Type] = make(map[string]bool)
+				}
+				referenced[ref.Type][ref.ReferencedID] = true
+			}
+		}
+		resource = models.NewStructForResourceName(resourceType)
+	}
+	return iter.Close()
+}
+
+// referencePaths returns the BSON paths of the elements of the model type t that may hold references: the references
+// themselves, the extensions (which are stored in a form that can only be loaded whole), and the elements of
+// recursive types (which are also loaded whole).  The visiting types are the types enclosing the element.
+func referencePaths(t reflect.Type, path string, visiting map[reflect.Type]bool) []string {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeOf(models.Reference{}), t == reflect.TypeOf(models.Extension{}):
+		return []string{path}
+	case t.Kind() != reflect.Struct:
+		return nil
+	case visiting[t]:
+		return []string{path}
+	}
+
+	visiting[t] = true
+	defer delete(visiting, t)
+	var paths []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("bson"), ",")
+		switch {
+		case len(tag) > 1 && tag[1] == "inline":
+			paths = append(paths, referencePaths(field.Type, path, visiting)...)
+		case tag[0] != "" && tag[0] != "-":
+			fieldPath := tag[0]
+			if path != "" {
+				fieldPath = path + "." + tag[0]
+			}
+			paths = append(paths, referencePaths(field.Type, fieldPath, visiting)...)
+		}
+	}
+	return paths
+}
+
+// currentVersionID returns the versionId of the current version of the resource with the given ID.  Resources
+// stored before versioning was supported have no versionId, in which case an empty string is returned.
+func (dal *mongoDataAccessLayer) currentVersionID(collection *mgo.Collection, id string) (string, error) {
+	var current struct {
+		Meta *models.Meta `bson:"meta"`
+	}
+	if err := collection.FindId(id).Select(bson.M{"meta.versionId": 1}).One(&current); err != nil {
+		return "", convertMongoErr(err)
+	}
+	if current.Meta == nil {
+		return "", nil
+	}
+	return current.Meta.VersionId, nil
+}
+
+// isDeleted indicates if the most recent version of the resource in the history collection is a deletion
+func (dal *mongoDataAccessLayer) isDeleted(resourceType, id string) bool {
+	latest, err := dal.latestVersion(resourceType, id)
+	return err == nil && latest.Method == "DELETE"
+}
+
+// latestVersionID returns the versionId of the most recent version of the resource in the history collection, or an
+// empty string if the resource has no history (i.e., it never existed).
+func (dal *mongoDataAccessLayer) latestVersionID(resourceType, id string) (string, error) {
+	latest, err := dal.latestVersion(resourceType, id)
+	if err == mgo.ErrNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", convertMongoErr(err)
+	}
+	return latest.VersionID, nil
+}
+
+// latestVersion returns the most recent version of the resource in the history collection, without its content
+func (dal *mongoDataAccessLayer) latestVersion(resourceType, id string) (*historyEntry, error) {
+	var latest historyEntry
+	selector := bson.M{"resourceType": resourceType, "resourceId": id}
+	err := dal.Database.C(historyCollection).Find(selector).Sort("-lastUpdated", "-_id").Select(bson.M{"resource": 0}).One(&latest)
+	if err != nil {
+		return nil, err
+	}
+	return &latest, nil
+}
+
+// saveVersion records a snapshot of the resource (as it was just written) in the history collection.  If a
+// transaction is in progress, the change is also recorded in its journal, along with the previous state of the
+// resource (or nil if it didn't exist).  The change is journaled first, since the resource has already been written
+// even if recording the version fails.
+func (dal *mongoDataAccessLayer) saveVersion(resourceType, id, method string, resource interface{}, previous *bson.Raw) error {
+	meta, _ := models.GetResourceMeta(resource)
+	version := &historyEntry{
+		ID:           bson.NewObjectId(),
+		ResourceType: resourceType,
+		ResourceID:   id,
+		VersionID:    meta.VersionId,
+		Method:       method,
+		LastUpdated:  meta.LastUpdated.Time,
+	}
+	dal.journal.record(version, previous)
+
+	data, err := bson.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	version.Resource = bson.Raw{Kind: 0x03, Data: data}
+	if err = dal.Database.C(historyCollection).Insert(version); err != nil {
+		return convertMongoErr(err)
+	}
+	return nil
+}
+
+// saveDeletedVersion records the deletion of a resource in the history collection.  Deletions are versions without
+// any resource content.  If a transaction is in progress, the change is also recorded in its journal (before the
+// version, as in saveVersion), along with the deleted resource.
+func (dal *mongoDataAccessLayer) saveDeletedVersion(resourceType, id, versionID string, previous *bson.Raw) error {
+	version := &historyEntry{
+		ID:           bson.NewObjectId(),
+		ResourceType: resourceType,
+		ResourceID:   id,
+		VersionID:    versionID,
+		Method:       "DELETE",
+		LastUpdated:  time.Now(),
+	}
+	dal.journal.record(version, previous)
+	if err := dal.Database.C(historyCollection).Insert(version); err != nil {
+		return convertMongoErr(err)
+	}
+	return nil
+}
+
+// historyCollection is the name of the collection holding every version of every resource
+const historyCollection = "history"
+
+// historyEntry represents a single version of a resource as stored in the history collection.  The Method indicates
+// the interaction that created the version (POST, PUT or DELETE).
+type historyEntry struct {
+	ID           bson.ObjectId `bson:"_id"`
+	ResourceType string        `bson:"resourceType"`
+	ResourceID   string        `bson:"resourceId"`
+	VersionID    string        `bson:"versionId"`
+	Method       string        `bson:"method"`
+	LastUpdated  time.Time     `bson:"lastUpdated"`
+	Resource     bson.Raw      `bson:"resource,omitempty"`
+}
+
+func (h *historyEntry) bundleEntry(baseURL url.URL) (models.BundleEntryComponent, error) {
+	var entry models.BundleEntryComponent
+	baseURL.Path = fmt.Sprintf("/%s/%s", h.ResourceType, h.ResourceID)
+	baseURL.RawQuery = ""
+	entry.FullUrl = baseURL.String()
+	entry.Request = &models.BundleEntryRequestComponent{Method: h.Method, Url: h.ResourceType + "/" + h.ResourceID}
+	entry.Response = &models.BundleEntryResponseComponent{
+		LastModified: &models.FHIRDateTime{Time: h.LastUpdated, Precision: models.Timestamp},
+	}
+
+	switch h.Method {
+	case "POST":
+		entry.Request.Url = h.ResourceType
+		entry.Response.Status = "201"
+	case "PUT":
+		entry.Response.Status = "200"
+	case "DELETE":
+		entry.Response.Status = "204"
+		return entry, nil
+	}
+
+	entry.Resource = models.NewStructForResourceName(h.ResourceType)
+	if err := h.Resource.Unmarshal(entry.Resource); err != nil {
+		return entry, err
+	}
+	return entry, nil
+}
+
+// versionMatches indicates if the current version of a resource satisfies an If-Match precondition's version
+func versionMatches(currentVersion, ifMatch string) bool {
+	return ifMatch == AnyVersion || currentVersion == ifMatch
+}
+
+// versionSelector selects the resource with the given ID only if its current version is the given version
+func versionSelector(id, versionID string) bson.M {
+	if versionID == "" {
+		return bson.M{"_id": id, "meta.versionId": bson.M{"$exists": false}}
+	}
+	return bson.M{"_id": id, "meta.versionId": versionID}
+}
+
 // ResourcePlusRelatedResources is an interface to capture those structs that implement the functions for
 // getting included and rev-included resources
 type ResourcePlusRelatedResources interface {
@@ -226,8 +896,11 @@ type ResourcePlusRelatedResources interface {
 }
 
 func generatePagingLinks(baseURL url.URL, query search.Query, total uint32) []models.BundleLinkComponent {
+	return pagingLinks(baseURL, query.URLQueryParameters(true), total)
+}
+
+func pagingLinks(baseURL url.URL, params search.URLQueryParameters, total uint32) []models.BundleLinkComponent {
 	links := make([]models.BundleLinkComponent, 0, 5)
-	params := query.URLQueryParameters(true)
 	offset := 0
 	if pOffset := params.Get(search.OffsetParam); pOffset != "" {
 		offset, _ = strconv.Atoi(pOffset)
@@ -287,14 +960,15 @@ func newLink(relation string, baseURL url.URL, params search.URLQueryParameters,
 	return models.BundleLinkComponent{Relation: relation, Url: baseURL.String()}
 }
 
-func convertIDToBsonID(id string) (bson.ObjectId, error) {
-	if bson.IsObjectIdHex(id) {
-		return bson.ObjectIdHex(id), nil
+// validateID returns an OperationOutcome error if the ID is not a valid FHIR resource ID
+func validateID(id string) error {
+	if !idPattern.MatchString(id) {
+		return models.NewOperationOutcome("fatal", "exception", "Id must be 1 to 64 letters, digits, '-', or '.'")
 	}
-	return bson.ObjectId(""), models.NewOperationOutcome("fatal", "exception", "Id must be a valid BSON ObjectId")
+	return nil
 }
 
-func updateLastUpdatedDate(resource interface{}) {
+func updateResourceMeta(resource interface{}, versionID string) {
 	m := reflect.ValueOf(resource).Elem().FieldByName("Meta")
 	if m.IsNil() {
 		newMeta := &models.Meta{}
@@ -302,6 +976,7 @@ func updateLastUpdatedDate(resource interface{}) {
 	}
 	now := &models.FHIRDateTime{Time: time.Now(), Precision: models.Timestamp}
 	m.Elem().FieldByName("LastUpdated").Set(reflect.ValueOf(now))
+	m.Elem().FieldByName("VersionId").SetString(versionID)
 }
 
 func convertMongoErr(err error) error {
@@ -312,3 +987,21 @@ func convertMongoErr(err error) error {
 		return ErrNotFound
 	}
 }
+
+// mongoIndexNotFound is the code of the error Mongo returns when a query needs an index that doesn't exist, such as
+// a full-text search of a collection without a text index
+const mongoIndexNotFound = 27
+
+// convertSearchErr converts the errors of searches on the resource type.  Full-text searches of a collection without
+// a text index fail in Mongo, so they are rejected with a search.Error explaining that the collection needs one.
+func convertSearchErr(err error, resourceType string) error {
+	if qe, ok := err.(*mgo.QueryError); (ok && qe.Code == mongoIndexNotFound) || strings.Contains(err.Error(), "text index required") {
+		collection := models.PluralizeLowerResourceName(resourceType)
+		return &search.Error{
+			HTTPStatus: http.StatusBadRequest,
+			OperationOutcome: models.NewOperationOutcome("error", "not-supported",
+				fmt.Sprintf("Full-text searches of %s are not supported: the %s collection has no text index", resourceType, collection)),
+		}
+	}
+	return convertMongoErr(err)
+}
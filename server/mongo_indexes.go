@@ -153,7 +153,9 @@ func parseCompoundIndex(indexSpec string) (*mgo.Index, error) {
 }
 
 // parseIndexKey converts the standard mongo index key format: "<key>_(-)1"
-// to the format used by mgo.Index: "(-)<key>"
+// to the format used by mgo.Index: "(-)<key>".  Text index keys, of the format
+// "<key>_text", are converted to "$text:<key>".  Use "$**_text" to index the
+// text of every field.
 func parseIndexKey(spec string) string {
 
 	keyAndDirection := strings.Split(spec, "_")
@@ -163,8 +165,11 @@ func parseIndexKey(spec string) string {
 	}
 
 	direction := ""
-	if keyAndDirection[1] == "-1" {
+	switch keyAndDirection[1] {
+	case "-1":
 		direction = "-"
+	case "text":
+		direction = "$text:"
 	}
 	return fmt.Sprintf("%s%s", direction, keyAndDirection[0])
 }
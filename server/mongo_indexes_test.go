@@ -65,7 +65,7 @@ func (s *MongoIndexesTestSuite) SetupSuite() {
 
 	// Build routes for testing
 	s.Engine = gin.New()
-	RegisterRoutes(s.Engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.Database), s.Config)
+	RegisterServerRoutes(s.Engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.Database), s.Config)
 
 	// Create httptest server
 	s.Server = httptest.NewServer(s.Engine)
@@ -157,6 +157,29 @@ func (s *MongoIndexesTestSuite) TestParseIndexCompoundIndexMixed() {
 	s.Equal(index.Key[1], "bar", "The second index key should be 'bar'")
 }
 
+func (s *MongoIndexesTestSuite) TestParseIndexTextIndex() {
+
+	indexStr := "testcollection.$**_text"
+	collectionName, index, err := parseIndex(indexStr)
+
+	s.Nil(err, "Should return without error")
+	s.Equal(collectionName, "testcollection", "Collection name should be 'testcollection'")
+	s.Equal(len(index.Key), 1, "The created index should contain one key")
+	s.Equal(index.Key[0], "$text:$**", "The index key should be '$text:$**'")
+}
+
+func (s *MongoIndexesTestSuite) TestParseIndexCompoundTextIndex() {
+
+	indexStr := "testcollection.(text.div_text, note.text_text)"
+	collectionName, index, err := parseIndex(indexStr)
+
+	s.Nil(err, "Should return without error")
+	s.Equal(collectionName, "testcollection", "Collection name should be 'testcollection'")
+	s.Equal(len(index.Key), 2, "The created index should contain 2 keys")
+	s.Equal(index.Key[0], "$text:text.div", "The first index key should be '$text:text.div'")
+	s.Equal(index.Key[1], "$text:note.text", "The second index key should be '$text:note.text'")
+}
+
 func (s *MongoIndexesTestSuite) TestParseIndexNoIndex() {
 
 	indexStr := ""
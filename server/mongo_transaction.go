@@ -0,0 +1,93 @@
+package server
+
+import (
+	"github.com/intervention-engine/fhir/models"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// StartTransaction returns a Transaction that journals each change so it can be undone.  Mongo does not support
+// multi-document transactions, so a rollback is performed by restoring the previous state of each changed resource
+// and removing the versions the transaction added to the history.  A transaction started within a transaction acts
+// as a savepoint: rolling it back only undoes its own changes, and committing it leaves them to the outer transaction.
+func (dal *mongoDataAccessLayer) StartTransaction() Transaction {
+	if dal.journal != nil {
+		return &mongoTransaction{mongoDataAccessLayer: dal, start: len(dal.journal.changes), nested: true}
+	}
+	return &mongoTransaction{mongoDataAccessLayer: &mongoDataAccessLayer{Database: dal.Database, idGenerator: dal.idGenerator, journal: &transactionJournal{}}}
+}
+
+type mongoTransaction struct {
+	*mongoDataAccessLayer
+	start  int
+	nested bool
+}
+
+func (t *mongoTransaction) Commit() {
+	if !t.nested {
+		t.journal.changes = nil
+	}
+}
+
+func (t *mongoTransaction) Rollback() error {
+	for i := len(t.journal.changes) - 1; i >= t.start; i-- {
+		change := t.journal.changes[i]
+		collection := t.Database.C(models.PluralizeLowerResourceName(change.resourceType))
+		var err error
+		if change.previous != nil {
+			_, err = collection.UpsertId(change.resourceID, *change.previous)
+		} else if err = collection.RemoveId(change.resourceID); err == mgo.ErrNotFound {
+			err = nil
+		}
+		if err != nil {
+			return convertMongoErr(err)
+		}
+		if err = t.Database.C(historyCollection).RemoveId(change.versionID); err != nil && err != mgo.ErrNotFound {
+			return convertMongoErr(err)
+		}
+		t.journal.changes = t.journal.changes[:i]
+	}
+	return nil
+}
+
+// transactionJournal records the changes made within a transaction so that they can be undone.  Methods on a nil
+// journal (i.e., when no transaction is in progress) do nothing.
+type transactionJournal struct {
+	changes []journaledChange
+}
+
+// journaledChange represents a single change to a resource.  The versionID is the ID of the history entry created by
+// the change (which may not exist, if recording the version failed), and previous is the state of the resource before
+// the change (or nil if it didn't exist).
+type journaledChange struct {
+	resourceType string
+	resourceID   string
+	versionID    bson.ObjectId
+	previous     *bson.Raw
+}
+
+// snapshot returns the current state of the resource with the given ID, or nil if it doesn't exist.
+func (j *transactionJournal) snapshot(collection *mgo.Collection, id string) (*bson.Raw, error) {
+	if j == nil {
+		return nil, nil
+	}
+	var current bson.Raw
+	if err := collection.FindId(id).One(&current); err == mgo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+func (j *transactionJournal) record(version *historyEntry, previous *bson.Raw) {
+	if j == nil {
+		return
+	}
+	j.changes = append(j.changes, journaledChange{
+		resourceType: version.ResourceType,
+		resourceID:   version.ResourceID,
+		versionID:    version.ID,
+		previous:     previous,
+	})
+}
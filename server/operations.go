@@ -0,0 +1,461 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/intervention-engine/fhir/models"
+	"github.com/intervention-engine/fhir/search"
+)
+
+// Operation is an extended operation ($operation) supported by the server.  The definition describes the operation:
+// its code (e.g., "match" for $match), the levels it may be invoked at (system, the resource types listed in Type,
+// and/or instances of those types), and its input and output parameters.  Requests are validated against the
+// definition's input parameters before the handler is called.
+type Operation struct {
+	Definition *models.OperationDefinition
+	Handler    OperationHandler
+}
+
+// OperationHandler executes an operation.  The returned output is either a Parameters resource or, for operations
+// that return a single resource, the resource itself.  A Parameters resource containing only a "return" resource
+// parameter is unwrapped and the resource is returned as-is.  Errors are reported using the same conventions as the
+// DataAccessLayer (e.g., ErrNotFound), an OperationOutcome (400), or an OperationError.
+type OperationHandler func(request *OperationRequest) (interface{}, error)
+
+// OperationRequest contains the details of a request to invoke an operation.  ResourceType is blank for system level
+// invocations, and ID is blank unless the operation was invoked on an instance.  Handlers for instance level
+// operations are responsible for checking that the instance exists.
+type OperationRequest struct {
+	Context      *gin.Context
+	DAL          DataAccessLayer
+	ResourceType string
+	ID           string
+	Parameters   *models.Parameters
+	// body is the decoded request body, if any, which preserves the elements of resource parameters exactly as sent
+	body map[string]interface{}
+}
+
+// OperationError is returned by an operation that fails.  It contains the HTTP status to respond with and an
+// OperationOutcome describing the problem.
+type OperationError struct {
+	HTTPStatus       int
+	OperationOutcome *models.OperationOutcome
+}
+
+func (e *OperationError) Error() string {
+	return e.OperationOutcome.Issue[0].Diagnostics
+}
+
+// NewOperationError returns an OperationError with a single issue.  The issue type is chosen based on the status.
+func NewOperationError(status int, format string, args ...interface{}) *OperationError {
+	return &OperationError{
+		HTTPStatus:       status,
+		OperationOutcome: models.NewOperationOutcome("error", issueTypeForStatus(status), fmt.Sprintf(format, args...)),
+	}
+}
+
+// Get returns the first parameter with the given name, or nil if there is none.
+func (r *OperationRequest) Get(name string) *models.ParametersParameterComponent {
+	for i := range r.Parameters.Parameter {
+		if r.Parameters.Parameter[i].Name == name {
+			return &r.Parameters.Parameter[i]
+		}
+	}
+	return nil
+}
+
+// resource returns the named resource parameter as it was sent in the request body (i.e., decoded into generic maps
+// and slices rather than a model), or nil if there is no such parameter.  If the body is the resource itself, rather
+// than a Parameters resource, it is returned for any name.
+func (r *OperationRequest) resource(name string) interface{} {
+	if r.body == nil {
+		return nil
+	} else if r.body["resourceType"] != "Parameters" {
+		return r.body
+	}
+	params, _ := r.body["parameter"].([]interface{})
+	for _, p := range params {
+		if p, ok := p.(map[string]interface{}); ok && p["name"] == name {
+			return p["resource"]
+		}
+	}
+	return nil
+}
+
+var operationRegistry *OperationRegistry
+var operationRegistryOnce sync.Once
+
+// GlobalOperationRegistry returns an instance of the global operation registry.  Operations must be registered before
+// the server's routes are registered (i.e., before the server is run).
+func GlobalOperationRegistry() *OperationRegistry {
+	operationRegistryOnce.Do(func() {
+		operationRegistry = new(OperationRegistry)
+		operationRegistry.operations = make(map[string]Operation)
+	})
+	return operationRegistry
+}
+
+// OperationRegistry supports the registration and lookup of extended operations, keyed by their code.
+type OperationRegistry struct {
+	lock       sync.RWMutex
+	operations map[string]Operation
+}
+
+// RegisterOperation registers an operation, replacing any operation previously registered with the same code.  An
+// error is returned if the operation has no handler or its definition has no code or cannot be invoked at any level.
+func (r *OperationRegistry) RegisterOperation(op Operation) error {
+	switch {
+	case op.Definition == nil || op.Definition.Code == "":
+		return fmt.Errorf("An operation must have a definition with a code")
+	case op.Handler == nil:
+		return fmt.Errorf("Operation $%s has no handler", op.Definition.Code)
+	case !op.IsSystem() && len(op.Definition.Type) == 0:
+		return fmt.Errorf("Operation $%s cannot be invoked at the system level or on any resource type", op.Definition.Code)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.operations[op.Definition.Code] = op
+	return nil
+}
+
+// LookupOperation looks up an operation by code.  If no operation is registered, it will return an error.
+func (r *OperationRegistry) LookupOperation(code string) (Operation, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	op, ok := r.operations[code]
+	if !ok {
+		return Operation{}, fmt.Errorf("Could not find operation $%s", code)
+	}
+	return op, nil
+}
+
+// Operations returns all of the registered operations, sorted by code.
+func (r *OperationRegistry) Operations() []Operation {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	ops := make([]Operation, 0, len(r.operations))
+	for _, op := range r.operations {
+		ops = append(ops, op)
+	}
+	sort.Sort(byOperationCode(ops))
+	return ops
+}
+
+// IsSystem indicates if the operation can be invoked at the system level.
+func (op Operation) IsSystem() bool {
+	return op.Definition.System != nil && *op.Definition.System
+}
+
+// IsType indicates if the operation can be invoked at the type level for the given resource type.
+func (op Operation) IsType(resourceType string) bool {
+	for _, t := range op.Definition.Type {
+		if t == resourceType || t == "Resource" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsInstance indicates if the operation can be invoked on instances of the given resource type.
+func (op Operation) IsInstance(resourceType string) bool {
+	return op.Definition.Instance != nil && *op.Definition.Instance && op.IsType(resourceType)
+}
+
+// OperationController handles requests to invoke system level operations.
+type OperationController struct {
+	DAL    DataAccessLayer
+	Config Config
+}
+
+// NewOperationController creates a new OperationController based on the passed in DataAccessLayer.
+func NewOperationController(dal DataAccessLayer) *OperationController {
+	return &OperationController{DAL: dal}
+}
+
+// Handler handles requests to invoke a system level operation.  The operation is identified by the last segment of the
+// request path (e.g., /$match).
+func (o *OperationController) Handler(c *gin.Context) {
+	invokeOperation(c, o.DAL, o.Config, strings.TrimPrefix(path.Base(c.Request.URL.Path), "$"), "", "")
+}
+
+// serverOperations returns the operations supported by a server with the given config, sorted by code: the
+// registered operations and the built-in operations the config enables.
+func serverOperations(config Config) []Operation {
+	if !config.EnableValidateOperation {
+		return GlobalOperationRegistry().Operations()
+	}
+	ops := []Operation{newValidateOperation()}
+	for _, op := range GlobalOperationRegistry().Operations() {
+		if op.Definition.Code != "validate" {
+			ops = append(ops, op)
+		}
+	}
+	sort.Sort(byOperationCode(ops))
+	return ops
+}
+
+// lookupServerOperation looks up an operation supported by a server with the given config by code.  The built-in
+// operations the config enables take precedence over registered operations with the same code.
+func lookupServerOperation(code string, config Config) (Operation, error) {
+	if code == "validate" && config.EnableValidateOperation {
+		return newValidateOperation(), nil
+	}
+	return GlobalOperationRegistry().LookupOperation(code)
+}
+
+// invokeOperation parses the request's parameters, invokes the operation, and responds with its output.
+func invokeOperation(c *gin.Context, dal DataAccessLayer, config Config, code, resourceType, id string) {
+	c.Set("Action", "operation")
+
+	op, err := lookupServerOperation(code, config)
+	switch {
+	case err != nil,
+		resourceType == "" && !op.IsSystem(),
+		resourceType != "" && id == "" && !op.IsType(resourceType),
+		id != "" && !op.IsInstance(resourceType):
+		outcome := models.NewOperationOutcome("error", "not-supported", fmt.Sprintf("Operation $%s is not supported here", code))
+		c.JSON(http.StatusNotFound, outcome)
+		return
+	}
+
+	request := &OperationRequest{Context: c, DAL: dal, ResourceType: resourceType, ID: id}
+	err = op.parseParameters(c, request)
+	if err == nil {
+		err = op.validateParameters(request.Parameters.Parameter, op.Definition.Parameter, "")
+	}
+	if err == nil {
+		var output interface{}
+		output, err = op.Handler(request)
+		if err == nil {
+			c.JSON(http.StatusOK, operationResponse(output))
+			return
+		}
+	}
+
+	status := statusForError(err)
+	if oe, ok := err.(*OperationError); ok {
+		c.JSON(oe.HTTPStatus, oe.OperationOutcome)
+	} else if oo, ok := err.(*models.OperationOutcome); ok {
+		c.JSON(status, oo)
+	} else if status == http.StatusInternalServerError {
+		c.AbortWithError(status, err)
+	} else {
+		c.JSON(status, models.NewOperationOutcome("error", issueTypeForStatus(status), err.Error()))
+	}
+}
+
+// operationResponse unwraps a Parameters resource containing only a "return" resource parameter
+func operationResponse(output interface{}) interface{} {
+	if params, ok := output.(*models.Parameters); ok && len(params.Parameter) == 1 {
+		if p := params.Parameter[0]; p.Name == "return" && p.Resource != nil {
+			return p.Resource
+		}
+	}
+	return output
+}
+
+// parseParameters parses the operation's input parameters from the request.  A GET request passes the parameters in
+// the query string, which is only allowed for operations that do not change state and whose inputs are primitives.  A
+// POST request passes them in a Parameters resource or, for operations with a single resource input, as the resource
+// itself.
+func (op Operation) parseParameters(c *gin.Context, request *OperationRequest) error {
+	params := &models.Parameters{}
+	params.ResourceType = "Parameters"
+	request.Parameters = params
+
+	if c.Request.Method == "GET" {
+		if op.Definition.Idempotent == nil || !*op.Definition.Idempotent {
+			return NewOperationError(http.StatusMethodNotAllowed, "Operation $%s must be invoked using POST", op.Definition.Code)
+		}
+		queryParams, err := search.ParseQuery(c.Request.URL.RawQuery)
+		if err != nil {
+			return NewOperationError(http.StatusBadRequest, "%s", err.Error())
+		}
+		for _, qp := range queryParams.All() {
+			if qp.Key == search.FormatParam {
+				continue
+			}
+			p, err := op.parseQueryParameter(qp.Key, qp.Value)
+			if err != nil {
+				return err
+			}
+			params.Parameter = append(params.Parameter, *p)
+		}
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return nil
+	}
+	if strings.Contains(c.ContentType(), "xml") {
+		if body, err = ConvertXMLToJSON(body); err != nil {
+			return NewOperationError(http.StatusBadRequest, "Invalid XML: %s", err.Error())
+		}
+	}
+	var resource map[string]interface{}
+	if err := json.Unmarshal(body, &resource); err != nil {
+		return NewOperationError(http.StatusBadRequest, "Invalid JSON: %s", err.Error())
+	}
+	request.body = resource
+	if resource["resourceType"] == "Parameters" {
+		if err := json.Unmarshal(body, params); err != nil {
+			return NewOperationError(http.StatusBadRequest, "Invalid Parameters: %s", err.Error())
+		}
+		return nil
+	}
+
+	// The body is a resource, which is only allowed if it is the operation's single resource input
+	var inputs []models.OperationDefinitionParameterComponent
+	for _, def := range op.Definition.Parameter {
+		if def.Use == "in" && isResourceParameterType(def.Type) {
+			inputs = append(inputs, def)
+		}
+	}
+	resourceType, _ := resource["resourceType"].(string)
+	if len(inputs) != 1 || models.StructForResourceName(resourceType) == nil {
+		return NewOperationError(http.StatusBadRequest, "The request body must be a Parameters resource")
+	}
+	params.Parameter = []models.ParametersParameterComponent{{Name: inputs[0].Name, Resource: models.MapToResource(resource, true)}}
+	return nil
+}
+
+// parseQueryParameter converts a query parameter to an operation parameter, based on its type in the definition
+func (op Operation) parseQueryParameter(name, value string) (*models.ParametersParameterComponent, error) {
+	def := findParameterDefinition(op.Definition.Parameter, name)
+	if def == nil || def.Use != "in" {
+		return nil, NewOperationError(http.StatusBadRequest, "Unknown parameter %s", name)
+	}
+	var data string
+	switch def.Type {
+	case "boolean", "integer", "decimal", "positiveInt", "unsignedInt":
+		data = value
+	case "string", "code", "id", "uri", "oid", "markdown", "base64Binary", "date", "dateTime", "instant", "time":
+		quoted, _ := json.Marshal(value)
+		data = string(quoted)
+	default:
+		return nil, NewOperationError(http.StatusBadRequest, "Parameter %s cannot be passed in the URL", name)
+	}
+
+	p := &models.ParametersParameterComponent{}
+	field := "value" + strings.ToUpper(def.Type[:1]) + def.Type[1:]
+	if err := json.Unmarshal([]byte(fmt.Sprintf(`{"name": %q, %q: %s}`, name, field, data)), p); err != nil {
+		return nil, NewOperationError(http.StatusBadRequest, "Parameter %s content is invalid", name)
+	}
+	return p, nil
+}
+
+// validateParameters checks the input parameters (or the parts of a parameter) against their definitions, checking
+// that each parameter is defined, has the expected type, and occurs the allowed number of times
+func (op Operation) validateParameters(params []models.ParametersParameterComponent, defs []models.OperationDefinitionParameterComponent, parent string) error {
+	counts := make(map[string]int)
+	for _, p := range params {
+		name := parent + p.Name
+		def := findParameterDefinition(defs, p.Name)
+		if def == nil || (parent == "" && def.Use != "in") {
+			return NewOperationError(http.StatusBadRequest, "Unknown parameter %s", name)
+		}
+		counts[p.Name]++
+
+		if len(def.Part) > 0 {
+			if err := op.validateParameters(p.Part, def.Part, name+"."); err != nil {
+				return err
+			}
+			continue
+		}
+		if isResourceParameterType(def.Type) {
+			resourceType := ""
+			if p.Resource != nil {
+				resourceType = reflect.Indirect(reflect.ValueOf(p.Resource)).Type().Name()
+			}
+			if resourceType == "" || (def.Type != "Resource" && def.Type != "Any" && def.Type != resourceType) {
+				return NewOperationError(http.StatusBadRequest, "Parameter %s must be a %s resource", name, def.Type)
+			}
+		} else if def.Type != "" && def.Type != "Any" && parameterValueType(p) != def.Type {
+			return NewOperationError(http.StatusBadRequest, "Parameter %s must be of type %s", name, def.Type)
+		}
+	}
+
+	for _, def := range defs {
+		if parent == "" && def.Use != "in" {
+			continue
+		}
+		if def.Min != nil && counts[def.Name] < int(*def.Min) {
+			return NewOperationError(http.StatusBadRequest, "Parameter %s%s is required", parent, def.Name)
+		}
+		if max, err := strconv.Atoi(def.Max); err == nil && counts[def.Name] > max {
+			return NewOperationError(http.StatusBadRequest, "Parameter %s%s may occur at most %d time(s)", parent, def.Name, max)
+		}
+	}
+	return nil
+}
+
+// findParameterDefinition returns the definition of the named parameter, or nil if it is not defined
+func findParameterDefinition(defs []models.OperationDefinitionParameterComponent, name string) *models.OperationDefinitionParameterComponent {
+	for i := range defs {
+		if defs[i].Name == name {
+			return &defs[i]
+		}
+	}
+	return nil
+}
+
+// isResourceParameterType indicates if a parameter type is a resource rather than a data type
+func isResourceParameterType(paramType string) bool {
+	return paramType == "Resource" || models.StructForResourceName(paramType) != nil
+}
+
+// parameterValueType returns the FHIR type of the parameter's value[x] (e.g., "string" or "CodeableConcept"), or an
+// empty string if it has no value
+func parameterValueType(p models.ParametersParameterComponent) string {
+	v := reflect.ValueOf(p)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !strings.HasPrefix(field.Name, "Value") || isZero(v.Field(i)) {
+			continue
+		}
+		valueType := strings.TrimPrefix(field.Name, "Value")
+		if primitive := strings.ToLower(valueType[:1]) + valueType[1:]; primitiveParameterTypes[primitive] {
+			return primitive
+		}
+		return valueType
+	}
+	return ""
+}
+
+// isZero indicates if a value[x] field is unset
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.String:
+		return v.Len() == 0
+	}
+	return false
+}
+
+// primitiveParameterTypes are the FHIR primitive types a parameter's value[x] may have
+var primitiveParameterTypes = map[string]bool{
+	"base64Binary": true, "boolean": true, "code": true, "date": true, "dateTime": true, "decimal": true, "id": true,
+	"instant": true, "integer": true, "markdown": true, "oid": true, "positiveInt": true, "string": true, "time": true,
+	"unsignedInt": true, "uri": true,
+}
+
+type byOperationCode []Operation
+
+func (o byOperationCode) Len() int           { return len(o) }
+func (o byOperationCode) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o byOperationCode) Less(i, j int) bool { return o[i].Definition.Code < o[j].Definition.Code }
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type OperationSuite struct {
+	Engine *gin.Engine
+}
+
+var _ = Suite(&OperationSuite{})
+
+func (o *OperationSuite) SetUpSuite(c *C) {
+	yes := true
+	one := int32(1)
+	definition := &models.OperationDefinition{
+		Url:        "http://example.org/OperationDefinition/echo",
+		Code:       "echo",
+		Kind:       "operation",
+		Idempotent: &yes,
+		System:     &yes,
+		Type:       []string{"Patient"},
+		Instance:   &yes,
+		Parameter: []models.OperationDefinitionParameterComponent{
+			{Name: "message", Use: "in", Min: &one, Max: "1", Type: "string"},
+			{Name: "count", Use: "in", Max: "1", Type: "integer"},
+			{Name: "return", Use: "out", Min: &one, Max: "1", Type: "Parameters"},
+		},
+	}
+	util.CheckErr(GlobalOperationRegistry().RegisterOperation(Operation{
+		Definition: definition,
+		Handler: func(r *OperationRequest) (interface{}, error) {
+			if r.ID == "missing" {
+				return nil, ErrNotFound
+			}
+			message := r.Get("message").ValueString
+			if count := r.Get("count"); count != nil {
+				message = strings.Repeat(message, int(*count.ValueInteger))
+			}
+			output := &models.Parameters{Parameter: []models.ParametersParameterComponent{
+				{Name: "message", ValueString: message},
+				{Name: "target", ValueString: r.ResourceType + "/" + r.ID},
+			}}
+			output.ResourceType = "Parameters"
+			return output, nil
+		},
+	}))
+
+	gin.SetMode(gin.ReleaseMode)
+	o.Engine = gin.New()
+	o.Engine.Use(NegotiateFormat)
+	RegisterServerRoutes(o.Engine, make(map[string][]gin.HandlerFunc), nil, DefaultConfig)
+}
+
+func (o *OperationSuite) invoke(method, path, body string) (int, map[string]interface{}) {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	util.CheckErr(err)
+	req.Header.Set("Content-Type", "application/json+fhir")
+	w := httptest.NewRecorder()
+	o.Engine.ServeHTTP(w, req)
+
+	var result map[string]interface{}
+	if w.Body.Len() > 0 {
+		util.CheckErr(json.Unmarshal(w.Body.Bytes(), &result))
+	}
+	return w.Code, result
+}
+
+func (o *OperationSuite) TestInvokeAtEachLevel(c *C) {
+	paths := map[string]string{
+		"/$echo?message=hi":             "/",
+		"/Patient/$echo?message=hi":     "Patient/",
+		"/Patient/123/$echo?message=hi": "Patient/123",
+	}
+	for path, target := range paths {
+		status, result := o.invoke("GET", path, "")
+		c.Assert(status, Equals, http.StatusOK)
+		c.Assert(result["resourceType"], Equals, "Parameters")
+		params := result["parameter"].([]interface{})
+		c.Assert(params, HasLen, 2)
+		c.Assert(params[0].(map[string]interface{})["valueString"], Equals, "hi")
+		c.Assert(params[1].(map[string]interface{})["valueString"], Equals, target)
+	}
+
+	status, result := o.invoke("POST", "/Patient/123/$echo", `{
+		"resourceType": "Parameters",
+		"parameter": [{"name": "message", "valueString": "ab"}, {"name": "count", "valueInteger": 2}]
+	}`)
+	c.Assert(status, Equals, http.StatusOK)
+	params := result["parameter"].([]interface{})
+	c.Assert(params[0].(map[string]interface{})["valueString"], Equals, "abab")
+
+	status, _ = o.invoke("GET", "/Patient/missing/$echo?message=hi", "")
+	c.Assert(status, Equals, http.StatusNotFound)
+}
+
+func (o *OperationSuite) TestInvalidInvocations(c *C) {
+	// Not supported on observations
+	status, result := o.invoke("GET", "/Observation/$echo?message=hi", "")
+	c.Assert(status, Equals, http.StatusNotFound)
+	c.Assert(result["resourceType"], Equals, "OperationOutcome")
+
+	// Not a registered operation
+	status, _ = o.invoke("POST", "/Patient/$frobnicate", "")
+	c.Assert(status, Equals, http.StatusNotFound)
+
+	// POST /Type/:id is only used for operations
+	status, _ = o.invoke("POST", "/Patient/123", "{}")
+	c.Assert(status, Equals, http.StatusMethodNotAllowed)
+
+	invalid := map[string]string{
+		"/$echo":                            "Parameter message is required",
+		"/$echo?message=hi&message=there":   "Parameter message may occur at most 1 time\\(s\\)",
+		"/$echo?message=hi&volume=11":       "Unknown parameter volume",
+		"/$echo?message=hi&count=lots":      "Parameter count content is invalid",
+		"/Patient/$echo?message=hi&return=": "Unknown parameter return",
+	}
+	for path, diagnostics := range invalid {
+		status, result = o.invoke("GET", path, "")
+		c.Assert(status, Equals, http.StatusBadRequest)
+		issue := result["issue"].([]interface{})[0].(map[string]interface{})
+		c.Assert(issue["diagnostics"], Matches, diagnostics)
+	}
+
+	status, result = o.invoke("POST", "/$echo", `{
+		"resourceType": "Parameters",
+		"parameter": [{"name": "message", "valueInteger": 2}]
+	}`)
+	c.Assert(status, Equals, http.StatusBadRequest)
+	issue := result["issue"].([]interface{})[0].(map[string]interface{})
+	c.Assert(issue["diagnostics"], Equals, "Parameter message must be of type string")
+
+	status, _ = o.invoke("POST", "/$echo", `{"resourceType": "Patient"}`)
+	c.Assert(status, Equals, http.StatusBadRequest)
+}
+
+func (o *OperationSuite) TestConformanceOperations(c *C) {
+	conformance := NewMetadataController(o.Engine, DefaultConfig).Conformance()
+	operations := conformance.Rest[0].Operation
+	c.Assert(operations, HasLen, 3)
+	c.Assert(operations[0].Name, Equals, "everything")
+	c.Assert(operations[1].Name, Equals, "echo")
+	c.Assert(operations[1].Definition.Reference, Equals, "http://example.org/OperationDefinition/echo")
+	c.Assert(operations[2].Name, Equals, "validate")
+}
@@ -0,0 +1,697 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/intervention-engine/fhir/models"
+)
+
+// Patch is a set of changes to a resource, expressed either as a JSON Patch document (RFC 6902) or as a FHIRPath Patch
+// Parameters resource.  Patches are applied to the JSON representation of the resource.
+type Patch interface {
+	apply(resourceType string, doc interface{}) (interface{}, error)
+}
+
+// PatchError is returned when a patch is malformed or cannot be applied.  It contains the HTTP status to respond with
+// and an OperationOutcome describing the problem.
+type PatchError struct {
+	HTTPStatus       int
+	OperationOutcome *models.OperationOutcome
+}
+
+func (e *PatchError) Error() string {
+	return e.OperationOutcome.Issue[0].Diagnostics
+}
+
+// newPatchError returns a PatchError for a malformed patch (400) or a patch that could not be applied (422)
+func newPatchError(status int, format string, args ...interface{}) *PatchError {
+	code := "processing"
+	if status == http.StatusBadRequest {
+		code = "invalid"
+	}
+	return &PatchError{
+		HTTPStatus:       status,
+		OperationOutcome: models.NewOperationOutcome("error", code, fmt.Sprintf(format, args...)),
+	}
+}
+
+// ParsePatch parses a patch with the given content type.  A content type of application/json-patch+json indicates a
+// JSON Patch document, while FHIR JSON and XML content types indicate a FHIRPath Patch Parameters resource.
+func ParsePatch(contentType string, body []byte) (Patch, error) {
+	switch {
+	case strings.Contains(contentType, "json-patch"):
+		var patch jsonPatch
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&patch); err != nil {
+			return nil, newPatchError(http.StatusBadRequest, "Invalid JSON Patch: %s", err.Error())
+		}
+		for _, op := range patch {
+			if err := op.check(); err != nil {
+				return nil, err
+			}
+		}
+		return patch, nil
+	case strings.Contains(contentType, "xml"):
+		jsonBody, err := ConvertXMLToJSON(body)
+		if err != nil {
+			return nil, newPatchError(http.StatusBadRequest, "Invalid FHIRPath Patch: %s", err.Error())
+		}
+		return parseFHIRPathPatch(jsonBody)
+	case strings.Contains(contentType, "json"):
+		return parseFHIRPathPatch(body)
+	}
+	return nil, newPatchError(http.StatusBadRequest, "Unsupported patch content type: %s", contentType)
+}
+
+// parsePatchResource parses the patch carried by a batch entry's resource: either a Binary containing a JSON Patch
+// document or a FHIRPath Patch Parameters resource.
+func parsePatchResource(resource interface{}) (Patch, error) {
+	switch resource := resource.(type) {
+	case *models.Binary:
+		body, err := base64.StdEncoding.DecodeString(resource.Content)
+		if err != nil {
+			return nil, newPatchError(http.StatusBadRequest, "Invalid Binary content: %s", err.Error())
+		}
+		return ParsePatch(resource.ContentType, body)
+	case *models.Parameters:
+		body, err := json.Marshal(resource)
+		if err != nil {
+			return nil, err
+		}
+		return parseFHIRPathPatch(body)
+	}
+	return nil, newPatchError(http.StatusBadRequest, "A patch must be a Binary or Parameters resource")
+}
+
+// ApplyPatch applies the patch to the resource, returning the patched resource as a new model.  The patched resource
+// is validated against the model, so a patch cannot introduce unknown elements or change the resource's type or ID.
+func ApplyPatch(patch Patch, resource interface{}) (interface{}, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	original, _ := doc.(map[string]interface{})
+	resourceType, _ := original["resourceType"].(string)
+	id := original["id"]
+
+	if doc, err = patch.apply(resourceType, doc); err != nil {
+		return nil, err
+	}
+
+	patched, ok := doc.(map[string]interface{})
+	if !ok || patched["resourceType"] != resourceType {
+		return nil, newPatchError(http.StatusUnprocessableEntity, "A patch cannot change the resource type")
+	} else if patched["id"] != id {
+		return nil, newPatchError(http.StatusUnprocessableEntity, "A patch cannot change the resource id")
+	}
+	if err := validateElements(patched, reflect.TypeOf(models.StructForResourceName(resourceType)), resourceType); err != nil {
+		return nil, err
+	}
+	if data, err = json.Marshal(patched); err != nil {
+		return nil, err
+	}
+	result := models.NewStructForResourceName(resourceType)
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, newPatchError(http.StatusUnprocessableEntity, "The patched resource is invalid: %s", err.Error())
+	}
+	return result, nil
+}
+
+// maxPatchAttempts bounds how many times a patch without an If-Match is reapplied when the resource is updated by
+// someone else between reading it and saving the patched result
+const maxPatchAttempts = 3
+
+// patchResource gets the resource, applies the patch, and saves the result.  If ifMatch is not empty, the resource is
+// only saved if it matches the resource's current version.  The patched resource must conform to its profiles (and the
+// profile the config requires for its type, if any), else a PatchError (422) with the problems is returned.
+//
+// The result is only saved if the resource is still at the version that was patched, so concurrent updates are never
+// lost.  Without an If-Match (or with If-Match: *), the patch is applied again to the new version, and ErrConflict is returned if the
+// resource keeps changing.
+func patchResource(dal DataAccessLayer, config Config, resourceType, id, ifMatch string, patch Patch) (interface{}, error) {
+	for attempt := 0; attempt < maxPatchAttempts; attempt++ {
+		resource, err := dal.Get(id, resourceType)
+		if err != nil {
+			return nil, err
+		}
+		var versionID string
+		if meta, ok := models.GetResourceMeta(resource); ok && meta != nil {
+			versionID = meta.VersionId
+		}
+		if ifMatch != "" && !versionMatches(versionID, parseETag(ifMatch)) {
+			return nil, ErrPreconditionFailed
+		}
+		patched, err := ApplyPatch(patch, resource)
+		if err != nil {
+			return nil, err
+		}
+		if err = validateProfiles(dal, config, patched); err != nil {
+			if outcome, ok := err.(*models.OperationOutcome); ok {
+				return nil, &PatchError{HTTPStatus: http.StatusUnprocessableEntity, OperationOutcome: outcome}
+			}
+			return nil, err
+		}
+		err = dal.PutIfMatch(id, versionID, patched)
+		if (err == ErrPreconditionFailed || err == ErrConflict) && (ifMatch == "" || parseETag(ifMatch) == AnyVersion) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return patched, nil
+	}
+	return nil, ErrConflict
+}
+
+// validateElements ensures that every element of the JSON value is defined by the model type t.  The path identifies
+// the value in error messages.
+func validateElements(value interface{}, t reflect.Type, path string) error {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Slice:
+		values, ok := value.([]interface{})
+		if !ok {
+			return newPatchError(http.StatusUnprocessableEntity, "%s must be an array", path)
+		}
+		for i := range values {
+			if err := validateElements(values[i], t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case t.Kind() == reflect.Interface:
+		// A resource container, such as a contained resource
+		object, _ := value.(map[string]interface{})
+		resourceType, _ := object["resourceType"].(string)
+		resourceStruct := models.StructForResourceName(resourceType)
+		if resourceStruct == nil {
+			return newPatchError(http.StatusUnprocessableEntity, "%s must be a resource", path)
+		}
+		return validateElements(object, reflect.TypeOf(resourceStruct), path)
+	case t.Kind() == reflect.Struct && t != fhirDateTimeType:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return newPatchError(http.StatusUnprocessableEntity, "%s must be an object", path)
+		}
+		fields := jsonFields(t)
+		for name, v := range object {
+			fieldType, ok := fields[name]
+			if name == "resourceType" {
+				continue
+			} else if !ok {
+				return newPatchError(http.StatusUnprocessableEntity, "Unknown element %s in %s", name, path)
+			}
+			if err := validateElements(v, fieldType, path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonPatch is a JSON Patch document (RFC 6902)
+type jsonPatch []jsonPatchOperation
+
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from"`
+	Value interface{} `json:"value"`
+}
+
+// check ensures the operation is well-formed
+func (o jsonPatchOperation) check() error {
+	switch o.Op {
+	case "add", "remove", "replace", "move", "copy", "test":
+	default:
+		return newPatchError(http.StatusBadRequest, "Unknown JSON Patch operation: %s", o.Op)
+	}
+	if _, err := parsePointer(o.Path); err != nil {
+		return err
+	}
+	if o.Op == "move" || o.Op == "copy" {
+		if _, err := parsePointer(o.From); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p jsonPatch) apply(resourceType string, doc interface{}) (interface{}, error) {
+	for _, op := range p {
+		var err error
+		if doc, err = op.apply(doc); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func (o jsonPatchOperation) apply(doc interface{}) (interface{}, error) {
+	path, _ := parsePointer(o.Path)
+	switch o.Op {
+	case "test":
+		value, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(value, o.Value) {
+			return nil, newPatchError(http.StatusUnprocessableEntity, "Test failed: %s does not have the expected value", o.Path)
+		}
+		return doc, nil
+	case "move", "copy":
+		from, _ := parsePointer(o.From)
+		value, err := pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if o.Op == "move" {
+			if strings.HasPrefix(o.Path+"/", o.From+"/") && o.Path != o.From {
+				return nil, newPatchError(http.StatusUnprocessableEntity, "Cannot move %s into one of its children", o.From)
+			}
+			if doc, err = pointerUpdate(doc, from, "remove", nil); err != nil {
+				return nil, err
+			}
+		} else {
+			value = copyValue(value)
+		}
+		return pointerUpdate(doc, path, "add", value)
+	}
+	return pointerUpdate(doc, path, o.Op, copyValue(o.Value))
+}
+
+// parsePointer splits a JSON pointer (RFC 6901) into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	} else if !strings.HasPrefix(pointer, "/") {
+		return nil, newPatchError(http.StatusBadRequest, "Invalid JSON pointer: %s", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(tokens[i], "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// pointerGet returns the value referenced by the pointer's tokens
+func pointerGet(doc interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		switch container := doc.(type) {
+		case map[string]interface{}:
+			value, ok := container[token]
+			if !ok {
+				return nil, pathNotFound(tokens)
+			}
+			doc = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(container)-1, tokens)
+			if err != nil {
+				return nil, err
+			}
+			doc = container[index]
+		default:
+			return nil, pathNotFound(tokens)
+		}
+	}
+	return doc, nil
+}
+
+// pointerUpdate performs an add, replace, or remove operation on the value referenced by the pointer's tokens,
+// returning the updated document.
+func pointerUpdate(doc interface{}, tokens []string, op string, value interface{}) (interface{}, error) {
+	return updateValue(doc, tokens, tokens, op, value)
+}
+
+func updateValue(doc interface{}, tokens, remaining []string, op string, value interface{}) (interface{}, error) {
+	if len(remaining) == 0 {
+		if op == "remove" {
+			return nil, newPatchError(http.StatusUnprocessableEntity, "Cannot remove the whole resource")
+		}
+		return value, nil
+	}
+
+	token, remaining := remaining[0], remaining[1:]
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		child, exists := container[token]
+		if len(remaining) > 0 || op != "add" {
+			if !exists {
+				return nil, pathNotFound(tokens)
+			}
+		}
+		if len(remaining) == 0 && op == "remove" {
+			delete(container, token)
+			return container, nil
+		}
+		child, err := updateValue(child, tokens, remaining, op, value)
+		if err != nil {
+			return nil, err
+		}
+		container[token] = child
+		return container, nil
+	case []interface{}:
+		if len(remaining) == 0 && op == "add" {
+			index := len(container)
+			if token != "-" {
+				var err error
+				if index, err = arrayIndex(token, len(container), tokens); err != nil {
+					return nil, err
+				}
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		}
+		index, err := arrayIndex(token, len(container)-1, tokens)
+		if err != nil {
+			return nil, err
+		}
+		if len(remaining) == 0 && op == "remove" {
+			return append(container[:index], container[index+1:]...), nil
+		}
+		if container[index], err = updateValue(container[index], tokens, remaining, op, value); err != nil {
+			return nil, err
+		}
+		return container, nil
+	}
+	return nil, pathNotFound(tokens)
+}
+
+// arrayIndex parses an array index token, ensuring it is between 0 and max
+func arrayIndex(token string, max int, tokens []string) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > max || (len(token) > 1 && token[0] == '0') {
+		return 0, pathNotFound(tokens)
+	}
+	return index, nil
+}
+
+func pathNotFound(tokens []string) error {
+	return newPatchError(http.StatusUnprocessableEntity, "Path not found: /%s", strings.Join(tokens, "/"))
+}
+
+// copyValue returns a deep copy of a JSON value so that patched values are never shared
+func copyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			object[k] = copyValue(v)
+		}
+		return object
+	case []interface{}:
+		array := make([]interface{}, len(value))
+		for i, v := range value {
+			array[i] = copyValue(v)
+		}
+		return array
+	}
+	return value
+}
+
+// fhirPathPatch is a FHIRPath Patch, represented as a Parameters resource with an "operation" parameter for each
+// change.  Only simple paths are supported: a resource type followed by element names, each optionally indexed (e.g.,
+// Patient.name[0].given).
+type fhirPathPatch []fhirPathOperation
+
+type fhirPathOperation struct {
+	Type        string
+	Path        string
+	Name        string
+	Value       interface{}
+	Index       *int
+	Source      *int
+	Destination *int
+}
+
+func parseFHIRPathPatch(body []byte) (Patch, error) {
+	var parameters struct {
+		ResourceType string `json:"resourceType"`
+		Parameter    []struct {
+			Name string                   `json:"name"`
+			Part []map[string]interface{} `json:"part"`
+		} `json:"parameter"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&parameters); err != nil {
+		return nil, newPatchError(http.StatusBadRequest, "Invalid FHIRPath Patch: %s", err.Error())
+	} else if parameters.ResourceType != "Parameters" {
+		return nil, newPatchError(http.StatusBadRequest, "A FHIRPath Patch must be a Parameters resource")
+	}
+
+	var patch fhirPathPatch
+	for _, parameter := range parameters.Parameter {
+		if parameter.Name != "operation" {
+			continue
+		}
+		var op fhirPathOperation
+		for _, part := range parameter.Part {
+			name, _ := part["name"].(string)
+			var value interface{}
+			for k, v := range part {
+				if strings.HasPrefix(k, "value") || k == "resource" {
+					value = v
+				}
+			}
+			var err error
+			switch name {
+			case "type":
+				op.Type, _ = value.(string)
+			case "path":
+				op.Path, _ = value.(string)
+			case "name":
+				op.Name, _ = value.(string)
+			case "value":
+				op.Value = value
+			case "index":
+				op.Index, err = integerPart(name, value)
+			case "source":
+				op.Source, err = integerPart(name, value)
+			case "destination":
+				op.Destination, err = integerPart(name, value)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := op.check(); err != nil {
+			return nil, err
+		}
+		patch = append(patch, op)
+	}
+	if len(patch) == 0 {
+		return nil, newPatchError(http.StatusBadRequest, "A FHIRPath Patch must have at least one operation")
+	}
+	return patch, nil
+}
+
+func integerPart(name string, value interface{}) (*int, error) {
+	number, _ := value.(json.Number)
+	i, err := strconv.Atoi(number.String())
+	if err != nil {
+		return nil, newPatchError(http.StatusBadRequest, "The %s of a FHIRPath Patch operation must be an integer", name)
+	}
+	return &i, nil
+}
+
+// check ensures the operation has the parts its type requires
+func (o fhirPathOperation) check() error {
+	missing := ""
+	switch {
+	case o.Path == "":
+		missing = "path"
+	case o.Type == "add" && o.Name == "":
+		missing = "name"
+	case (o.Type == "add" || o.Type == "insert" || o.Type == "replace") && o.Value == nil:
+		missing = "value"
+	case o.Type == "insert" && o.Index == nil:
+		missing = "index"
+	case o.Type == "move" && o.Source == nil:
+		missing = "source"
+	case o.Type == "move" && o.Destination == nil:
+		missing = "destination"
+	case o.Type != "add" && o.Type != "insert" && o.Type != "delete" && o.Type != "replace" && o.Type != "move":
+		return newPatchError(http.StatusBadRequest, "Unknown FHIRPath Patch operation: %s", o.Type)
+	}
+	if missing != "" {
+		return newPatchError(http.StatusBadRequest, "FHIRPath Patch %s operation requires a %s", o.Type, missing)
+	}
+	return nil
+}
+
+func (p fhirPathPatch) apply(resourceType string, doc interface{}) (interface{}, error) {
+	for _, op := range p {
+		var err error
+		if doc, err = op.apply(resourceType, doc); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// apply translates the FHIRPath Patch operation into the equivalent JSON Patch operation and applies it
+func (o fhirPathOperation) apply(resourceType string, doc interface{}) (interface{}, error) {
+	tokens, t, isCollection, err := resolveFHIRPath(doc, resourceType, o.Path)
+	if err != nil {
+		return nil, err
+	}
+	value := copyValue(o.Value)
+
+	switch o.Type {
+	case "add":
+		if tokens, err = singleElement(doc, tokens, isCollection, o.Path); err != nil {
+			return nil, err
+		}
+		if t.Kind() == reflect.Slice {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		fieldType, ok := jsonFields(t)[o.Name]
+		if t.Kind() != reflect.Struct || !ok {
+			return nil, newPatchError(http.StatusUnprocessableEntity, "Unknown element %s in %s", o.Name, o.Path)
+		}
+		tokens = append(tokens, o.Name)
+		existing, err := pointerGet(doc, tokens)
+		switch {
+		case err == nil && fieldType.Kind() == reflect.Slice:
+			return pointerUpdate(doc, append(tokens, strconv.Itoa(len(existing.([]interface{})))), "add", value)
+		case err == nil:
+			return nil, newPatchError(http.StatusUnprocessableEntity, "%s.%s already has a value", o.Path, o.Name)
+		case fieldType.Kind() == reflect.Slice:
+			return pointerUpdate(doc, tokens, "add", []interface{}{value})
+		}
+		return pointerUpdate(doc, tokens, "add", value)
+	case "insert":
+		if !isCollection {
+			return nil, newPatchError(http.StatusUnprocessableEntity, "%s is not a list", o.Path)
+		}
+		if _, err := pointerGet(doc, tokens); err != nil && *o.Index == 0 {
+			return pointerUpdate(doc, tokens, "add", []interface{}{value})
+		}
+		return pointerUpdate(doc, append(tokens, strconv.Itoa(*o.Index)), "add", value)
+	case "delete":
+		if _, err := pointerGet(doc, tokens); err != nil {
+			// Deleting an element that doesn't exist is not an error
+			return doc, nil
+		}
+		elementTokens, err := singleElement(doc, tokens, isCollection, o.Path)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = pointerUpdate(doc, elementTokens, "remove", nil); err != nil {
+			return nil, err
+		}
+		// FHIR doesn't allow empty lists, so remove the list if it is now empty
+		if list, err := pointerGet(doc, tokens); err == nil && reflect.DeepEqual(list, []interface{}{}) {
+			return pointerUpdate(doc, tokens, "remove", nil)
+		}
+		return doc, nil
+	case "replace":
+		if tokens, err = singleElement(doc, tokens, isCollection, o.Path); err != nil {
+			return nil, err
+		}
+		return pointerUpdate(doc, tokens, "replace", value)
+	case "move":
+		if !isCollection {
+			return nil, newPatchError(http.StatusUnprocessableEntity, "%s is not a list", o.Path)
+		}
+		from := append(append([]string{}, tokens...), strconv.Itoa(*o.Source))
+		value, err := pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = pointerUpdate(doc, from, "remove", nil); err != nil {
+			return nil, err
+		}
+		return pointerUpdate(doc, append(tokens, strconv.Itoa(*o.Destination)), "add", value)
+	}
+	return doc, nil
+}
+
+var fhirPathSegment = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9]*)(?:\[(\d+)\])?$`)
+
+// resolveFHIRPath converts a simple FHIRPath expression into JSON pointer tokens, returning the model type of the
+// element it identifies.  If the path ends at a repeating element without an index, isCollection is true and the
+// tokens refer to the whole list.  Unindexed repeating elements in the middle of the path must have a single value.
+func resolveFHIRPath(doc interface{}, resourceType, path string) (tokens []string, t reflect.Type, isCollection bool, err error) {
+	segments := strings.Split(path, ".")
+	if segments[0] != resourceType {
+		return nil, nil, false, newPatchError(http.StatusUnprocessableEntity, "Unsupported FHIRPath Patch path: %s", path)
+	}
+
+	t = reflect.TypeOf(models.StructForResourceName(resourceType))
+	for i, segment := range segments[1:] {
+		match := fhirPathSegment.FindStringSubmatch(segment)
+		if match == nil {
+			return nil, nil, false, newPatchError(http.StatusUnprocessableEntity, "Unsupported FHIRPath Patch path: %s", path)
+		}
+		if t.Kind() == reflect.Slice {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		fieldType, ok := reflect.Type(nil), false
+		if t.Kind() == reflect.Struct {
+			fieldType, ok = jsonFields(t)[match[1]]
+		}
+		if !ok {
+			return nil, nil, false, newPatchError(http.StatusUnprocessableEntity, "Unknown element %s in %s", match[1], path)
+		}
+		t = fieldType
+		tokens = append(tokens, match[1])
+
+		isLast := i == len(segments)-2
+		switch {
+		case t.Kind() != reflect.Slice:
+			if match[2] != "" {
+				return nil, nil, false, newPatchError(http.StatusUnprocessableEntity, "%s is not a list", match[1])
+			}
+		case match[2] != "":
+			tokens = append(tokens, match[2])
+		case isLast:
+			isCollection = true
+		default:
+			if tokens, err = singleElement(doc, tokens, true, path); err != nil {
+				return nil, nil, false, err
+			}
+		}
+	}
+	return tokens, t, isCollection, nil
+}
+
+// singleElement returns tokens identifying a single element.  If the tokens identify a list, it must have exactly one
+// element.
+func singleElement(doc interface{}, tokens []string, isCollection bool, path string) ([]string, error) {
+	if !isCollection {
+		return tokens, nil
+	}
+	value, err := pointerGet(doc, tokens)
+	if err != nil {
+		return nil, err
+	}
+	if list, _ := value.([]interface{}); len(list) != 1 {
+		return nil, newPatchError(http.StatusUnprocessableEntity, "%s must identify a single element", path)
+	}
+	return append(tokens, "0"), nil
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type PatchSuite struct{}
+
+var _ = Suite(&PatchSuite{})
+
+func (p *PatchSuite) encounter() *models.Encounter {
+	encounter := &models.Encounter{
+		Status: "in-progress",
+		Type: []models.CodeableConcept{
+			{Text: "Office Visit"},
+			{Text: "Follow-up"},
+		},
+		Patient: &models.Reference{Reference: "Patient/123"},
+	}
+	encounter.Id = "456"
+	return encounter
+}
+
+func (p *PatchSuite) TestJSONPatch(c *C) {
+	patch, err := ParsePatch("application/json-patch+json", []byte(`[
+		{"op": "test", "path": "/status", "value": "in-progress"},
+		{"op": "replace", "path": "/status", "value": "finished"},
+		{"op": "add", "path": "/type/-", "value": {"text": "Annual"}},
+		{"op": "remove", "path": "/type/0"},
+		{"op": "copy", "from": "/type/1", "path": "/type/0"},
+		{"op": "move", "from": "/patient", "path": "/subject"}
+	]`))
+	util.CheckErr(err)
+
+	_, err = ApplyPatch(patch, p.encounter())
+	// Encounter has no subject, so the move should make the result invalid
+	c.Assert(err, ErrorMatches, "Unknown element subject in Encounter")
+	c.Assert(err.(*PatchError).HTTPStatus, Equals, http.StatusUnprocessableEntity)
+
+	patch, err = ParsePatch("application/json-patch+json", []byte(`[
+		{"op": "test", "path": "/status", "value": "in-progress"},
+		{"op": "replace", "path": "/status", "value": "finished"},
+		{"op": "add", "path": "/type/-", "value": {"text": "Annual"}},
+		{"op": "remove", "path": "/type/0"},
+		{"op": "copy", "from": "/type/1", "path": "/type/0"}
+	]`))
+	util.CheckErr(err)
+	original := p.encounter()
+	result, err := ApplyPatch(patch, original)
+	util.CheckErr(err)
+	patched := result.(*models.Encounter)
+	c.Assert(patched.Id, Equals, "456")
+	c.Assert(patched.Status, Equals, "finished")
+	c.Assert(patched.Type, HasLen, 3)
+	c.Assert(patched.Type[0].Text, Equals, "Annual")
+	c.Assert(patched.Type[1].Text, Equals, "Follow-up")
+	c.Assert(patched.Type[2].Text, Equals, "Annual")
+	c.Assert(patched.Patient.Reference, Equals, "Patient/123")
+
+	// The original should be untouched
+	c.Assert(original.Status, Equals, "in-progress")
+	c.Assert(original.Type, HasLen, 2)
+}
+
+func (p *PatchSuite) TestJSONPatchFailures(c *C) {
+	_, err := ParsePatch("application/json-patch+json", []byte(`{"op": "replace"}`))
+	c.Assert(err, NotNil)
+	c.Assert(err.(*PatchError).HTTPStatus, Equals, http.StatusBadRequest)
+
+	_, err = ParsePatch("application/json-patch+json", []byte(`[{"op": "frobnicate", "path": "/status"}]`))
+	c.Assert(err, ErrorMatches, "Unknown JSON Patch operation: frobnicate")
+
+	patch, err := ParsePatch("application/json-patch+json", []byte(`[{"op": "test", "path": "/status", "value": "planned"}]`))
+	util.CheckErr(err)
+	_, err = ApplyPatch(patch, p.encounter())
+	c.Assert(err, ErrorMatches, "Test failed: /status does not have the expected value")
+
+	patch, err = ParsePatch("application/json-patch+json", []byte(`[{"op": "replace", "path": "/type/5/text", "value": "x"}]`))
+	util.CheckErr(err)
+	_, err = ApplyPatch(patch, p.encounter())
+	c.Assert(err, ErrorMatches, "Path not found: /type/5/text")
+
+	patch, err = ParsePatch("application/json-patch+json", []byte(`[{"op": "replace", "path": "/id", "value": "789"}]`))
+	util.CheckErr(err)
+	_, err = ApplyPatch(patch, p.encounter())
+	c.Assert(err, ErrorMatches, "A patch cannot change the resource id")
+
+	patch, err = ParsePatch("application/json-patch+json", []byte(`[{"op": "replace", "path": "/status", "value": 5}]`))
+	util.CheckErr(err)
+	_, err = ApplyPatch(patch, p.encounter())
+	c.Assert(err, ErrorMatches, "The patched resource is invalid: .*")
+}
+
+func (p *PatchSuite) TestFHIRPathPatch(c *C) {
+	patch, err := ParsePatch("application/json+fhir", []byte(`{
+		"resourceType": "Parameters",
+		"parameter": [
+			{"name": "operation", "part": [
+				{"name": "type", "valueCode": "replace"},
+				{"name": "path", "valueString": "Encounter.status"},
+				{"name": "value", "valueCode": "finished"}
+			]},
+			{"name": "operation", "part": [
+				{"name": "type", "valueCode": "insert"},
+				{"name": "path", "valueString": "Encounter.type"},
+				{"name": "index", "valueInteger": 0},
+				{"name": "value", "valueCodeableConcept": {"text": "Annual"}}
+			]},
+			{"name": "operation", "part": [
+				{"name": "type", "valueCode": "move"},
+				{"name": "path", "valueString": "Encounter.type"},
+				{"name": "source", "valueInteger": 2},
+				{"name": "destination", "valueInteger": 1}
+			]},
+			{"name": "operation", "part": [
+				{"name": "type", "valueCode": "add"},
+				{"name": "path", "valueString": "Encounter"},
+				{"name": "name", "valueString": "reason"},
+				{"name": "value", "valueCodeableConcept": {"text": "Checkup"}}
+			]},
+			{"name": "operation", "part": [
+				{"name": "type", "valueCode": "delete"},
+				{"name": "path", "valueString": "Encounter.type[0]"}
+			]},
+			{"name": "operation", "part": [
+				{"name": "type", "valueCode": "delete"},
+				{"name": "path", "valueString": "Encounter.priority"}
+			]}
+		]
+	}`))
+	util.CheckErr(err)
+
+	result, err := ApplyPatch(patch, p.encounter())
+	util.CheckErr(err)
+	patched := result.(*models.Encounter)
+	c.Assert(patched.Status, Equals, "finished")
+	c.Assert(patched.Type, HasLen, 2)
+	c.Assert(patched.Type[0].Text, Equals, "Follow-up")
+	c.Assert(patched.Type[1].Text, Equals, "Office Visit")
+	c.Assert(patched.Reason, HasLen, 1)
+	c.Assert(patched.Reason[0].Text, Equals, "Checkup")
+}
+
+func (p *PatchSuite) TestFHIRPathPatchFailures(c *C) {
+	_, err := ParsePatch("application/json+fhir", []byte(`{"resourceType": "Patient"}`))
+	c.Assert(err, ErrorMatches, "A FHIRPath Patch must be a Parameters resource")
+
+	_, err = ParsePatch("application/json+fhir", []byte(`{"resourceType": "Parameters", "parameter": [
+		{"name": "operation", "part": [
+			{"name": "type", "valueCode": "add"},
+			{"name": "path", "valueString": "Encounter"},
+			{"name": "value", "valueCode": "finished"}
+		]}
+	]}`))
+	c.Assert(err, ErrorMatches, "FHIRPath Patch add operation requires a name")
+
+	patch, err := ParsePatch("application/json+fhir", []byte(`{"resourceType": "Parameters", "parameter": [
+		{"name": "operation", "part": [
+			{"name": "type", "valueCode": "replace"},
+			{"name": "path", "valueString": "Encounter.type.text"},
+			{"name": "value", "valueString": "Annual"}
+		]}
+	]}`))
+	util.CheckErr(err)
+	_, err = ApplyPatch(patch, p.encounter())
+	c.Assert(err, ErrorMatches, "Encounter.type.text must identify a single element")
+
+	patch, err = ParsePatch("application/json+fhir", []byte(`{"resourceType": "Parameters", "parameter": [
+		{"name": "operation", "part": [
+			{"name": "type", "valueCode": "replace"},
+			{"name": "path", "valueString": "Encounter.foo"},
+			{"name": "value", "valueString": "bar"}
+		]}
+	]}`))
+	util.CheckErr(err)
+	_, err = ApplyPatch(patch, p.encounter())
+	c.Assert(err, ErrorMatches, "Unknown element foo in Encounter.foo")
+}
+
+// racingDAL holds a single encounter, which is updated by someone else the first updates times it is saved
+type racingDAL struct {
+	DataAccessLayer
+	encounter *models.Encounter
+	updates   int
+}
+
+func (d *racingDAL) Get(id, resourceType string) (interface{}, error) {
+	encounter := *d.encounter
+	return &encounter, nil
+}
+
+func (d *racingDAL) PutIfMatch(id, versionID string, resource interface{}) error {
+	if d.updates > 0 {
+		d.updates--
+		d.encounter.Type = append(d.encounter.Type, models.CodeableConcept{Text: "Concurrent"})
+		d.encounter.Meta = &models.Meta{VersionId: nextVersionID(d.encounter.Meta.VersionId)}
+	}
+	if versionID != d.encounter.Meta.VersionId {
+		return ErrPreconditionFailed
+	}
+	d.encounter = resource.(*models.Encounter)
+	d.encounter.Meta = &models.Meta{VersionId: nextVersionID(versionID)}
+	return nil
+}
+
+func (p *PatchSuite) TestPatchResourceKeepsConcurrentUpdates(c *C) {
+	patch, err := ParsePatch("application/json-patch+json", []byte(`[{"op": "replace", "path": "/status", "value": "finished"}]`))
+	util.CheckErr(err)
+
+	encounter := p.encounter()
+	encounter.Meta = &models.Meta{VersionId: "1"}
+	dal := &racingDAL{encounter: encounter, updates: 1}
+	_, err = patchResource(dal, Config{}, "Encounter", "456", "", patch)
+	util.CheckErr(err)
+	// The patch is reapplied to the concurrently updated version rather than overwriting it
+	c.Assert(dal.encounter.Status, Equals, "finished")
+	c.Assert(dal.encounter.Type, HasLen, 3)
+	c.Assert(dal.encounter.Meta.VersionId, Equals, "3")
+
+	// With an If-Match, the concurrent update makes the precondition fail
+	encounter = p.encounter()
+	encounter.Meta = &models.Meta{VersionId: "1"}
+	dal = &racingDAL{encounter: encounter, updates: 1}
+	_, err = patchResource(dal, Config{}, "Encounter", "456", "W/\"1\"", patch)
+	c.Assert(err, Equals, ErrPreconditionFailed)
+	c.Assert(dal.encounter.Status, Equals, "in-progress")
+
+	// A resource that keeps changing is a conflict
+	encounter = p.encounter()
+	encounter.Meta = &models.Meta{VersionId: "1"}
+	dal = &racingDAL{encounter: encounter, updates: maxPatchAttempts}
+	_, err = patchResource(dal, Config{}, "Encounter", "456", "", patch)
+	c.Assert(err, Equals, ErrConflict)
+	c.Assert(dal.encounter.Status, Equals, "in-progress")
+}
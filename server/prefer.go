@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/intervention-engine/fhir/models"
+)
+
+// The return preferences a client may state in the Prefer header, determining what the response to a create, update,
+// or patch contains
+const (
+	ReturnMinimal          = "minimal"
+	ReturnRepresentation   = "representation"
+	ReturnOperationOutcome = "OperationOutcome"
+)
+
+// returnPreference returns the client's return preference (minimal, representation, or OperationOutcome) from the
+// request's Prefer header.  If the header states no recognized return preference, an empty string is returned and the
+// full representation should be returned.
+func returnPreference(r *http.Request) string {
+	for _, header := range r.Header["Prefer"] {
+		for _, preference := range strings.Split(header, ",") {
+			// Preference parameters (after a semicolon) don't apply to return preferences, so they're ignored
+			parts := strings.SplitN(strings.SplitN(preference, ";", 2)[0], "=", 2)
+			if len(parts) != 2 || !strings.EqualFold(strings.TrimSpace(parts[0]), "return") {
+				continue
+			}
+			value := strings.Trim(strings.TrimSpace(parts[1]), "\"")
+			for _, known := range []string{ReturnMinimal, ReturnRepresentation, ReturnOperationOutcome} {
+				if strings.EqualFold(value, known) {
+					return known
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// respondWithResource responds to a create, update, or patch according to the client's return preference: with the
+// resource (the default), with no body, or with an OperationOutcome whose diagnostics describe the result.
+func respondWithResource(c *gin.Context, status int, resource interface{}, diagnostics string) {
+	preference := returnPreference(c.Request)
+	if preference != "" {
+		c.Header("Preference-Applied", "return="+preference)
+	}
+	switch preference {
+	case ReturnMinimal:
+		c.Status(status)
+	case ReturnOperationOutcome:
+		c.JSON(status, models.NewOperationOutcome("information", "informational", diagnostics))
+	default:
+		c.JSON(status, resource)
+	}
+}
+
+// applyReturnPreference replaces the resource in a successful batch entry's response according to the client's
+// return preference: minimal removes it, and OperationOutcome replaces it with an OperationOutcome describing the
+// result of the entry's request.
+func applyReturnPreference(entry *models.BundleEntryComponent, preference, method string) {
+	switch preference {
+	case ReturnMinimal:
+		entry.Resource = nil
+	case ReturnOperationOutcome:
+		action := map[string]string{"POST": "Created", "PUT": "Updated", "PATCH": "Patched"}[method]
+		if entry.Response.Status == "201" {
+			action = "Created"
+		} else if method == "POST" {
+			action = "Found existing"
+		}
+		diagnostics := fmt.Sprintf("%s %s", action, entry.Response.Location)
+		entry.Resource = models.NewOperationOutcome("information", "informational", diagnostics)
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type PreferSuite struct {
+	Engine *gin.Engine
+}
+
+var _ = Suite(&PreferSuite{})
+
+// writeOnlyDAL accepts every create and update, without storing anything
+type writeOnlyDAL struct {
+	DataAccessLayer
+}
+
+func (d *writeOnlyDAL) NewID(resourceType string) (string, error) {
+	return "123", nil
+}
+
+func (d *writeOnlyDAL) Post(resource interface{}) (string, error) {
+	return "123", d.PostWithID("123", resource)
+}
+
+func (d *writeOnlyDAL) PostWithID(id string, resource interface{}) error {
+	resource.(*models.Patient).Id = id
+	resource.(*models.Patient).Meta = &models.Meta{VersionId: "1"}
+	return nil
+}
+
+func (d *writeOnlyDAL) Put(id string, resource interface{}) (bool, error) {
+	resource.(*models.Patient).Id = id
+	resource.(*models.Patient).Meta = &models.Meta{VersionId: "2"}
+	return false, nil
+}
+
+func (p *PreferSuite) SetUpSuite(c *C) {
+	gin.SetMode(gin.ReleaseMode)
+	p.Engine = gin.New()
+	p.Engine.Use(NegotiateFormat)
+	RegisterServerRoutes(p.Engine, make(map[string][]gin.HandlerFunc), &writeOnlyDAL{}, DefaultConfig)
+}
+
+func (p *PreferSuite) do(method, path, body, prefer string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	util.CheckErr(err)
+	req.Header.Set("Content-Type", "application/json+fhir")
+	if prefer != "" {
+		req.Header.Set("Prefer", prefer)
+	}
+	w := httptest.NewRecorder()
+	p.Engine.ServeHTTP(w, req)
+	return w
+}
+
+func (p *PreferSuite) TestReturnPreference(c *C) {
+	preferences := map[string]string{
+		"":                          "",
+		"return=minimal":            ReturnMinimal,
+		"return=\"representation\"": ReturnRepresentation,
+		"handling=strict, return=operationoutcome": ReturnOperationOutcome,
+		"return=everything":                        "",
+		"respond-async":                            "",
+	}
+	for header, expected := range preferences {
+		req, err := http.NewRequest("POST", "/Patient", nil)
+		util.CheckErr(err)
+		req.Header.Set("Prefer", header)
+		c.Assert(returnPreference(req), Equals, expected, Commentf("Prefer: %s", header))
+	}
+}
+
+func (p *PreferSuite) TestSingleInteractions(c *C) {
+	patient := `{"resourceType": "Patient", "gender": "male"}`
+
+	w := p.do("POST", "/Patient", patient, "return=minimal")
+	c.Assert(w.Code, Equals, http.StatusCreated)
+	c.Assert(w.Body.Len(), Equals, 0)
+	c.Assert(w.Header().Get("Location"), Equals, "http:///Patient/123")
+	c.Assert(w.Header().Get("ETag"), Equals, "W/\"1\"")
+	c.Assert(w.Header().Get("Preference-Applied"), Equals, "return=minimal")
+
+	w = p.do("PUT", "/Patient/123", patient, "return=OperationOutcome")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	outcome := &models.OperationOutcome{}
+	util.CheckErr(json.Unmarshal(w.Body.Bytes(), outcome))
+	c.Assert(outcome.Issue, HasLen, 1)
+	c.Assert(outcome.Issue[0].Severity, Equals, "information")
+	c.Assert(outcome.Issue[0].Diagnostics, Equals, "Updated http:///Patient/123")
+
+	for _, prefer := range []string{"", "return=representation"} {
+		w = p.do("POST", "/Patient", patient, prefer)
+		c.Assert(w.Code, Equals, http.StatusCreated)
+		result := &models.Patient{}
+		util.CheckErr(json.Unmarshal(w.Body.Bytes(), result))
+		c.Assert(result.Id, Equals, "123")
+		c.Assert(result.Gender, Equals, "male")
+	}
+}
+
+func (p *PreferSuite) TestBatchEntries(c *C) {
+	batch := `{
+		"resourceType": "Bundle",
+		"type": "batch",
+		"entry": [
+			{"resource": {"resourceType": "Patient"}, "request": {"method": "POST", "url": "Patient"}},
+			{"resource": {"resourceType": "Patient"}, "request": {"method": "PUT", "url": "Patient/456"}}
+		]
+	}`
+
+	w := p.do("POST", "/", batch, "return=minimal")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	bundle := &models.Bundle{}
+	util.CheckErr(json.Unmarshal(w.Body.Bytes(), bundle))
+	c.Assert(bundle.Entry, HasLen, 2)
+	for _, entry := range bundle.Entry {
+		c.Assert(entry.Resource, IsNil)
+		c.Assert(entry.Response.Location, Not(Equals), "")
+	}
+
+	w = p.do("POST", "/", batch, "return=OperationOutcome")
+	bundle = &models.Bundle{}
+	util.CheckErr(json.Unmarshal(w.Body.Bytes(), bundle))
+	c.Assert(bundle.Entry[0].Resource.(*models.OperationOutcome).Issue[0].Diagnostics, Equals, "Created http:///Patient/123")
+	c.Assert(bundle.Entry[1].Resource.(*models.OperationOutcome).Issue[0].Diagnostics, Equals, "Updated http:///Patient/456")
+
+	w = p.do("POST", "/", batch, "")
+	bundle = &models.Bundle{}
+	util.CheckErr(json.Unmarshal(w.Body.Bytes(), bundle))
+	c.Assert(bundle.Entry[0].Resource.(*models.Patient).Id, Equals, "123")
+}
@@ -0,0 +1,682 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/intervention-engine/fhir/models"
+	"github.com/intervention-engine/fhir/search"
+)
+
+// ProfileValidator validates resources against profiles: StructureDefinition resources, stored by the server, whose
+// snapshots constrain the cardinality, types, fixed and pattern values, bindings, and slicing of a resource's elements.
+// Extensions are also validated against their definitions, and coded values against the value sets they are bound
+// to, when those definitions and value sets are stored by the server.  Profiles and value sets are loaded as needed
+// and cached for the life of the validator, so a validator should only be used for a single request.
+type ProfileValidator struct {
+	DAL        DataAccessLayer
+	profiles   map[string]*profile
+	valueSets  map[string]*valueSetCodes
+	validating map[string]bool
+	issues     []models.OperationOutcomeIssueComponent
+}
+
+// NewProfileValidator creates a new ProfileValidator that loads profiles and value sets through the passed in
+// DataAccessLayer.
+func NewProfileValidator(dal DataAccessLayer) *ProfileValidator {
+	return &ProfileValidator{
+		DAL:        dal,
+		profiles:   make(map[string]*profile),
+		valueSets:  make(map[string]*valueSetCodes),
+		validating: make(map[string]bool),
+	}
+}
+
+// Validate validates a resource, decoded from JSON into generic maps and slices, against the profiles it claims to
+// conform to (in Meta.Profile) and the additional required profiles.  Profiles that the resource claims but that
+// are not stored by the server are reported as warnings, while missing required profiles are reported as errors.
+func (p *ProfileValidator) Validate(doc map[string]interface{}, required ...string) []models.OperationOutcomeIssueComponent {
+	p.issues = nil
+	resourceType, _ := doc["resourceType"].(string)
+	urls := make(map[string]bool)
+	for _, u := range required {
+		if !urls[u] {
+			urls[u] = true
+			p.validateProfile(doc, resourceType, u, true)
+		}
+	}
+	meta, _ := doc["meta"].(map[string]interface{})
+	claimed, _ := meta["profile"].([]interface{})
+	for _, u := range claimed {
+		if u, ok := u.(string); ok && !urls[u] {
+			urls[u] = true
+			p.validateProfile(doc, resourceType, u, false)
+		}
+	}
+	return p.issues
+}
+
+// ValidateResource validates a resource model against the profiles it claims to conform to and the additional
+// required profiles.
+func (p *ProfileValidator) ValidateResource(resource interface{}, required ...string) ([]models.OperationOutcomeIssueComponent, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return p.Validate(doc, required...), nil
+}
+
+// validateProfiles validates a resource against the profiles it claims to conform to and the profile the server
+// requires for its type, if any.  If the resource does not conform, the OperationOutcome describing its problems is
+// returned as the error.
+func validateProfiles(dal DataAccessLayer, config Config, resource interface{}) error {
+	var required []string
+	if profile, ok := config.RequiredProfiles[reflect.TypeOf(resource).Elem().Name()]; ok {
+		required = append(required, profile)
+	}
+	if meta, ok := models.GetResourceMeta(resource); len(required) == 0 && (!ok || meta == nil || len(meta.Profile) == 0) {
+		return nil
+	}
+	issues, err := NewProfileValidator(dal).ValidateResource(resource, required...)
+	if err != nil {
+		return err
+	} else if hasErrors(issues) {
+		return validationOutcome(issues)
+	}
+	return nil
+}
+
+// hasErrors indicates if any of the issues are errors, rather than warnings or information
+func hasErrors(issues []models.OperationOutcomeIssueComponent) bool {
+	for _, issue := range issues {
+		if issue.Severity == "error" || issue.Severity == "fatal" {
+			return true
+		}
+	}
+	return false
+}
+
+// profile is the parsed snapshot of a StructureDefinition
+type profile struct {
+	url  string
+	root *profileElement
+}
+
+// profileElement is an element definition within a profile's snapshot, along with the definitions of its child
+// elements and, if the element is sliced, its slices
+type profileElement struct {
+	def      *models.ElementDefinition
+	fixed    interface{}
+	pattern  interface{}
+	children []*profileElement
+	slices   []*profileElement
+}
+
+// instanceItem is a single occurrence of an element in a resource
+type instanceItem struct {
+	value    interface{}
+	path     string
+	dataType string
+}
+
+func (p *ProfileValidator) addIssue(severity, code, location, format string, args ...interface{}) {
+	issue := models.OperationOutcomeIssueComponent{
+		Severity:    severity,
+		Code:        code,
+		Diagnostics: fmt.Sprintf(format, args...),
+	}
+	if location != "" {
+		issue.Location = []string{location}
+	}
+	p.issues = append(p.issues, issue)
+}
+
+// validateProfile validates a resource against a single profile
+func (p *ProfileValidator) validateProfile(doc map[string]interface{}, resourceType, url string, required bool) {
+	prof, err := p.loadProfile(url)
+	if err != nil {
+		p.addIssue("error", "exception", resourceType, "Unable to load profile %s: %s", url, err.Error())
+		return
+	} else if prof == nil {
+		severity := "warning"
+		if required {
+			severity = "error"
+		}
+		p.addIssue(severity, "not-found", resourceType, "Profile %s could not be found", url)
+		return
+	}
+	if prof.root.def.Path != resourceType {
+		p.addIssue("error", "invalid", resourceType, "Profile %s applies to %s, not %s", url, prof.root.def.Path, resourceType)
+		return
+	}
+	p.validateElement(doc, prof.root, resourceType, prof.url)
+}
+
+// loadProfile loads and parses the StructureDefinition with the given URL.  The URL may also be a relative reference
+// to a StructureDefinition (e.g., StructureDefinition/123).  If there is no such StructureDefinition, nil is returned.
+func (p *ProfileValidator) loadProfile(profileURL string) (*profile, error) {
+	if prof, ok := p.profiles[profileURL]; ok {
+		return prof, nil
+	}
+	resource, err := p.load("StructureDefinition", profileURL)
+	if err != nil || resource == nil {
+		return nil, err
+	}
+	sd := resource.(*models.StructureDefinition)
+	if sd.Snapshot == nil || len(sd.Snapshot.Element) == 0 {
+		return nil, fmt.Errorf("The profile has no snapshot")
+	}
+	prof := &profile{url: profileURL, root: parseSnapshot(sd.Snapshot.Element)}
+	p.profiles[profileURL] = prof
+	return prof, nil
+}
+
+// load loads a conformance resource (a StructureDefinition or ValueSet) by its canonical URL or, failing that, as a
+// relative reference.  If there is no such resource, nil is returned.
+func (p *ProfileValidator) load(resourceType, canonical string) (interface{}, error) {
+	ids, err := p.DAL.FindIDs(search.Query{Resource: resourceType, Query: "url=" + url.QueryEscape(canonical)})
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		parts := strings.Split(strings.TrimSuffix(canonical, "/"), "/")
+		if len(parts) < 2 || parts[len(parts)-2] != resourceType {
+			return nil, nil
+		}
+		ids = []string{parts[len(parts)-1]}
+	}
+	resource, err := p.DAL.Get(ids[0], resourceType)
+	if err == ErrNotFound || err == ErrDeleted {
+		return nil, nil
+	}
+	return resource, err
+}
+
+// parseSnapshot builds the tree of element definitions from a snapshot, in which each element follows its parent and
+// each slice follows the element being sliced (with the same path) and the other slices
+func parseSnapshot(elements []models.ElementDefinition) *profileElement {
+	var root *profileElement
+	var stack []*profileElement
+	for i := range elements {
+		e := newProfileElement(&elements[i])
+		if root == nil {
+			root = e
+			stack = []*profileElement{e}
+			continue
+		}
+		for len(stack) > 1 && !strings.HasPrefix(e.def.Path, stack[len(stack)-1].def.Path+".") {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1]
+		if sliced := parent.child(e.def.Path); sliced != nil && e.def.Name != "" && sliced.def.Slicing != nil {
+			sliced.slices = append(sliced.slices, e)
+		} else {
+			parent.children = append(parent.children, e)
+		}
+		stack = append(stack, e)
+	}
+	return root
+}
+
+func newProfileElement(def *models.ElementDefinition) *profileElement {
+	e := &profileElement{def: def}
+	var values map[string]interface{}
+	if data, err := json.Marshal(def); err == nil && json.Unmarshal(data, &values) == nil {
+		for key, value := range values {
+			if strings.HasPrefix(key, "fixed") {
+				e.fixed = value
+			} else if strings.HasPrefix(key, "pattern") {
+				e.pattern = value
+			}
+		}
+	}
+	return e
+}
+
+// child returns the child element with the given path, or nil if there is none
+func (e *profileElement) child(path string) *profileElement {
+	for _, c := range e.children {
+		if c.def.Path == path {
+			return c
+		}
+	}
+	return nil
+}
+
+// descendant returns the element with the given path among the element's descendants, or nil if there is none
+func (e *profileElement) descendant(path string) *profileElement {
+	for _, c := range e.children {
+		if c.def.Path == path {
+			return c
+		} else if strings.HasPrefix(path, c.def.Path+".") {
+			return c.descendant(path)
+		}
+	}
+	return nil
+}
+
+// name returns the element's name, as it appears in its parent (e.g., "value[x]"), qualified by the slice name if the
+// element is a slice
+func (e *profileElement) name() string {
+	name := e.def.Path[strings.LastIndex(e.def.Path, ".")+1:]
+	if e.def.Name != "" {
+		name += ":" + e.def.Name
+	}
+	return name
+}
+
+// validateElement validates the children of an element (a resource or complex data type) against their definitions
+func (p *ProfileValidator) validateElement(value interface{}, e *profileElement, path, profileURL string) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, c := range e.children {
+		name := c.def.Path[strings.LastIndex(c.def.Path, ".")+1:]
+		items := instanceItems(object, name, path)
+		p.checkCardinality(c, len(items), path+"."+c.name(), profileURL)
+		if len(c.slices) > 0 {
+			p.validateSlices(items, c, path, profileURL)
+			continue
+		}
+		for _, item := range items {
+			p.validateItem(item, c, profileURL)
+		}
+	}
+}
+
+// instanceItems returns the occurrences of an element in a resource or data type.  For choice elements (e.g.,
+// "value[x]"), the occurrences of each choice (e.g., "valueString") are returned along with their data type.
+func instanceItems(object map[string]interface{}, name, path string) []instanceItem {
+	keys := make(map[string]string)
+	if strings.HasSuffix(name, "[x]") {
+		prefix := strings.TrimSuffix(name, "[x]")
+		for key := range object {
+			if dataType := strings.TrimPrefix(key, prefix); strings.HasPrefix(key, prefix) && dataType != "" && strings.ToUpper(dataType[:1]) == dataType[:1] {
+				keys[key] = dataType
+			}
+		}
+	} else if _, ok := object[name]; ok {
+		keys[name] = ""
+	}
+
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	var items []instanceItem
+	for _, key := range names {
+		if values, ok := object[key].([]interface{}); ok {
+			for i := range values {
+				items = append(items, instanceItem{values[i], fmt.Sprintf("%s.%s[%d]", path, key, i), keys[key]})
+			}
+		} else {
+			items = append(items, instanceItem{object[key], path + "." + key, keys[key]})
+		}
+	}
+	return items
+}
+
+// checkCardinality checks the number of occurrences of an element (or slice) against its definition.  Missing
+// must-support elements are reported as warnings.
+func (p *ProfileValidator) checkCardinality(e *profileElement, count int, location, profileURL string) {
+	min := 0
+	if e.def.Min != nil {
+		min = int(*e.def.Min)
+	}
+	if count < min {
+		p.addIssue("error", "required", location, "%s must occur at least %d time(s) but occurs %d time(s) (profile %s)", location, min, count, profileURL)
+	} else if max, err := strconv.Atoi(e.def.Max); err == nil && count > max {
+		p.addIssue("error", "structure", location, "%s must occur at most %d time(s) but occurs %d time(s) (profile %s)", location, max, count, profileURL)
+	} else if count == 0 && e.def.MustSupport != nil && *e.def.MustSupport {
+		p.addIssue("warning", "incomplete", location, "Must-support element %s is missing (profile %s)", location, profileURL)
+	}
+}
+
+// validateSlices assigns each occurrence of a sliced element to the first slice it matches, validating it against
+// that slice and checking the cardinality of each slice.  Occurrences matching no slice are validated against the
+// sliced element itself and, if the slicing is closed, reported as errors.
+func (p *ProfileValidator) validateSlices(items []instanceItem, sliced *profileElement, path, profileURL string) {
+	counts := make(map[*profileElement]int)
+	for _, item := range items {
+		var match *profileElement
+		for _, slice := range sliced.slices {
+			if p.matchesSlice(item, slice, sliced.def.Slicing.Discriminator) {
+				match = slice
+				break
+			}
+		}
+		if match != nil {
+			counts[match]++
+			p.validateItem(item, match, profileURL)
+			continue
+		}
+		if sliced.def.Slicing.Rules == "closed" {
+			code := "structure"
+			if sliced.name() == "extension" || sliced.name() == "modifierExtension" {
+				code = "extension"
+			}
+			p.addIssue("error", code, item.path, "%s does not match any of the slices defined for %s (profile %s)", item.path, path+"."+sliced.name(), profileURL)
+		}
+		p.validateItem(item, sliced, profileURL)
+	}
+	for _, slice := range sliced.slices {
+		p.checkCardinality(slice, counts[slice], path+"."+slice.name(), profileURL)
+	}
+}
+
+// matchesSlice determines if an occurrence of a sliced element belongs to the slice.  Each discriminator is a path,
+// relative to the element, whose fixed or pattern value in the slice must match the occurrence's value.  Extensions
+// are also discriminated by the URL of the extension definition the slice is constrained to.  A slice without
+// discriminators is matched by validating the occurrence against it.
+func (p *ProfileValidator) matchesSlice(item instanceItem, slice *profileElement, discriminators []string) bool {
+	if len(discriminators) == 0 {
+		trial := &ProfileValidator{DAL: p.DAL, profiles: p.profiles, valueSets: p.valueSets, validating: p.validating}
+		trial.validateItem(item, slice, "")
+		return !hasErrors(trial.issues)
+	}
+	for _, d := range discriminators {
+		var expected interface{}
+		if e := slice.descendant(slice.def.Path + "." + d); e != nil && e.fixed != nil {
+			expected = e.fixed
+		} else if e != nil && e.pattern != nil {
+			expected = e.pattern
+		} else if d == "url" && len(slice.def.Type) > 0 && len(slice.def.Type[0].Profile) > 0 {
+			expected = slice.def.Type[0].Profile[0]
+		} else {
+			return false
+		}
+		matched := false
+		for _, actual := range valuesAtPath(item.value, d) {
+			matched = matched || matchesPattern(actual, expected)
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// valuesAtPath returns the values found by following a dot-separated path of element names, flattening any arrays
+func valuesAtPath(value interface{}, path string) []interface{} {
+	values := []interface{}{value}
+	for _, name := range strings.Split(path, ".") {
+		var next []interface{}
+		for _, v := range values {
+			object, _ := v.(map[string]interface{})
+			if children, ok := object[name].([]interface{}); ok {
+				next = append(next, children...)
+			} else if child, ok := object[name]; ok {
+				next = append(next, child)
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// validateItem validates a single occurrence of an element against its definition
+func (p *ProfileValidator) validateItem(item instanceItem, e *profileElement, profileURL string) {
+	if item.dataType != "" && len(e.def.Type) > 0 {
+		allowed := false
+		for _, t := range e.def.Type {
+			allowed = allowed || strings.EqualFold(t.Code, item.dataType)
+		}
+		if !allowed {
+			p.addIssue("error", "structure", item.path, "%s is not an allowed type for %s (profile %s)", item.dataType, e.def.Path, profileURL)
+			return
+		}
+	}
+	if e.fixed != nil && !jsonEqual(item.value, e.fixed) {
+		p.addIssue("error", "value", item.path, "%s must have the fixed value %s (profile %s)", item.path, jsonString(e.fixed), profileURL)
+	}
+	if e.pattern != nil && !matchesPattern(item.value, e.pattern) {
+		p.addIssue("error", "value", item.path, "%s must match the pattern %s (profile %s)", item.path, jsonString(e.pattern), profileURL)
+	}
+	if e.def.Binding != nil {
+		p.checkBinding(item, e.def.Binding, profileURL)
+	}
+	if len(e.def.Type) == 1 && e.def.Type[0].Code == "Extension" {
+		p.validateExtension(item, e)
+	}
+	p.validateElement(item.value, e, item.path, profileURL)
+}
+
+// validateExtension validates an extension against its definition, identified by the profile of the element it
+// occurs in or, failing that, by its URL.  Definitions named by a profile must be stored by the server.
+func (p *ProfileValidator) validateExtension(item instanceItem, e *profileElement) {
+	object, _ := item.value.(map[string]interface{})
+	definitionURL, _ := object["url"].(string)
+	constrained := len(e.def.Type[0].Profile) > 0
+	if constrained {
+		definitionURL = e.def.Type[0].Profile[0]
+	}
+	if definitionURL == "" || p.validating[definitionURL] {
+		return
+	}
+	prof, err := p.loadProfile(definitionURL)
+	if err != nil {
+		p.addIssue("error", "exception", item.path, "Unable to load extension definition %s: %s", definitionURL, err.Error())
+		return
+	} else if prof == nil {
+		if constrained {
+			p.addIssue("error", "not-found", item.path, "Extension definition %s could not be found", definitionURL)
+		}
+		return
+	}
+	p.validating[definitionURL] = true
+	p.validateElement(item.value, prof.root, item.path, definitionURL)
+	delete(p.validating, definitionURL)
+}
+
+// jsonEqual determines if two values decoded from JSON are equal, regardless of how their numbers were decoded
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(normalizeJSON(a), normalizeJSON(b))
+}
+
+// matchesPattern determines if a value matches a pattern: every element of the pattern must be present in the value
+// with a matching value, and every item in a pattern array must match an item in the value's array
+func matchesPattern(value, pattern interface{}) bool {
+	value, pattern = normalizeJSON(value), normalizeJSON(pattern)
+	switch pattern := pattern.(type) {
+	case map[string]interface{}:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, p := range pattern {
+			if !matchesPattern(object[key], p) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		values, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, p := range pattern {
+			found := false
+			for _, v := range values {
+				found = found || matchesPattern(v, p)
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(value, pattern)
+}
+
+// normalizeJSON re-decodes a value so that all of its numbers are float64s
+func normalizeJSON(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	json.Unmarshal(data, &normalized)
+	return normalized
+}
+
+func jsonString(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// valueSetCodes are the codes in a value set, by system, and the systems whose codes are all included.  If the value
+// set could not be fully evaluated (e.g., it uses unsupported filters), complete is false.
+type valueSetCodes struct {
+	url          string
+	codes        map[string]map[string]bool
+	wholeSystems map[string]bool
+	complete     bool
+}
+
+// checkBinding checks a coded value (a code, Coding, or CodeableConcept) against the value set it is bound to.  Values
+// not in a required value set are errors, and values not in an extensible value set are warnings.
+func (p *ProfileValidator) checkBinding(item instanceItem, binding *models.ElementDefinitionBindingComponent, profileURL string) {
+	severity := map[string]string{"required": "error", "extensible": "warning"}[binding.Strength]
+	valueSetURL := binding.ValueSetUri
+	if binding.ValueSetReference != nil {
+		valueSetURL = binding.ValueSetReference.Reference
+	}
+	codings := itemCodings(item.value)
+	if severity == "" || valueSetURL == "" || len(codings) == 0 {
+		return
+	}
+
+	vs, err := p.loadValueSet(valueSetURL)
+	if err != nil {
+		p.addIssue("error", "exception", item.path, "Unable to load value set %s: %s", valueSetURL, err.Error())
+		return
+	} else if vs == nil {
+		p.addIssue("warning", "not-found", item.path, "Value set %s could not be found", valueSetURL)
+		return
+	}
+	for _, coding := range codings {
+		if vs.contains(coding[0], coding[1]) {
+			return
+		}
+	}
+	if !vs.complete {
+		p.addIssue("information", "informational", item.path, "Value set %s could not be fully evaluated", valueSetURL)
+		return
+	}
+	p.addIssue(severity, "code-invalid", item.path, "%s is not in the value set %s (profile %s)", item.path, valueSetURL, profileURL)
+}
+
+// itemCodings returns the system and code of each coding in a code, Coding, or CodeableConcept value.  The system of
+// a code is blank.
+func itemCodings(value interface{}) [][2]string {
+	switch value := value.(type) {
+	case string:
+		return [][2]string{{"", value}}
+	case map[string]interface{}:
+		if codings, ok := value["coding"].([]interface{}); ok {
+			var result [][2]string
+			for _, c := range codings {
+				result = append(result, itemCodings(c)...)
+			}
+			return result
+		}
+		if code, ok := value["code"].(string); ok {
+			system, _ := value["system"].(string)
+			return [][2]string{{system, code}}
+		}
+	}
+	return nil
+}
+
+// contains determines if a code is in the value set.  A blank system matches a code in any system.
+func (vs *valueSetCodes) contains(system, code string) bool {
+	for s := range vs.wholeSystems {
+		if system == "" || system == s {
+			return true
+		}
+	}
+	for s, codes := range vs.codes {
+		if (system == "" || system == s) && codes[code] {
+			return true
+		}
+	}
+	return false
+}
+
+// loadValueSet loads the codes of the ValueSet with the given URL, expanding it the same way :in searches do.  If
+// there is no such ValueSet, nil is returned.
+func (p *ProfileValidator) loadValueSet(valueSetURL string) (*valueSetCodes, error) {
+	if vs, ok := p.valueSets[valueSetURL]; ok {
+		return vs, nil
+	}
+	expansion, err := search.ExpandValueSet(dalValueSetSource{p}, valueSetURL)
+	if err != nil || expansion == nil {
+		return nil, err
+	}
+	vs := &valueSetCodes{
+		url:          valueSetURL,
+		codes:        make(map[string]map[string]bool),
+		wholeSystems: make(map[string]bool),
+		complete:     expansion.Complete,
+	}
+	for _, code := range expansion.Codes {
+		if code.Code == "" {
+			vs.wholeSystems[code.System] = true
+			continue
+		}
+		if vs.codes[code.System] == nil {
+			vs.codes[code.System] = make(map[string]bool)
+		}
+		vs.codes[code.System][code.Code] = true
+	}
+	p.valueSets[valueSetURL] = vs
+	return vs, nil
+}
+
+// dalValueSetSource provides the ValueSets stored in the validator's DataAccessLayer to value set expansions
+type dalValueSetSource struct {
+	p *ProfileValidator
+}
+
+func (s dalValueSetSource) ValueSet(url string) (*models.ValueSet, error) {
+	resource, err := s.p.load("ValueSet", url)
+	if err != nil || resource == nil {
+		return nil, err
+	}
+	return resource.(*models.ValueSet), nil
+}
+
+func (s dalValueSetSource) CodeSystems(system string) ([]*models.ValueSet, error) {
+	var query string
+	if system != "" {
+		query = "system=" + url.QueryEscape(system)
+	}
+	ids, err := s.p.DAL.FindIDs(search.Query{Resource: "ValueSet", Query: query})
+	if err != nil {
+		return nil, err
+	}
+	var valueSets []*models.ValueSet
+	for _, id := range ids {
+		resource, err := s.p.DAL.Get(id, "ValueSet")
+		if err == ErrNotFound || err == ErrDeleted {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if vs := resource.(*models.ValueSet); vs.CodeSystem != nil && (system == "" || vs.CodeSystem.System == system) {
+			valueSets = append(valueSets, vs)
+		}
+	}
+	return valueSets, nil
+}
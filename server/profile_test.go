@@ -0,0 +1,343 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/intervention-engine/fhir/models"
+	"github.com/intervention-engine/fhir/search"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+const (
+	patientProfileURL    = "http://example.org/StructureDefinition/registered-patient"
+	birthPlaceProfileURL = "http://example.org/StructureDefinition/birth-place"
+)
+
+type ProfileSuite struct {
+	DAL    *conformanceDAL
+	Engine *gin.Engine
+}
+
+var _ = Suite(&ProfileSuite{})
+
+// conformanceDAL serves StructureDefinition and ValueSet resources by their URLs (and ValueSets by the code systems
+// they define), and accepts any created resource
+type conformanceDAL struct {
+	DataAccessLayer
+	resources map[string]interface{}
+}
+
+func (d *conformanceDAL) FindIDs(query search.Query) ([]string, error) {
+	values, err := url.ParseQuery(query.Query)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := d.resources[values.Get("url")]; ok {
+		return []string{values.Get("url")}, nil
+	}
+	if system := values.Get("system"); system != "" {
+		var ids []string
+		for id, resource := range d.resources {
+			if vs, ok := resource.(*models.ValueSet); ok && vs.CodeSystem != nil && vs.CodeSystem.System == system {
+				ids = append(ids, id)
+			}
+		}
+		return ids, nil
+	}
+	return nil, nil
+}
+
+func (d *conformanceDAL) Get(id, resourceType string) (interface{}, error) {
+	if resource, ok := d.resources[id]; ok {
+		return resource, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (d *conformanceDAL) Post(resource interface{}) (string, error) {
+	return "123", nil
+}
+
+func (d *conformanceDAL) Put(id string, resource interface{}) (bool, error) {
+	return false, nil
+}
+
+func (d *conformanceDAL) PutIfMatch(id, versionID string, resource interface{}) error {
+	return nil
+}
+
+func (d *conformanceDAL) add(url, data string) {
+	resource := models.MapToResource(decode(data), true)
+	d.resources[url] = resource
+}
+
+func decode(data string) map[string]interface{} {
+	var doc map[string]interface{}
+	util.CheckErr(json.Unmarshal([]byte(data), &doc))
+	return doc
+}
+
+func (p *ProfileSuite) SetUpSuite(c *C) {
+	p.DAL = &conformanceDAL{resources: make(map[string]interface{})}
+	p.DAL.add(patientProfileURL, `{
+		"resourceType": "StructureDefinition",
+		"url": "`+patientProfileURL+`",
+		"snapshot": {"element": [
+			{"path": "Patient", "min": 0, "max": "*"},
+			{"path": "Patient.extension", "slicing": {"discriminator": ["url"], "rules": "open"}, "min": 0, "max": "*"},
+			{"path": "Patient.extension", "name": "birthPlace", "min": 1, "max": "1",
+			 "type": [{"code": "Extension", "profile": ["`+birthPlaceProfileURL+`"]}]},
+			{"path": "Patient.identifier", "slicing": {"discriminator": ["system"], "rules": "closed"}, "min": 1, "max": "*"},
+			{"path": "Patient.identifier", "name": "mrn", "min": 1, "max": "1"},
+			{"path": "Patient.identifier.system", "min": 1, "max": "1", "fixedUri": "http://example.org/mrn"},
+			{"path": "Patient.identifier.value", "min": 1, "max": "1"},
+			{"path": "Patient.active", "min": 0, "max": "1", "fixedBoolean": true},
+			{"path": "Patient.telecom", "min": 0, "max": "*", "mustSupport": true},
+			{"path": "Patient.gender", "min": 1, "max": "1",
+			 "binding": {"strength": "required", "valueSetReference": {"reference": "http://example.org/ValueSet/binary-gender"}}},
+			{"path": "Patient.deceased[x]", "min": 0, "max": "1", "type": [{"code": "boolean"}]},
+			{"path": "Patient.maritalStatus", "min": 0, "max": "1",
+			 "patternCodeableConcept": {"coding": [{"system": "http://hl7.org/fhir/v3/MaritalStatus"}]}}
+		]}
+	}`)
+	p.DAL.add(birthPlaceProfileURL, `{
+		"resourceType": "StructureDefinition",
+		"url": "`+birthPlaceProfileURL+`",
+		"snapshot": {"element": [
+			{"path": "Extension", "min": 0, "max": "*"},
+			{"path": "Extension.url", "min": 1, "max": "1", "fixedUri": "`+birthPlaceProfileURL+`"},
+			{"path": "Extension.value[x]", "min": 1, "max": "1", "type": [{"code": "string"}]}
+		]}
+	}`)
+	p.DAL.add("http://example.org/ValueSet/binary-gender", `{
+		"resourceType": "ValueSet",
+		"url": "http://example.org/ValueSet/binary-gender",
+		"status": "active",
+		"compose": {"include": [{"system": "http://hl7.org/fhir/administrative-gender", "concept": [{"code": "male"}, {"code": "female"}]}]}
+	}`)
+
+	config := DefaultConfig
+	config.RequiredProfiles = map[string]string{"Patient": patientProfileURL}
+
+	gin.SetMode(gin.ReleaseMode)
+	p.Engine = gin.New()
+	p.Engine.Use(NegotiateFormat)
+	RegisterServerRoutes(p.Engine, make(map[string][]gin.HandlerFunc), p.DAL, config)
+}
+
+func (p *ProfileSuite) post(path, body string) (int, map[string]interface{}) {
+	req, err := http.NewRequest("POST", path, strings.NewReader(body))
+	util.CheckErr(err)
+	req.Header.Set("Content-Type", "application/json+fhir")
+	w := httptest.NewRecorder()
+	p.Engine.ServeHTTP(w, req)
+
+	var result map[string]interface{}
+	util.CheckErr(json.Unmarshal(w.Body.Bytes(), &result))
+	return w.Code, result
+}
+
+const conformingPatient = `{
+	"resourceType": "Patient",
+	"meta": {"profile": ["` + patientProfileURL + `"]},
+	"extension": [
+		{"url": "http://example.org/StructureDefinition/nickname", "valueString": "Bob"},
+		{"url": "` + birthPlaceProfileURL + `", "valueString": "Bedford, MA"}
+	],
+	"identifier": [{"system": "http://example.org/mrn", "value": "12345"}],
+	"active": true,
+	"telecom": [{"system": "phone", "value": "555-1234"}],
+	"gender": "male",
+	"maritalStatus": {"coding": [{"system": "http://hl7.org/fhir/v3/MaritalStatus", "code": "M"}], "text": "Married"}
+}`
+
+func (p *ProfileSuite) TestConformingResource(c *C) {
+	issues := NewProfileValidator(p.DAL).Validate(decode(conformingPatient))
+	c.Assert(issues, HasLen, 0)
+}
+
+func (p *ProfileSuite) TestNonConformingResource(c *C) {
+	issues := NewProfileValidator(p.DAL).Validate(decode(`{
+		"resourceType": "Patient",
+		"meta": {"profile": ["` + patientProfileURL + `"]},
+		"extension": [{"url": "` + birthPlaceProfileURL + `", "valueInteger": 5}],
+		"identifier": [{"system": "http://example.org/mrn"}, {"system": "http://example.org/ssn", "value": "1"}],
+		"active": false,
+		"gender": "other",
+		"deceasedDateTime": "2016-01-01",
+		"maritalStatus": {"coding": [{"system": "http://snomed.info/sct", "code": "87915002"}]}
+	}`))
+	locations := make(map[string]string)
+	for _, issue := range issues {
+		c.Assert(issue.Location, HasLen, 1)
+		locations[issue.Location[0]] = issue.Severity + " " + issue.Code + ": " + strings.TrimSuffix(issue.Diagnostics, " (profile "+patientProfileURL+")")
+	}
+	c.Assert(locations, DeepEquals, map[string]string{
+		"Patient.extension[0].valueInteger": "error structure: Integer is not an allowed type for Extension.value[x] (profile " + birthPlaceProfileURL + ")",
+		"Patient.identifier[0].value":       "error required: Patient.identifier[0].value must occur at least 1 time(s) but occurs 0 time(s)",
+		"Patient.identifier[1]":             "error structure: Patient.identifier[1] does not match any of the slices defined for Patient.identifier",
+		"Patient.active":                    "error value: Patient.active must have the fixed value true",
+		"Patient.telecom":                   "warning incomplete: Must-support element Patient.telecom is missing",
+		"Patient.gender":                    "error code-invalid: Patient.gender is not in the value set http://example.org/ValueSet/binary-gender",
+		"Patient.deceasedDateTime":          "error structure: DateTime is not an allowed type for Patient.deceased[x]",
+		"Patient.maritalStatus":             `error value: Patient.maritalStatus must match the pattern {"coding":[{"system":"http://hl7.org/fhir/v3/MaritalStatus"}]}`,
+	})
+}
+
+func (p *ProfileSuite) TestValueSetIncludingWholeSystem(c *C) {
+	p.DAL.add("http://example.org/ValueSet/any-gender", `{
+		"resourceType": "ValueSet",
+		"url": "http://example.org/ValueSet/any-gender",
+		"status": "active",
+		"compose": {"include": [
+			{"system": "http://hl7.org/fhir/administrative-gender"},
+			{"system": "http://hl7.org/fhir/administrative-gender", "concept": [{"code": "male"}]}
+		]},
+		"expansion": {"contains": [{"system": "http://hl7.org/fhir/administrative-gender", "code": "female"}]}
+	}`)
+	vs, err := NewProfileValidator(p.DAL).loadValueSet("http://example.org/ValueSet/any-gender")
+	util.CheckErr(err)
+	c.Assert(vs.complete, Equals, true)
+	// The whole system is included, not just the codes listed for it
+	c.Assert(vs.contains("http://hl7.org/fhir/administrative-gender", "other"), Equals, true)
+	c.Assert(vs.contains("", "unknown"), Equals, true)
+	c.Assert(vs.contains("http://example.org/gender", "other"), Equals, false)
+}
+
+func (p *ProfileSuite) TestValueSetExpandedLikeSearches(c *C) {
+	p.DAL.add("http://example.org/ValueSet/cardiac", `{
+		"resourceType": "ValueSet",
+		"url": "http://example.org/ValueSet/cardiac",
+		"status": "active",
+		"codeSystem": {"system": "http://snomed.info/sct", "concept": [
+			{"code": "49601007", "concept": [
+				{"code": "10091002", "concept": [{"code": "981000124106"}]},
+				{"code": "123641001"}
+			]}
+		]}
+	}`)
+	p.DAL.add("http://example.org/ValueSet/heart", `{
+		"resourceType": "ValueSet",
+		"url": "http://example.org/ValueSet/heart",
+		"status": "active",
+		"compose": {
+			"import": ["http://example.org/ValueSet/binary-gender"],
+			"include": [{"system": "http://snomed.info/sct", "filter": [{"property": "concept", "op": "is-a", "value": "10091002"}]}],
+			"exclude": [{"system": "http://hl7.org/fhir/administrative-gender", "concept": [{"code": "female"}]}]
+		}
+	}`)
+	vs, err := NewProfileValidator(p.DAL).loadValueSet("http://example.org/ValueSet/heart")
+	util.CheckErr(err)
+	c.Assert(vs.complete, Equals, true)
+	c.Assert(vs.contains("http://snomed.info/sct", "10091002"), Equals, true)
+	c.Assert(vs.contains("http://snomed.info/sct", "981000124106"), Equals, true)
+	c.Assert(vs.contains("http://snomed.info/sct", "123641001"), Equals, false)
+	c.Assert(vs.contains("http://hl7.org/fhir/administrative-gender", "male"), Equals, true)
+	c.Assert(vs.contains("http://hl7.org/fhir/administrative-gender", "female"), Equals, false)
+
+	// Unsupported filters make the value set incomplete
+	p.DAL.add("http://example.org/ValueSet/regex", `{
+		"resourceType": "ValueSet",
+		"url": "http://example.org/ValueSet/regex",
+		"status": "active",
+		"compose": {"include": [{"system": "http://snomed.info/sct", "filter": [{"property": "code", "op": "regex", "value": "^1"}]}]}
+	}`)
+	vs, err = NewProfileValidator(p.DAL).loadValueSet("http://example.org/ValueSet/regex")
+	util.CheckErr(err)
+	c.Assert(vs.complete, Equals, false)
+}
+
+func (p *ProfileSuite) TestMissingProfiles(c *C) {
+	issues := NewProfileValidator(p.DAL).Validate(decode(`{
+		"resourceType": "Observation",
+		"meta": {"profile": ["http://example.org/StructureDefinition/unknown"]}
+	}`), patientProfileURL, "http://example.org/StructureDefinition/required")
+	c.Assert(issues, HasLen, 3)
+	c.Assert(issues[0].Diagnostics, Equals, "Profile "+patientProfileURL+" applies to Patient, not Observation")
+	c.Assert(issues[1].Severity, Equals, "error")
+	c.Assert(issues[1].Diagnostics, Equals, "Profile http://example.org/StructureDefinition/required could not be found")
+	c.Assert(issues[2].Severity, Equals, "warning")
+	c.Assert(issues[2].Diagnostics, Equals, "Profile http://example.org/StructureDefinition/unknown could not be found")
+}
+
+func (p *ProfileSuite) TestRequiredProfileEnforced(c *C) {
+	status, _ := p.post("/Patient", conformingPatient)
+	c.Assert(status, Equals, http.StatusCreated)
+
+	// The required profile applies even though the resource doesn't claim it
+	status, result := p.post("/Patient", `{"resourceType": "Patient", "gender": "male"}`)
+	c.Assert(status, Equals, http.StatusUnprocessableEntity)
+	c.Assert(result["resourceType"], Equals, "OperationOutcome")
+	c.Assert(result["issue"], HasLen, 4)
+
+	status, result = p.post("/", `{
+		"resourceType": "Bundle",
+		"type": "batch",
+		"entry": [{"resource": {"resourceType": "Patient"}, "request": {"method": "POST", "url": "Patient"}}]
+	}`)
+	c.Assert(status, Equals, http.StatusOK)
+	entry := result["entry"].([]interface{})[0].(map[string]interface{})
+	c.Assert(entry["response"].(map[string]interface{})["status"], Equals, "422")
+	c.Assert(entry["resource"].(map[string]interface{})["resourceType"], Equals, "OperationOutcome")
+}
+
+func (p *ProfileSuite) TestRequiredProfileEnforcedOnPatch(c *C) {
+	p.DAL.add("patient-1", conformingPatient)
+	patch := func(body string) (int, map[string]interface{}) {
+		req, err := http.NewRequest("PATCH", "/Patient/patient-1", strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Set("Content-Type", "application/json-patch+json")
+		w := httptest.NewRecorder()
+		p.Engine.ServeHTTP(w, req)
+		var result map[string]interface{}
+		util.CheckErr(json.Unmarshal(w.Body.Bytes(), &result))
+		return w.Code, result
+	}
+
+	status, _ := patch(`[{"op": "replace", "path": "/gender", "value": "female"}]`)
+	c.Assert(status, Equals, http.StatusOK)
+
+	status, result := patch(`[{"op": "replace", "path": "/gender", "value": "other"}]`)
+	c.Assert(status, Equals, http.StatusUnprocessableEntity)
+	c.Assert(result["resourceType"], Equals, "OperationOutcome")
+	c.Assert(result["issue"], HasLen, 1)
+
+	status, result = p.post("/", `{
+		"resourceType": "Bundle",
+		"type": "batch",
+		"entry": [{
+			"resource": {"resourceType": "Parameters", "parameter": [{"name": "operation", "part": [
+				{"name": "type", "valueCode": "delete"},
+				{"name": "path", "valueString": "Patient.identifier"}
+			]}]},
+			"request": {"method": "PATCH", "url": "Patient/patient-1"}
+		}]
+	}`)
+	c.Assert(status, Equals, http.StatusOK)
+	entry := result["entry"].([]interface{})[0].(map[string]interface{})
+	c.Assert(entry["response"].(map[string]interface{})["status"], Equals, "422")
+	c.Assert(entry["resource"].(map[string]interface{})["resourceType"], Equals, "OperationOutcome")
+}
+
+func (p *ProfileSuite) TestValidateOperationWithProfile(c *C) {
+	status, result := p.post("/Patient/$validate", `{
+		"resourceType": "Parameters",
+		"parameter": [
+			{"name": "resource", "resource": {"resourceType": "Patient", "gender": "male"}},
+			{"name": "profile", "valueUri": "`+patientProfileURL+`"}
+		]
+	}`)
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(result["issue"], HasLen, 4)
+
+	status, result = p.post("/Patient/$validate", conformingPatient)
+	c.Assert(status, Equals, http.StatusOK)
+	issue := result["issue"].([]interface{})[0].(map[string]interface{})
+	c.Assert(issue["diagnostics"], Equals, "The resource is valid")
+}
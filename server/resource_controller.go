@@ -1,9 +1,12 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -45,7 +48,10 @@ func (rc *ResourceController) IndexHandler(c *gin.Context) {
 	searchQuery := search.Query{Resource: rc.Name, Query: c.Request.URL.RawQuery}
 	baseURL := responseURL(c.Request, rc.Name)
 	bundle, err := rc.DAL.Search(*baseURL, searchQuery)
-	if err != nil {
+	if searchErr, ok := err.(*search.Error); ok {
+		c.JSON(searchErr.HTTPStatus, searchErr.OperationOutcome)
+		return
+	} else if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
@@ -72,9 +78,25 @@ func (rc *ResourceController) LoadResource(c *gin.Context) (interface{}, error)
 
 // ShowHandler handles requests to get a particular resource by ID.
 func (rc *ResourceController) ShowHandler(c *gin.Context) {
+	// The type-level history shares its route with read, so hand it off here
+	if c.Param("id") == "_history" {
+		rc.HistoryHandler(c)
+		return
+	}
+	// As does the type-level $everything operation for patients
+	if c.Param("id") == "$everything" && rc.Name == "Patient" {
+		rc.EverythingHandler(c)
+		return
+	}
+	// And any other type-level operations
+	if strings.HasPrefix(c.Param("id"), "$") {
+		rc.OperationHandler(c)
+		return
+	}
+
 	c.Set("Action", "read")
 	_, err := rc.LoadResource(c)
-	if err != nil && err != ErrNotFound {
+	if err != nil && err != ErrNotFound && err != ErrDeleted {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
@@ -82,19 +104,162 @@ func (rc *ResourceController) ShowHandler(c *gin.Context) {
 	if err == ErrNotFound {
 		c.Status(http.StatusNotFound)
 		return
+	} else if err == ErrDeleted {
+		c.Status(http.StatusGone)
+		return
 	}
 	resource, _ := c.Get(rc.Name)
+	setETagHeader(c, resource)
+
+	// Support for _summary and _elements
+	options, err := readOptions(rc.Name, c.Request.URL.Query())
+	if err != nil {
+		searchErr := err.(*search.Error)
+		c.JSON(searchErr.HTTPStatus, searchErr.OperationOutcome)
+		return
+	}
+	if resource, err = subsetResource(resource, options); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
 	c.JSON(http.StatusOK, resource)
 }
 
-// CreateHandler handles requests to create a new resource instance, assigning it a new ID.
-func (rc *ResourceController) CreateHandler(c *gin.Context) {
-	resource := models.NewStructForResourceName(rc.Name)
-	err := FHIRBind(c, resource)
-	if err != nil {
-		oo := models.NewOperationOutcome("fatal", "exception", err.Error())
+// VReadHandler handles requests to get a particular version of a resource by ID and version ID.
+func (rc *ResourceController) VReadHandler(c *gin.Context) {
+	c.Set("Action", "vread")
+	resource, err := rc.DAL.GetVersion(c.Param("id"), c.Param("vid"), rc.Name)
+	if err == ErrNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	} else if err == ErrDeleted {
+		c.Status(http.StatusGone)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Set(rc.Name, resource)
+	c.Set("Resource", rc.Name)
+	setETagHeader(c, resource)
+	c.JSON(http.StatusOK, resource)
+}
+
+// HistoryHandler handles requests for the version history of a particular resource or, when no ID is given, of all
+// resources of the controller's type.
+func (rc *ResourceController) HistoryHandler(c *gin.Context) {
+	historyQuery := HistoryQuery{Resource: rc.Name, Query: c.Request.URL.RawQuery}
+	baseURL := responseURL(c.Request, rc.Name, "_history")
+	if id := c.Param("id"); id != "_history" {
+		historyQuery.ID = id
+		baseURL = responseURL(c.Request, rc.Name, id, "_history")
+	}
+
+	bundle, err := rc.DAL.History(*baseURL, historyQuery)
+	if oo, ok := err.(*models.OperationOutcome); ok {
 		c.JSON(http.StatusBadRequest, oo)
 		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Set("bundle", bundle)
+	c.Set("Resource", rc.Name)
+	c.Set("Action", "history")
+
+	c.JSON(http.StatusOK, bundle)
+}
+
+// EverythingHandler handles requests for the Patient $everything operation, returning the resources in the patient
+// compartment (and the resources they reference) of a particular patient or, when no ID is given, of all patients.
+func (rc *ResourceController) EverythingHandler(c *gin.Context) {
+	everythingQuery := EverythingQuery{Query: c.Request.URL.RawQuery}
+	baseURL := responseURL(c.Request, rc.Name, "$everything")
+	if id := c.Param("id"); id != "$everything" {
+		everythingQuery.ID = id
+		baseURL = responseURL(c.Request, rc.Name, id, "$everything")
+	}
+
+	bundle, err := rc.DAL.Everything(*baseURL, everythingQuery)
+	if oo, ok := err.(*models.OperationOutcome); ok {
+		c.JSON(http.StatusBadRequest, oo)
+		return
+	} else if err == ErrNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	} else if err == ErrDeleted {
+		c.Status(http.StatusGone)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Set("bundle", bundle)
+	c.Set("Resource", rc.Name)
+	c.Set("Action", "everything")
+
+	c.JSON(http.StatusOK, bundle)
+}
+
+// OperationHandler handles requests to invoke a registered operation on the controller's resource type or, when an ID
+// is given, on a particular resource.  Type-level operations share their routes with read (GET /Type/:id) and
+// POST /Type/:id, so the operation is identified by either the ID or the last segment of the request path.
+func (rc *ResourceController) OperationHandler(c *gin.Context) {
+	code, id := path.Base(c.Request.URL.Path), c.Param("id")
+	if !strings.HasPrefix(code, "$") {
+		// POST /Type/:id is only routed to support type-level operations
+		c.Status(http.StatusMethodNotAllowed)
+		return
+	}
+	if id == code {
+		id = ""
+	}
+	c.Set("Resource", rc.Name)
+	invokeOperation(c, rc.DAL, requestConfig(c), strings.TrimPrefix(code, "$"), rc.Name, id)
+}
+
+// CreateHandler handles requests to create a new resource instance, assigning it a new ID.  If the request has an
+// If-None-Exist header, the resource is only created if no existing resources match the header's search criteria.  If
+// one resource matches, it is returned instead.  Criteria resulting in more than one match is considered an error.
+func (rc *ResourceController) CreateHandler(c *gin.Context) {
+	resource := rc.bindResource(c)
+	if resource == nil {
+		return
+	}
+
+	if ifNoneExist := c.Request.Header.Get("If-None-Exist"); ifNoneExist != "" {
+		existingID, err := findIfNoneExistMatch(rc.DAL, rc.Name, ifNoneExist)
+		if err == ErrMultipleMatches {
+			c.AbortWithStatus(http.StatusPreconditionFailed)
+			return
+		} else if searchErr, ok := err.(*search.Error); ok {
+			c.JSON(searchErr.HTTPStatus, searchErr.OperationOutcome)
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		if existingID != "" {
+			existing, err := rc.DAL.Get(existingID, rc.Name)
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+
+			c.Set(rc.Name, existing)
+			c.Set("Resource", rc.Name)
+			c.Set("Action", "read")
+
+			location := responseURL(c.Request, rc.Name, existingID).String()
+			c.Header("Location", location)
+			setETagHeader(c, existing)
+			respondWithResource(c, http.StatusOK, existing, "Found existing "+location)
+			return
+		}
 	}
 
 	id, err := rc.DAL.Post(resource)
@@ -107,23 +272,35 @@ func (rc *ResourceController) CreateHandler(c *gin.Context) {
 	c.Set("Resource", rc.Name)
 	c.Set("Action", "create")
 
-	c.Header("Location", responseURL(c.Request, rc.Name, id).String())
-	c.JSON(http.StatusCreated, resource)
+	location := responseURL(c.Request, rc.Name, id).String()
+	c.Header("Location", location)
+	setETagHeader(c, resource)
+	respondWithResource(c, http.StatusCreated, resource, "Created "+location)
 }
 
 // UpdateHandler handles requests to update a resource having a given ID.  If the resource with that ID does not
-// exist, a new resource is created with that ID.
+// exist, a new resource is created with that ID.  If the request has an If-Match header, the resource is only updated
+// if the header matches the resource's current version.
 func (rc *ResourceController) UpdateHandler(c *gin.Context) {
-	resource := models.NewStructForResourceName(rc.Name)
-	err := FHIRBind(c, resource)
-	if err != nil {
-		oo := models.NewOperationOutcome("fatal", "exception", err.Error())
-		c.JSON(http.StatusBadRequest, oo)
+	resource := rc.bindResource(c)
+	if resource == nil {
 		return
 	}
 
-	createdNew, err := rc.DAL.Put(c.Param("id"), resource)
-	if err != nil {
+	var createdNew bool
+	var err error
+	if ifMatch := c.Request.Header.Get("If-Match"); ifMatch != "" {
+		err = rc.DAL.PutIfMatch(c.Param("id"), parseETag(ifMatch), resource)
+	} else {
+		createdNew, err = rc.DAL.Put(c.Param("id"), resource)
+	}
+	if err == ErrPreconditionFailed {
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	} else if err == ErrConflict {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	} else if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
@@ -131,13 +308,15 @@ func (rc *ResourceController) UpdateHandler(c *gin.Context) {
 	c.Set(rc.Name, resource)
 	c.Set("Resource", rc.Name)
 
-	c.Header("Location", responseURL(c.Request, rc.Name, c.Param("id")).String())
+	location := responseURL(c.Request, rc.Name, c.Param("id")).String()
+	c.Header("Location", location)
+	setETagHeader(c, resource)
 	if createdNew {
 		c.Set("Action", "create")
-		c.JSON(http.StatusCreated, resource)
+		respondWithResource(c, http.StatusCreated, resource, "Created "+location)
 	} else {
 		c.Set("Action", "update")
-		c.JSON(http.StatusOK, resource)
+		respondWithResource(c, http.StatusOK, resource, "Updated "+location)
 	}
 }
 
@@ -146,11 +325,8 @@ func (rc *ResourceController) UpdateHandler(c *gin.Context) {
 // results in one found resource, that resource will be updated.  Criteria resulting in more than one found resource
 // is considered an error.
 func (rc *ResourceController) ConditionalUpdateHandler(c *gin.Context) {
-	resource := models.NewStructForResourceName(rc.Name)
-	err := FHIRBind(c, resource)
-	if err != nil {
-		oo := models.NewOperationOutcome("fatal", "exception", err.Error())
-		c.JSON(http.StatusBadRequest, oo)
+	resource := rc.bindResource(c)
+	if resource == nil {
 		return
 	}
 
@@ -159,6 +335,9 @@ func (rc *ResourceController) ConditionalUpdateHandler(c *gin.Context) {
 	if err == ErrMultipleMatches {
 		c.AbortWithStatus(http.StatusPreconditionFailed)
 		return
+	} else if err == ErrConflict {
+		c.AbortWithStatus(http.StatusConflict)
+		return
 	} else if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -166,21 +345,110 @@ func (rc *ResourceController) ConditionalUpdateHandler(c *gin.Context) {
 
 	c.Set("Resource", rc.Name)
 
-	c.Header("Location", responseURL(c.Request, rc.Name, id).String())
+	location := responseURL(c.Request, rc.Name, id).String()
+	c.Header("Location", location)
+	setETagHeader(c, resource)
 	if createdNew {
 		c.Set("Action", "create")
-		c.JSON(http.StatusCreated, resource)
+		respondWithResource(c, http.StatusCreated, resource, "Created "+location)
 	} else {
 		c.Set("Action", "update")
-		c.JSON(http.StatusOK, resource)
+		respondWithResource(c, http.StatusOK, resource, "Updated "+location)
+	}
+}
+
+// PatchHandler handles requests to change part of a resource having a given ID.  The request body is either a JSON
+// Patch document (application/json-patch+json) or a FHIRPath Patch Parameters resource.  If the request has an If-Match
+// header, the resource is only patched if the header matches the resource's current version.
+func (rc *ResourceController) PatchHandler(c *gin.Context) {
+	rc.patch(c, c.Param("id"))
+}
+
+// ConditionalPatchHandler handles requests to patch the resource identified by search criteria.  Criteria resulting
+// in no found resources or more than one found resource is considered an error.
+func (rc *ResourceController) ConditionalPatchHandler(c *gin.Context) {
+	query := search.Query{Resource: rc.Name, Query: c.Request.URL.RawQuery}
+	IDs, err := rc.DAL.FindIDs(query)
+	if searchErr, ok := err.(*search.Error); ok {
+		c.JSON(searchErr.HTTPStatus, searchErr.OperationOutcome)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	switch len(IDs) {
+	case 0:
+		c.Status(http.StatusNotFound)
+	case 1:
+		rc.patch(c, IDs[0])
+	default:
+		c.AbortWithStatus(http.StatusPreconditionFailed)
 	}
 }
 
-// DeleteHandler handles requests to delete a resource instance identified by its ID.
+func (rc *ResourceController) patch(c *gin.Context, id string) {
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	patch, err := ParsePatch(c.ContentType(), body)
+	if err != nil {
+		pe := err.(*PatchError)
+		c.JSON(pe.HTTPStatus, pe.OperationOutcome)
+		return
+	}
+
+	resource, err := patchResource(rc.DAL, requestConfig(c), rc.Name, id, c.Request.Header.Get("If-Match"), patch)
+	if pe, ok := err.(*PatchError); ok {
+		c.JSON(pe.HTTPStatus, pe.OperationOutcome)
+		return
+	} else if err == ErrNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	} else if err == ErrDeleted {
+		c.Status(http.StatusGone)
+		return
+	} else if err == ErrPreconditionFailed {
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	} else if err == ErrConflict {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Set(rc.Name, resource)
+	c.Set("Resource", rc.Name)
+	c.Set("Action", "patch")
+
+	location := responseURL(c.Request, rc.Name, id).String()
+	c.Header("Location", location)
+	setETagHeader(c, resource)
+	respondWithResource(c, http.StatusOK, resource, "Patched "+location)
+}
+
+// DeleteHandler handles requests to delete a resource instance identified by its ID.  If the request has an If-Match
+// header, the resource is only deleted if the header matches the resource's current version.
 func (rc *ResourceController) DeleteHandler(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := rc.DAL.Delete(id, rc.Name); err != nil && err != ErrNotFound {
+	var err error
+	if ifMatch := c.Request.Header.Get("If-Match"); ifMatch != "" {
+		err = rc.DAL.DeleteIfMatch(id, parseETag(ifMatch), rc.Name)
+	} else {
+		err = rc.DAL.Delete(id, rc.Name)
+	}
+	if err == ErrPreconditionFailed {
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	} else if err == ErrConflict {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	} else if err != nil && err != ErrNotFound {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
@@ -208,6 +476,100 @@ func (rc *ResourceController) ConditionalDeleteHandler(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// bindResource binds the request body to a new resource of the controller's type.  If the server is configured to
+// validate resources, the body is validated first.  The resource is then validated against its profiles.  If the
+// body cannot be bound or is invalid, an OperationOutcome is returned as the response and bindResource returns nil.
+func (rc *ResourceController) bindResource(c *gin.Context) interface{} {
+	resource := models.NewStructForResourceName(rc.Name)
+	if !requestConfig(c).ValidateResources {
+		if err := FHIRBind(c, resource); err != nil {
+			c.JSON(http.StatusBadRequest, models.NewOperationOutcome("fatal", "exception", err.Error()))
+			return nil
+		}
+		return rc.checkProfiles(c, resource)
+	}
+
+	data, err := ioutil.ReadAll(c.Request.Body)
+	if err == nil && strings.Contains(c.ContentType(), "xml") {
+		data, err = ConvertXMLToJSON(data)
+	}
+	if err == nil {
+		if issues := ValidateResource(data); len(issues) > 0 {
+			c.JSON(http.StatusBadRequest, validationOutcome(issues))
+			return nil
+		}
+		err = json.Unmarshal(data, resource)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.NewOperationOutcome("fatal", "exception", err.Error()))
+		return nil
+	}
+	return rc.checkProfiles(c, resource)
+}
+
+// checkProfiles validates a bound resource against its profiles, responding with 422 Unprocessable Entity and the
+// OperationOutcome describing its problems (and returning nil) if it does not conform.
+func (rc *ResourceController) checkProfiles(c *gin.Context, resource interface{}) interface{} {
+	if err := validateProfiles(rc.DAL, requestConfig(c), resource); err != nil {
+		if outcome, ok := err.(*models.OperationOutcome); ok {
+			c.JSON(http.StatusUnprocessableEntity, outcome)
+		} else {
+			c.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return nil
+	}
+	return resource
+}
+
+// findIfNoneExistMatch searches for resources matching the criteria of an If-None-Exist header (or a batch entry's
+// ifNoneExist).  It returns the matching resource's ID, or an empty string if there is no match.  ErrMultipleMatches
+// is returned if more than one resource matches.  If the criteria are invalid, the *search.Error describing the
+// problem is returned.
+func findIfNoneExistMatch(dal DataAccessLayer, resourceType, criteria string) (id string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			searchErr, ok := r.(*search.Error)
+			if !ok {
+				panic(r)
+			}
+			id, err = "", searchErr
+		}
+	}()
+
+	query := search.Query{Resource: resourceType, Query: strings.TrimPrefix(criteria, "?")}
+	IDs, err := dal.FindIDs(query)
+	if err != nil {
+		return "", err
+	}
+	switch len(IDs) {
+	case 0:
+		return "", nil
+	case 1:
+		return IDs[0], nil
+	default:
+		return "", ErrMultipleMatches
+	}
+}
+
+// setETagHeader sets the ETag header to the weak ETag representing the resource's version, if it has one.
+func setETagHeader(c *gin.Context, resource interface{}) {
+	if vid := versionID(resource); vid != "" {
+		c.Header("ETag", weakETag(vid))
+	}
+}
+
+// weakETag returns the weak ETag representing the given version ID (e.g., W/"3").
+func weakETag(versionID string) string {
+	return fmt.Sprintf("W/\"%s\"", versionID)
+}
+
+// parseETag extracts the version ID from an ETag, such as one passed in an If-Match header.  Both weak (W/"3") and
+// strong ("3") ETags are accepted, as is "*" (i.e., AnyVersion).
+func parseETag(etag string) string {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	return strings.Trim(etag, "\"")
+}
+
 func responseURL(r *http.Request, paths ...string) *url.URL {
 	responseURL := url.URL{}
 	if r.TLS == nil {
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/intervention-engine/fhir/auth"
+)
+
+// RegisterServerRoutes registers all of the server's routes: the generated CRUD and batch routes (see RegisterRoutes)
+// followed by the routes that are not generated (see RegisterExtendedRoutes).  The server's Config is made available
+// to every handler through the request context.
+func RegisterServerRoutes(e *gin.Engine, config map[string][]gin.HandlerFunc, dal DataAccessLayer, serverConfig Config) {
+	e.Use(configHandler(serverConfig))
+	RegisterRoutes(e, config, dal, serverConfig)
+	RegisterExtendedRoutes(e, config, dal, serverConfig)
+}
+
+// RegisterExtendedRoutes registers the routes that are not generated: PATCH, history, vread, $everything, extended
+// operations and the conformance statement.  The resource routes are added for each resource that RegisterRoutes
+// registered, so it must be called after RegisterRoutes.
+func RegisterExtendedRoutes(e *gin.Engine, config map[string][]gin.HandlerFunc, dal DataAccessLayer, serverConfig Config) {
+	registered := make(map[string]bool)
+	for _, route := range e.Routes() {
+		if name := strings.TrimPrefix(route.Path, "/"); isResourceName(name) && !strings.Contains(name, "/") {
+			registered[name] = true
+		}
+	}
+	names := make([]string, 0, len(registered))
+	for name := range registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		registerExtendedResourceRoutes(name, e, config[name], dal, serverConfig)
+	}
+
+	// History Support
+	history := NewHistoryController(dal)
+	historyHandlers := make([]gin.HandlerFunc, len(config["History"]))
+	copy(historyHandlers, config["History"])
+	historyHandlers = append(historyHandlers, history.Get)
+	e.GET("/_history", historyHandlers...)
+
+	// Operation Support
+	operations := NewOperationController(dal)
+	operations.Config = serverConfig
+	for _, op := range serverOperations(serverConfig) {
+		if !op.IsSystem() {
+			continue
+		}
+		operationHandlers := make([]gin.HandlerFunc, len(config["Operation"]))
+		copy(operationHandlers, config["Operation"])
+		operationHandlers = append(operationHandlers, operations.Handler)
+		e.GET("/$"+op.Definition.Code, operationHandlers...)
+		e.POST("/$"+op.Definition.Code, operationHandlers...)
+	}
+
+	// Conformance Support
+	metadata := NewMetadataController(e, serverConfig)
+	metadataHandlers := make([]gin.HandlerFunc, len(config["Metadata"]))
+	copy(metadataHandlers, config["Metadata"])
+	metadataHandlers = append(metadataHandlers, metadata.Get)
+	e.GET("/metadata", metadataHandlers...)
+}
+
+// registerExtendedResourceRoutes registers the routes (and middleware) for a FHIR resource beyond the generated CRUD
+// routes: PATCH, history, vread, $everything and the resource's extended operations.
+func registerExtendedResourceRoutes(name string, e *gin.Engine, m []gin.HandlerFunc, dal DataAccessLayer, config Config) {
+	rc := NewResourceController(name, dal)
+	rcBase := e.Group("/" + name)
+
+	if len(m) > 0 {
+		rcBase.Use(m...)
+	}
+
+	switch config.Auth.Method {
+	case auth.AuthTypeOIDC, auth.AuthTypeHEART:
+		rcBase.Use(auth.HEARTScopesHandler(name))
+	}
+
+	rcBase.PATCH("", rc.ConditionalPatchHandler)
+
+	rcItem := rcBase.Group("/:id")
+	rcItem.PATCH("", rc.PatchHandler)
+
+	rcItem.GET("/_history", rc.HistoryHandler)
+	rcItem.GET("/_history/:vid", rc.VReadHandler)
+
+	if name == "Patient" {
+		rcItem.GET("/$everything", rc.EverythingHandler)
+	}
+
+	// Type-level operations are dispatched from the read route (GET /:id) and the POST /:id route
+	typeOperations := false
+	for _, op := range serverOperations(config) {
+		typeOperations = typeOperations || op.IsType(name)
+		if op.IsInstance(name) {
+			rcItem.GET("/$"+op.Definition.Code, rc.OperationHandler)
+			rcItem.POST("/$"+op.Definition.Code, rc.OperationHandler)
+		}
+	}
+	if typeOperations {
+		rcItem.POST("", rc.OperationHandler)
+	}
+}
+
+// configHandler returns middleware that sets the server's Config on the request context, so that handlers registered
+// by the generated code can see it.
+func configHandler(config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("Config", config)
+		c.Next()
+	}
+}
+
+// requestConfig returns the server's Config from the request context, or the zero Config if it was not set.
+func requestConfig(c *gin.Context) Config {
+	if config, ok := c.Get("Config"); ok {
+		return config.(Config)
+	}
+	return Config{}
+}
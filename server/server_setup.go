@@ -30,15 +30,15 @@ func NewServer(databaseHost string) *FHIRServer {
 
 	server.Engine.Use(cors.Middleware(cors.Config{
 		Origins:         "*",
-		Methods:         "GET, PUT, POST, DELETE",
-		RequestHeaders:  "Origin, Authorization, Content-Type, If-Match, If-None-Exist",
-		ExposedHeaders:  "Location, ETag, Last-Modified",
+		Methods:         "GET, PUT, POST, PATCH, DELETE",
+		RequestHeaders:  "Origin, Authorization, Content-Type, If-Match, If-None-Exist, Prefer",
+		ExposedHeaders:  "Location, ETag, Last-Modified, Preference-Applied",
 		MaxAge:          86400 * time.Second, // Preflight expires after 1 day
 		Credentials:     true,
 		ValidateHeaders: false,
 	}))
 
-	server.Engine.Use(AbortNonJSONRequests)
+	server.Engine.Use(NegotiateFormat)
 
 	return server
 }
@@ -56,7 +56,7 @@ func (f *FHIRServer) Run(config Config) {
 
 	Database = session.DB(config.DatabaseName)
 
-	RegisterRoutes(f.Engine, f.MiddlewareConfig, NewMongoDataAccessLayer(Database), config)
+	RegisterServerRoutes(f.Engine, f.MiddlewareConfig, NewMongoDataAccessLayerWithIDGenerator(Database, config.IDGenerator), config)
 
 	indexSession := session.Copy()
 	ConfigureIndexes(indexSession, config)
@@ -71,6 +71,8 @@ func (f *FHIRServer) Run(config Config) {
 
 // AbortNonJSONRequests is middleware that responds to any request that Accepts a format
 // other than JSON with a 406 Not Acceptable status.
+//
+// Deprecated: use NegotiateFormat, which also supports XML.
 func AbortNonJSONRequests(c *gin.Context) {
 	acceptHeader := c.Request.Header.Get("Accept")
 	if acceptHeader != "" && !strings.Contains(acceptHeader, "json") && !strings.Contains(acceptHeader, "*/*") {
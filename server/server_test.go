@@ -2,11 +2,14 @@ package server
 
 import (
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -49,8 +52,8 @@ func (s *ServerSuite) SetUpSuite(c *C) {
 
 	// Build routes for testing
 	s.Engine = gin.New()
-	s.Engine.Use(AbortNonJSONRequests)
-	RegisterRoutes(s.Engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.Database), config)
+	s.Engine.Use(NegotiateFormat)
+	RegisterServerRoutes(s.Engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.Database), config)
 
 	// Create httptest server
 	s.Server = httptest.NewServer(s.Engine)
@@ -64,6 +67,7 @@ func (s *ServerSuite) SetUpTest(c *C) {
 
 func (s *ServerSuite) TearDownTest(c *C) {
 	s.Database.C("patients").DropCollection()
+	s.Database.C("history").DropCollection()
 }
 
 func (s *ServerSuite) TearDownSuite(c *C) {
@@ -92,6 +96,63 @@ func (s *ServerSuite) TestGetPatientsWithOptions(c *C) {
 	assertBundleCount(c, s.Server.URL+"/Patient?_offset=100", 0, 5)
 }
 
+func (s *ServerSuite) TestGetPatientsWithSummaryAndElements(c *C) {
+	// Add 4 more patients
+	for i := 0; i < 4; i++ {
+		s.insertPatientFromFixture("../fixtures/patient-example-a.json")
+	}
+
+	// A count summary returns the total, but no resources
+	assertBundleCount(c, s.Server.URL+"/Patient?_summary=count", 0, 5)
+
+	bundle := assertBundleCount(c, s.Server.URL+"/Patient?_elements=gender&_count=2", 2, 5)
+	for _, entry := range bundle.Entry {
+		patient := entry.Resource.(*models.Patient)
+		c.Assert(patient.Id, Not(Equals), "")
+		c.Assert(patient.Gender, Equals, "male")
+		c.Assert(patient.Name, HasLen, 0)
+		c.Assert(patient.Meta.Tag, HasLen, 1)
+		c.Assert(patient.Meta.Tag[0].Code, Equals, "SUBSETTED")
+	}
+	c.Assert(bundle.Link[0].Url, Matches, ".*_elements=gender.*")
+
+	bundle = assertBundleCount(c, s.Server.URL+"/Patient?_summary=data", 5, 5)
+	for _, entry := range bundle.Entry {
+		patient := entry.Resource.(*models.Patient)
+		c.Assert(patient.Text, IsNil)
+		c.Assert(patient.Name, Not(HasLen), 0)
+		c.Assert(patient.Meta.Tag[0].Code, Equals, "SUBSETTED")
+	}
+
+	// The narrative, photo, and contacts aren't summary elements
+	bundle = assertBundleCount(c, s.Server.URL+"/Patient?_summary=true", 5, 5)
+	for _, entry := range bundle.Entry {
+		patient := entry.Resource.(*models.Patient)
+		c.Assert(patient.Text, IsNil)
+		c.Assert(patient.Photo, HasLen, 0)
+		c.Assert(patient.Contact, HasLen, 0)
+		c.Assert(patient.Name, Not(HasLen), 0)
+		c.Assert(patient.Gender, Equals, "male")
+		c.Assert(patient.ManagingOrganization, NotNil)
+		c.Assert(patient.Meta.Tag[0].Code, Equals, "SUBSETTED")
+	}
+	c.Assert(bundle.Link[0].Url, Matches, ".*_summary=true.*")
+}
+
+func (s *ServerSuite) TestGetPatientsFullTextWithoutTextIndex(c *C) {
+	// The patients collection has no text index, so Mongo can't perform the search
+	res, err := http.Get(s.Server.URL + "/Patient?_text=duck")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusBadRequest)
+
+	outcome := &models.OperationOutcome{}
+	err = json.NewDecoder(res.Body).Decode(outcome)
+	util.CheckErr(err)
+	c.Assert(outcome.Issue, HasLen, 1)
+	c.Assert(outcome.Issue[0].Code, Equals, "not-supported")
+	c.Assert(outcome.Issue[0].Diagnostics, Matches, ".*patients collection has no text index.*")
+}
+
 func (s *ServerSuite) TestGetPatientsDefaultLimitIs100(c *C) {
 	// Add 100 more patients
 	for i := 0; i < 100; i++ {
@@ -295,6 +356,51 @@ func (s *ServerSuite) TestCreatePatientByPut(c *C) {
 	s.checkCreatedPatient(createdPatientID, c)
 }
 
+func (s *ServerSuite) TestCreatePatientByPutWithClientAssignedID(c *C) {
+	for _, id := range []string{"example", "patient-123", "6f1c0e52-3b7e-4b1a-9d59-0c3b8f0e2a11", "v1.2"} {
+		data, err := os.Open("../fixtures/patient-example-b.json")
+		util.CheckErr(err)
+
+		req, err := http.NewRequest("PUT", s.Server.URL+"/Patient/"+id, data)
+		util.CheckErr(err)
+		req.Header.Add("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		data.Close()
+
+		c.Assert(res.StatusCode, Equals, 201)
+		s.checkCreatedPatient(id, c)
+		c.Assert(s.getPatient(c, id).Id, Equals, id)
+	}
+
+	// IDs must still be valid FHIR IDs
+	res, err := http.Get(s.Server.URL + "/Patient/not_valid")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 400)
+}
+
+func (s *ServerSuite) TestSequentialIDGenerator(c *C) {
+	defer func() {
+		for _, name := range []string{sequenceCollection, "devices", "practitioners"} {
+			s.Database.C(name).DropCollection()
+		}
+	}()
+	dal := NewMongoDataAccessLayerWithIDGenerator(s.Database, &SequentialIDGenerator{})
+
+	// A client has already taken the first ID, so it is skipped
+	util.CheckErr(dal.PostWithID("1", &models.Device{}))
+	for _, expected := range []string{"2", "3"} {
+		id, err := dal.Post(&models.Device{})
+		util.CheckErr(err)
+		c.Assert(id, Equals, expected)
+	}
+	id, err := dal.Post(&models.Practitioner{})
+	util.CheckErr(err)
+	c.Assert(id, Equals, "1")
+
+	c.Assert(dal.PostWithID("2", &models.Device{}), Equals, ErrConflict)
+}
+
 func (s *ServerSuite) checkCreatedPatient(createdPatientID string, c *C) {
 	patientCollection := s.Database.C("patients")
 	patient := models.Patient{}
@@ -338,6 +444,66 @@ func (s *ServerSuite) TestGetConditionsWithIncludes(c *C) {
 	c.Assert(b.Entry[1].Search.Mode, Equals, "include")
 }
 
+func (s *ServerSuite) TestGetMedicationOrdersWithIteratedIncludes(c *C) {
+	for collection, resource := range map[string]string{
+		"organizations":    `{"resourceType": "Organization", "id": "org1", "name": "Acme", "partOf": {"reference": "Organization/org2"}}`,
+		"practitioners":    `{"resourceType": "Practitioner", "id": "pr1", "practitionerRole": [{"managingOrganization": {"reference": "Organization/org1"}}]}`,
+		"medicationorders": `{"resourceType": "MedicationOrder", "id": "mo1", "prescriber": {"reference": "Practitioner/pr1"}, "patient": {"reference": "Patient/` + s.FixtureID + `"}}`,
+	} {
+		util.CheckErr(s.Database.C(collection).Insert(models.MapToResource(decode(resource), true)))
+		defer s.Database.C(collection).DropCollection()
+	}
+	util.CheckErr(s.Database.C("organizations").Insert(models.MapToResource(decode(`{"resourceType": "Organization", "id": "org2", "name": "Acme Holdings"}`), true)))
+
+	includedIDs := func(b *models.Bundle) map[string]bool {
+		ids := make(map[string]bool)
+		for _, entry := range b.Entry[1:] {
+			c.Assert(entry.Search.Mode, Equals, "include")
+			ids[reflect.ValueOf(entry.Resource).Elem().FieldByName("Id").String()] = true
+		}
+		return ids
+	}
+
+	b := assertBundleCount(c, s.Server.URL+"/MedicationOrder?_include=MedicationOrder:prescriber", 2, 1)
+	c.Assert(includedIDs(b), DeepEquals, map[string]bool{"pr1": true})
+
+	b = assertBundleCount(c, s.Server.URL+"/MedicationOrder?_include=MedicationOrder:prescriber&_include:recurse=Practitioner:organization", 3, 1)
+	c.Assert(includedIDs(b), DeepEquals, map[string]bool{"pr1": true, "org1": true})
+
+	b = assertBundleCount(c, s.Server.URL+"/MedicationOrder?_include=MedicationOrder:prescriber&_include:iterate=Practitioner:organization&_include:iterate=Organization:partof", 4, 1)
+	c.Assert(includedIDs(b), DeepEquals, map[string]bool{"pr1": true, "org1": true, "org2": true})
+
+	b = assertBundleCount(c, s.Server.URL+"/MedicationOrder?_include=*", 3, 1)
+	c.Assert(includedIDs(b), DeepEquals, map[string]bool{"pr1": true, s.FixtureID: true})
+
+	b = assertBundleCount(c, s.Server.URL+"/MedicationOrder?_include=*&_include:iterate=*", 5, 1)
+	c.Assert(includedIDs(b), DeepEquals, map[string]bool{"pr1": true, s.FixtureID: true, "org1": true, "org2": true})
+
+	b = assertBundleCount(c, s.Server.URL+"/MedicationOrder?_include:iterate=*", 5, 1)
+	c.Assert(includedIDs(b), DeepEquals, map[string]bool{"pr1": true, s.FixtureID: true, "org1": true, "org2": true})
+
+	b = assertBundleCount(c, s.Server.URL+"/MedicationOrder?_include:recurse=MedicationOrder:prescriber", 2, 1)
+	c.Assert(includedIDs(b), DeepEquals, map[string]bool{"pr1": true})
+}
+
+func (s *ServerSuite) TestGetIteratedIncludesOfDifferentTypesWithTheSameID(c *C) {
+	for collection, resource := range map[string]string{
+		"organizations":    `{"resourceType": "Organization", "id": "pr1", "name": "Acme"}`,
+		"practitioners":    `{"resourceType": "Practitioner", "id": "pr1", "practitionerRole": [{"managingOrganization": {"reference": "Organization/pr1"}}]}`,
+		"medicationorders": `{"resourceType": "MedicationOrder", "id": "mo1", "prescriber": {"reference": "Practitioner/pr1"}}`,
+	} {
+		util.CheckErr(s.Database.C(collection).Insert(models.MapToResource(decode(resource), true)))
+		defer s.Database.C(collection).DropCollection()
+	}
+
+	b := assertBundleCount(c, s.Server.URL+"/MedicationOrder?_include=MedicationOrder:prescriber&_include:iterate=Practitioner:organization", 3, 1)
+	included := make(map[string]bool)
+	for _, entry := range b.Entry[1:] {
+		included[resourceKey(entry.Resource)] = true
+	}
+	c.Assert(included, DeepEquals, map[string]bool{"Practitioner/pr1": true, "Organization/pr1": true})
+}
+
 func (s *ServerSuite) TestWrongResource(c *C) {
 	data, err := os.Open("../fixtures/patient-wrong-type.json")
 	util.CheckErr(err)
@@ -515,12 +681,669 @@ func (s *ServerSuite) TestConditionalDelete(c *C) {
 	c.Assert(count, Equals, 8)
 }
 
-func (s *ServerSuite) TestRejectXML(c *C) {
+func (s *ServerSuite) TestUpdatePatientIncrementsVersion(c *C) {
+	createdPatientID := s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+	patient := s.getPatient(c, createdPatientID)
+	c.Assert(patient.Meta.VersionId, Equals, "1")
+
+	s.updatePatientFromFixture(c, createdPatientID, "../fixtures/patient-example-c.json")
+	patient = s.getPatient(c, createdPatientID)
+	c.Assert(patient.Meta.VersionId, Equals, "2")
+	c.Assert(patient.Name[0].Given[0], Equals, "Donny")
+}
+
+func (s *ServerSuite) TestVReadPatient(c *C) {
+	createdPatientID := s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+	s.updatePatientFromFixture(c, createdPatientID, "../fixtures/patient-example-c.json")
+
+	res, err := http.Get(s.Server.URL + "/Patient/" + createdPatientID + "/_history/1")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	patient := &models.Patient{}
+	err = json.NewDecoder(res.Body).Decode(patient)
+	util.CheckErr(err)
+	c.Assert(patient.Meta.VersionId, Equals, "1")
+	c.Assert(patient.Name[0].Given[0], Equals, "Don")
+
+	res, err = http.Get(s.Server.URL + "/Patient/" + createdPatientID + "/_history/2")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	patient = &models.Patient{}
+	err = json.NewDecoder(res.Body).Decode(patient)
+	util.CheckErr(err)
+	c.Assert(patient.Meta.VersionId, Equals, "2")
+	c.Assert(patient.Name[0].Given[0], Equals, "Donny")
+
+	res, err = http.Get(s.Server.URL + "/Patient/" + createdPatientID + "/_history/3")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+}
+
+func (s *ServerSuite) TestDeletedPatientIsGone(c *C) {
+	createdPatientID := s.createPatientFromFixture(c, "../fixtures/patient-example-d.json")
+
+	req, err := http.NewRequest("DELETE", s.Server.URL+"/Patient/"+createdPatientID, nil)
+	util.CheckErr(err)
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusNoContent)
+
+	res, err = http.Get(s.Server.URL + "/Patient/" + createdPatientID)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusGone)
+
+	res, err = http.Get(s.Server.URL + "/Patient/" + createdPatientID + "/_history/2")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusGone)
+
+	res, err = http.Get(s.Server.URL + "/Patient/" + createdPatientID + "/_history/1")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+}
+
+func (s *ServerSuite) TestRecreatingDeletedPatientContinuesVersions(c *C) {
+	createdPatientID := s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+	req, err := http.NewRequest("DELETE", s.Server.URL+"/Patient/"+createdPatientID, nil)
+	util.CheckErr(err)
+	_, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+
+	data, err := os.Open("../fixtures/patient-example-c.json")
+	util.CheckErr(err)
+	defer data.Close()
+	req, err = http.NewRequest("PUT", s.Server.URL+"/Patient/"+createdPatientID, data)
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusCreated)
+	c.Assert(s.getPatient(c, createdPatientID).Meta.VersionId, Equals, "3")
+
+	bundle := assertBundleCount(c, s.Server.URL+"/Patient/"+createdPatientID+"/_history", 3, 3)
+	c.Assert(bundle.Entry[0].Resource.(*models.Patient).Meta.VersionId, Equals, "3")
+	c.Assert(bundle.Entry[1].Request.Method, Equals, "DELETE")
+	c.Assert(bundle.Entry[2].Resource.(*models.Patient).Meta.VersionId, Equals, "1")
+}
+
+func (s *ServerSuite) TestInstanceHistory(c *C) {
+	createdPatientID := s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+	s.updatePatientFromFixture(c, createdPatientID, "../fixtures/patient-example-c.json")
+	req, err := http.NewRequest("DELETE", s.Server.URL+"/Patient/"+createdPatientID, nil)
+	util.CheckErr(err)
+	_, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+
+	bundle := assertBundleCount(c, s.Server.URL+"/Patient/"+createdPatientID+"/_history", 3, 3)
+	c.Assert(bundle.Type, Equals, "history")
+	c.Assert(bundle.Entry[0].Request.Method, Equals, "DELETE")
+	c.Assert(bundle.Entry[0].Resource, IsNil)
+	c.Assert(bundle.Entry[1].Request.Method, Equals, "PUT")
+	c.Assert(bundle.Entry[1].Resource.(*models.Patient).Meta.VersionId, Equals, "2")
+	c.Assert(bundle.Entry[2].Request.Method, Equals, "POST")
+	c.Assert(bundle.Entry[2].Resource.(*models.Patient).Meta.VersionId, Equals, "1")
+	c.Assert(bundle.Entry[2].FullUrl, Equals, s.Server.URL+"/Patient/"+createdPatientID)
+}
+
+func (s *ServerSuite) TestTypeAndSystemHistoryPaging(c *C) {
+	for i := 0; i < 5; i++ {
+		s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+	}
+
+	bundle := assertBundleCount(c, s.Server.URL+"/Patient/_history?_count=2", 2, 5)
+	c.Assert(bundle.Link, HasLen, 4)
+	assertPagingLink(c, bundle.Link[0], "self", 2, 0)
+	assertPagingLink(c, bundle.Link[1], "first", 2, 0)
+	assertPagingLink(c, bundle.Link[2], "next", 2, 2)
+	assertPagingLink(c, bundle.Link[3], "last", 2, 4)
+
+	assertBundleCount(c, s.Server.URL+"/_history?_count=2&_offset=4", 1, 5)
+	assertBundleCount(c, s.Server.URL+"/_history?_since=2100-01-01T00:00:00Z", 0, 0)
+
+	res, err := http.Get(s.Server.URL + "/_history?_since=yesterday")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusBadRequest)
+}
+
+func (s *ServerSuite) TestPatientEverything(c *C) {
+	collections := []string{"encounters", "conditions", "observations", "practitioners"}
+	defer func() {
+		for _, name := range collections {
+			s.Database.C(name).DropCollection()
+		}
+	}()
+
+	dal := NewMongoDataAccessLayer(s.Database)
+	patientID := s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+	patientRef := &models.Reference{Reference: "Patient/" + patientID, ReferencedID: patientID, Type: "Patient", External: new(bool)}
+	practitionerID := bson.NewObjectId().Hex()
+	util.CheckErr(dal.PostWithID(practitionerID, &models.Practitioner{Active: new(bool)}))
+	util.CheckErr(dal.PostWithID(bson.NewObjectId().Hex(), &models.Practitioner{}))
+	util.CheckErr(dal.PostWithID(bson.NewObjectId().Hex(), &models.Encounter{
+		Status:  "finished",
+		Patient: patientRef,
+		Period: &models.Period{
+			Start: &models.FHIRDateTime{Time: time.Date(2015, 3, 1, 0, 0, 0, 0, time.UTC), Precision: models.Date},
+			End:   &models.FHIRDateTime{Time: time.Date(2015, 3, 2, 0, 0, 0, 0, time.UTC), Precision: models.Date},
+		},
+	}))
+	util.CheckErr(dal.PostWithID(bson.NewObjectId().Hex(), &models.Condition{VerificationStatus: "confirmed", Patient: patientRef}))
+	util.CheckErr(dal.PostWithID(bson.NewObjectId().Hex(), &models.Observation{
+		Status:            "final",
+		Subject:           patientRef,
+		EffectiveDateTime: &models.FHIRDateTime{Time: time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC), Precision: models.Date},
+		Performer:         []models.Reference{{Reference: "Practitioner/" + practitionerID, ReferencedID: practitionerID, Type: "Practitioner", External: new(bool)}},
+	}))
+	// This one belongs to someone else
+	util.CheckErr(dal.PostWithID(bson.NewObjectId().Hex(), &models.Condition{
+		VerificationStatus: "confirmed",
+		Patient:            &models.Reference{Reference: "Patient/" + s.FixtureID, ReferencedID: s.FixtureID, Type: "Patient", External: new(bool)},
+	}))
+
+	everythingURL := s.Server.URL + "/Patient/" + patientID + "/$everything"
+	bundle := assertBundleCount(c, everythingURL, 5, 5)
+	c.Assert(bundle.Type, Equals, "searchset")
+	c.Assert(bundle.Entry[0].Resource.(*models.Patient).Id, Equals, patientID)
+	c.Assert(bundle.Entry[4].Search.Mode, Equals, "include")
+	c.Assert(bundle.Entry[4].Resource.(*models.Practitioner).Id, Equals, practitionerID)
+
+	// Paging
+	bundle = assertBundleCount(c, everythingURL+"?_count=3&_offset=3", 2, 5)
+	c.Assert(bundle.Entry[1].Resource.(*models.Practitioner).Id, Equals, practitionerID)
+	assertPagingLink(c, bundle.Link[0], "self", 3, 3)
+	bundle = assertBundleCount(c, everythingURL+"?_count=2&_offset=1", 2, 5)
+	c.Assert(bundle.Entry[0].Resource, FitsTypeOf, &models.Condition{})
+	c.Assert(bundle.Entry[1].Resource, FitsTypeOf, &models.Encounter{})
+	bundle = assertBundleCount(c, everythingURL+"?_count=2&_offset=2", 2, 5)
+	c.Assert(bundle.Entry[0].Resource, FitsTypeOf, &models.Encounter{})
+	c.Assert(bundle.Entry[1].Resource, FitsTypeOf, &models.Observation{})
+	assertBundleCount(c, everythingURL+"?_count=0", 0, 5)
+
+	// Date filters only apply to resources with care dates
+	assertBundleCount(c, everythingURL+"?start=2016-01-01", 4, 4)
+	assertBundleCount(c, everythingURL+"?end=2015-12-31", 3, 3)
+	assertBundleCount(c, everythingURL+"?_since=2100-01-01T00:00:00Z", 0, 0)
+
+	// Type level includes all patients
+	assertBundleCount(c, s.Server.URL+"/Patient/$everything", 7, 7)
+
+	res, err := http.Get(everythingURL + "?start=yesterday")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusBadRequest)
+
+	res, err = http.Get(s.Server.URL + "/Patient/" + bson.NewObjectId().Hex() + "/$everything")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+}
+
+func (s *ServerSuite) TestReferencePaths(c *C) {
+	paths := referencePaths(reflect.TypeOf(models.Encounter{}), "", make(map[reflect.Type]bool))
+	c.Assert(paths, Not(HasLen), 0)
+	found := make(map[string]bool)
+	for _, path := range paths {
+		found[path] = true
+	}
+	for _, path := range []string{"patient", "participant.individual", "hospitalization.origin", "extension", "participant.modifierExtension"} {
+		c.Assert(found[path], Equals, true, Commentf("%s", path))
+	}
+
+	// Recursive elements are loaded whole
+	paths = referencePaths(reflect.TypeOf(models.Questionnaire{}), "", make(map[reflect.Type]bool))
+	c.Assert(paths, Not(HasLen), 0)
+	found = make(map[string]bool)
+	for _, path := range paths {
+		found[path] = true
+	}
+	c.Assert(found["group.group"], Equals, true)
+	c.Assert(found["group.question.options"], Equals, true)
+}
+
+func (s *ServerSuite) TestETagHeader(c *C) {
+	data, err := os.Open("../fixtures/patient-example-b.json")
+	util.CheckErr(err)
+	defer data.Close()
+
+	res, err := http.Post(s.Server.URL+"/Patient", "application/json", data)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusCreated)
+	c.Assert(res.Header.Get("ETag"), Equals, "W/\"1\"")
+
+	splitLocation := strings.Split(res.Header.Get("Location"), "/")
+	createdPatientID := splitLocation[len(splitLocation)-1]
+	s.updatePatientFromFixture(c, createdPatientID, "../fixtures/patient-example-c.json")
+
+	res, err = http.Get(s.Server.URL + "/Patient/" + createdPatientID)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("ETag"), Equals, "W/\"2\"")
+
+	res, err = http.Get(s.Server.URL + "/Patient/" + createdPatientID + "/_history/1")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("ETag"), Equals, "W/\"1\"")
+}
+
+func (s *ServerSuite) TestUpdatePatientIfMatch(c *C) {
+	createdPatientID := s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+
+	data, err := os.Open("../fixtures/patient-example-c.json")
+	util.CheckErr(err)
+	defer data.Close()
+	req, err := http.NewRequest("PUT", s.Server.URL+"/Patient/"+createdPatientID, data)
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("If-Match", "W/\"1\"")
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("ETag"), Equals, "W/\"2\"")
+
+	// The same update again is now stale
+	data2, err := os.Open("../fixtures/patient-example-c.json")
+	util.CheckErr(err)
+	defer data2.Close()
+	req, err = http.NewRequest("PUT", s.Server.URL+"/Patient/"+createdPatientID, data2)
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("If-Match", "W/\"1\"")
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusPreconditionFailed)
+
+	patient := s.getPatient(c, createdPatientID)
+	c.Assert(patient.Meta.VersionId, Equals, "2")
+}
+
+func (s *ServerSuite) TestUpdatePatientIfMatchAnyVersion(c *C) {
+	createdPatientID := s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+	s.updatePatientFromFixture(c, createdPatientID, "../fixtures/patient-example-b.json")
+
+	data, err := os.Open("../fixtures/patient-example-c.json")
+	util.CheckErr(err)
+	defer data.Close()
+	req, err := http.NewRequest("PUT", s.Server.URL+"/Patient/"+createdPatientID, data)
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("If-Match", "*")
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("ETag"), Equals, "W/\"3\"")
+
+	// The resource must still exist, though
+	data2, err := os.Open("../fixtures/patient-example-c.json")
+	util.CheckErr(err)
+	defer data2.Close()
+	req, err = http.NewRequest("PUT", s.Server.URL+"/Patient/"+bson.NewObjectId().Hex(), data2)
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("If-Match", "*")
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusPreconditionFailed)
+}
+
+func (s *ServerSuite) TestDeletePatientIfMatch(c *C) {
+	createdPatientID := s.createPatientFromFixture(c, "../fixtures/patient-example-d.json")
+	s.updatePatientFromFixture(c, createdPatientID, "../fixtures/patient-example-d.json")
+
+	req, err := http.NewRequest("DELETE", s.Server.URL+"/Patient/"+createdPatientID, nil)
+	util.CheckErr(err)
+	req.Header.Add("If-Match", "W/\"1\"")
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusPreconditionFailed)
+
+	count, err := s.Database.C("patients").FindId(createdPatientID).Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 1)
+
+	req, err = http.NewRequest("DELETE", s.Server.URL+"/Patient/"+createdPatientID, nil)
+	util.CheckErr(err)
+	req.Header.Add("If-Match", "W/\"2\"")
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusNoContent)
+
+	// Once it's gone, even "any version" doesn't match
+	req, err = http.NewRequest("DELETE", s.Server.URL+"/Patient/"+createdPatientID, nil)
+	util.CheckErr(err)
+	req.Header.Add("If-Match", "*")
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusPreconditionFailed)
+}
+
+func (s *ServerSuite) TestConditionalCreatePatient(c *C) {
+	createdPatientID := s.conditionalCreatePatient(c, "identifier=urn:oid:0.1.2.3.4.5.6.7|654321", http.StatusCreated)
+	count, err := s.Database.C("patients").Find(bson.M{"_id": createdPatientID}).Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 1)
+
+	// Creating it again should return the existing patient rather than a duplicate
+	existingPatientID := s.conditionalCreatePatient(c, "identifier=urn:oid:0.1.2.3.4.5.6.7|654321", http.StatusOK)
+	c.Assert(existingPatientID, Equals, createdPatientID)
+	count, err = s.Database.C("patients").Find(bson.M{"identifier.value": "654321"}).Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 1)
+}
+
+func (s *ServerSuite) TestConditionalCreatePatientMultipleMatches(c *C) {
+	s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+	s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+
+	data, err := os.Open("../fixtures/patient-example-b.json")
+	util.CheckErr(err)
+	defer data.Close()
+
+	req, err := http.NewRequest("POST", s.Server.URL+"/Patient", data)
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("If-None-Exist", "identifier=654321")
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusPreconditionFailed)
+}
+
+func (s *ServerSuite) TestConditionalCreatePatientInvalidCriteria(c *C) {
+	data, err := os.Open("../fixtures/patient-example-b.json")
+	util.CheckErr(err)
+	defer data.Close()
+
+	req, err := http.NewRequest("POST", s.Server.URL+"/Patient", data)
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("If-None-Exist", "foo=bar")
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusBadRequest)
+
+	outcome := &models.OperationOutcome{}
+	err = json.NewDecoder(res.Body).Decode(outcome)
+	util.CheckErr(err)
+	c.Assert(outcome.Issue, HasLen, 1)
+
+	count, err := s.Database.C("patients").Find(bson.M{"identifier.value": "654321"}).Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 0)
+}
+
+func (s *ServerSuite) conditionalCreatePatient(c *C, ifNoneExist string, expectedStatus int) string {
+	data, err := os.Open("../fixtures/patient-example-b.json")
+	util.CheckErr(err)
+	defer data.Close()
+
+	req, err := http.NewRequest("POST", s.Server.URL+"/Patient", data)
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("If-None-Exist", ifNoneExist)
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, expectedStatus)
+
+	patient := &models.Patient{}
+	err = json.NewDecoder(res.Body).Decode(patient)
+	util.CheckErr(err)
+	c.Assert(patient.Id, Not(Equals), "")
+	c.Assert(res.Header.Get("Location"), Equals, s.Server.URL+"/Patient/"+patient.Id)
+	return patient.Id
+}
+
+func (s *ServerSuite) TestMetadata(c *C) {
+	search.GlobalRegistry().RegisterParameterInfo(search.SearchParamInfo{
+		Resource: "Patient",
+		Name:     "favorite-color",
+		Type:     "string",
+		Paths:    []search.SearchParamPath{{Path: "extension.valueString", Type: "string"}},
+	})
+
+	res, err := http.Get(s.Server.URL + "/metadata")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+
+	conformance := &models.Conformance{}
+	err = json.NewDecoder(res.Body).Decode(conformance)
+	util.CheckErr(err)
+	c.Assert(conformance.Kind, Equals, "instance")
+	c.Assert(conformance.Rest, HasLen, 1)
+	rest := conformance.Rest[0]
+	c.Assert(rest.Mode, Equals, "server")
+	c.Assert(*rest.Security.Cors, Equals, true)
+	c.Assert(rest.Interaction, HasLen, 2)
+	c.Assert(rest.Interaction[0].Code, Equals, "transaction")
+	c.Assert(rest.Interaction[1].Code, Equals, "history-system")
+
+	var patient *models.ConformanceRestResourceComponent
+	for i := range rest.Resource {
+		if rest.Resource[i].Type == "Patient" {
+			patient = &rest.Resource[i]
+		}
+	}
+	c.Assert(patient, NotNil)
+
+	codes := make([]string, len(patient.Interaction))
+	for i := range patient.Interaction {
+		codes[i] = patient.Interaction[i].Code
+	}
+	c.Assert(codes, DeepEquals, []string{"read", "vread", "update", "delete", "history-instance", "history-type",
+		"create", "search-type"})
+	c.Assert(patient.Versioning, Equals, "versioned-update")
+	c.Assert(*patient.ConditionalCreate, Equals, true)
+	c.Assert(*patient.ConditionalUpdate, Equals, true)
+	c.Assert(patient.ConditionalDelete, Equals, "multiple")
+	c.Assert(patient.SearchInclude, Not(HasLen), 0)
+
+	params := make(map[string]string)
+	for _, param := range patient.SearchParam {
+		params[param.Name] = param.Type
+	}
+	c.Assert(params["name"], Equals, "string")
+	c.Assert(params["birthdate"], Equals, "date")
+	c.Assert(params["favorite-color"], Equals, "string")
+}
+
+func (s *ServerSuite) TestConformanceResourceWithoutInstanceHistory(c *C) {
+	// Type-level history is served by the read route, so it doesn't depend on the instance history route
+	resource := conformanceResource("Patient", map[string]bool{"GET /:id": true, "GET ": true})
+	codes := make([]string, len(resource.Interaction))
+	for i := range resource.Interaction {
+		codes[i] = resource.Interaction[i].Code
+	}
+	c.Assert(codes, DeepEquals, []string{"read", "history-type", "search-type"})
+}
+
+func (s *ServerSuite) TestPatchPatient(c *C) {
+	createdPatientID := s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+
+	patch := `[{"op": "replace", "path": "/gender", "value": "female"}]`
+	res := s.patchPatient(c, "/Patient/"+createdPatientID, "application/json-patch+json", patch, "W/\"1\"")
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("ETag"), Equals, "W/\"2\"")
+	patched := &models.Patient{}
+	err := json.NewDecoder(res.Body).Decode(patched)
+	util.CheckErr(err)
+	c.Assert(patched.Gender, Equals, "female")
+
+	// The same patch again is now stale
+	res = s.patchPatient(c, "/Patient/"+createdPatientID, "application/json-patch+json", patch, "W/\"1\"")
+	c.Assert(res.StatusCode, Equals, http.StatusPreconditionFailed)
+
+	patient := s.getPatient(c, createdPatientID)
+	c.Assert(patient.Gender, Equals, "female")
+	c.Assert(patient.Meta.VersionId, Equals, "2")
+	c.Assert(patient.Identifier, HasLen, 1)
+}
+
+func (s *ServerSuite) TestPatchPatientFHIRPath(c *C) {
+	createdPatientID := s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+
+	patch := `{
+		"resourceType": "Parameters",
+		"parameter": [{"name": "operation", "part": [
+			{"name": "type", "valueCode": "replace"},
+			{"name": "path", "valueString": "Patient.gender"},
+			{"name": "value", "valueCode": "female"}
+		]}]
+	}`
+	res := s.patchPatient(c, "/Patient/"+createdPatientID, "application/json+fhir", patch, "")
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+
+	patient := s.getPatient(c, createdPatientID)
+	c.Assert(patient.Gender, Equals, "female")
+}
+
+func (s *ServerSuite) TestConditionalPatchPatient(c *C) {
+	createdPatientID := s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+
+	patch := `[{"op": "replace", "path": "/gender", "value": "female"}]`
+	res := s.patchPatient(c, "/Patient?identifier=654321", "application/json-patch+json", patch, "")
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Location"), Equals, s.Server.URL+"/Patient/"+createdPatientID)
+	c.Assert(s.getPatient(c, createdPatientID).Gender, Equals, "female")
+
+	res = s.patchPatient(c, "/Patient?identifier=123456", "application/json-patch+json", patch, "")
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+}
+
+func (s *ServerSuite) TestPatchPatientInvalid(c *C) {
+	createdPatientID := s.createPatientFromFixture(c, "../fixtures/patient-example-b.json")
+
+	res := s.patchPatient(c, "/Patient/"+createdPatientID, "application/json-patch+json", `[{"op": "add", "path": "/color", "value": "blue"}]`, "")
+	c.Assert(res.StatusCode, Equals, http.StatusUnprocessableEntity)
+	outcome := &models.OperationOutcome{}
+	err := json.NewDecoder(res.Body).Decode(outcome)
+	util.CheckErr(err)
+	c.Assert(outcome.Issue[0].Diagnostics, Equals, "Unknown element color in Patient")
+
+	res = s.patchPatient(c, "/Patient/"+createdPatientID, "application/json-patch+json", `{"op": "add"}`, "")
+	c.Assert(res.StatusCode, Equals, http.StatusBadRequest)
+
+	res = s.patchPatient(c, "/Patient/"+bson.NewObjectId().Hex(), "application/json-patch+json", `[]`, "")
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+
+	c.Assert(s.getPatient(c, createdPatientID).Meta.VersionId, Equals, "1")
+}
+
+func (s *ServerSuite) patchPatient(c *C, path, contentType, patch, ifMatch string) *http.Response {
+	req, err := http.NewRequest("PATCH", s.Server.URL+path, strings.NewReader(patch))
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", contentType)
+	if ifMatch != "" {
+		req.Header.Add("If-Match", ifMatch)
+	}
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	return res
+}
+
+func (s *ServerSuite) createPatientFromFixture(c *C, fileName string) string {
+	data, err := os.Open(fileName)
+	util.CheckErr(err)
+	defer data.Close()
+
+	res, err := http.Post(s.Server.URL+"/Patient", "application/json", data)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusCreated)
+
+	splitLocation := strings.Split(res.Header.Get("Location"), "/")
+	return splitLocation[len(splitLocation)-1]
+}
+
+func (s *ServerSuite) updatePatientFromFixture(c *C, id string, fileName string) {
+	data, err := os.Open(fileName)
+	util.CheckErr(err)
+	defer data.Close()
+
+	req, err := http.NewRequest("PUT", s.Server.URL+"/Patient/"+id, data)
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+}
+
+func (s *ServerSuite) getPatient(c *C, id string) *models.Patient {
+	patient := &models.Patient{}
+	err := s.Database.C("patients").FindId(id).One(patient)
+	util.CheckErr(err)
+	return patient
+}
+
+func (s *ServerSuite) TestRejectUnsupportedFormat(c *C) {
 	req, err := http.NewRequest("GET", s.Server.URL+"/Patient", nil)
 	util.CheckErr(err)
-	req.Header.Add("Accept", "application/xml")
+	req.Header.Add("Accept", "text/turtle")
 	resp, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
 	c.Assert(resp.StatusCode, Equals, http.StatusNotAcceptable)
+
+	resp, err = http.Get(s.Server.URL + "/Patient?_format=text/turtle")
+	util.CheckErr(err)
+	c.Assert(resp.StatusCode, Equals, http.StatusNotAcceptable)
+}
+
+func (s *ServerSuite) TestShowPatientXML(c *C) {
+	req, err := http.NewRequest("GET", s.Server.URL+"/Patient/"+s.FixtureID, nil)
+	util.CheckErr(err)
+	req.Header.Add("Accept", "application/xml+fhir")
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Content-Type"), Equals, "application/xml+fhir; charset=utf-8")
+
+	decoder := xml.NewDecoder(res.Body)
+	var patient struct {
+		XMLName xml.Name
+		ID      struct {
+			Value string `xml:"value,attr"`
+		} `xml:"id"`
+	}
+	err = decoder.Decode(&patient)
+	util.CheckErr(err)
+	c.Assert(patient.XMLName.Space, Equals, "http://hl7.org/fhir")
+	c.Assert(patient.XMLName.Local, Equals, "Patient")
+	c.Assert(patient.ID.Value, Equals, s.FixtureID)
+
+	// The _format parameter should override the Accept header
+	res, err = http.Get(s.Server.URL + "/Patient/" + s.FixtureID + "?_format=xml")
+	util.CheckErr(err)
+	c.Assert(res.Header.Get("Content-Type"), Equals, "application/xml+fhir; charset=utf-8")
+}
+
+func (s *ServerSuite) TestSearchPatientXML(c *C) {
+	res, err := http.Get(s.Server.URL + "/Patient?_format=application/xml+fhir")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	data, err := ioutil.ReadAll(res.Body)
+	util.CheckErr(err)
+
+	jsonData, err := ConvertXMLToJSON(data)
+	util.CheckErr(err)
+	bundle := &models.Bundle{}
+	err = json.Unmarshal(jsonData, bundle)
+	util.CheckErr(err)
+	c.Assert(bundle.Type, Equals, "searchset")
+	c.Assert(bundle.Entry, HasLen, 1)
+	patient, ok := bundle.Entry[0].Resource.(*models.Patient)
+	c.Assert(ok, Equals, true)
+	c.Assert(patient.Id, Equals, s.FixtureID)
+}
+
+func (s *ServerSuite) TestCreateConditionXML(c *C) {
+	data, err := os.Open("../fixtures/condition.xml")
+	util.CheckErr(err)
+	defer data.Close()
+
+	res, err := http.Post(s.Server.URL+"/Condition", "application/xml+fhir", data)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, http.StatusCreated)
+	c.Assert(res.Header.Get("Content-Type"), Equals, "application/json; charset=utf-8")
+
+	condition := &models.Condition{}
+	err = json.NewDecoder(res.Body).Decode(condition)
+	util.CheckErr(err)
+	c.Assert(condition.Code.Text, Equals, "Heart failure")
+	c.Assert(condition.Code.Coding, HasLen, 3)
+	s.Database.C("conditions").DropCollection()
 }
 
 func performSearch(c *C, url string) *models.Bundle {
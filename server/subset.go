@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+
+	"github.com/intervention-engine/fhir/models"
+	"github.com/intervention-engine/fhir/search"
+)
+
+// The tag marking resources from which some elements were left out because of the _summary or _elements parameters
+const (
+	subsettedSystem = "http://hl7.org/fhir/v3/ObservationValue"
+	subsettedCode   = "SUBSETTED"
+)
+
+// markSubsetted adds the SUBSETTED tag to the resource's meta, unless it is already there.
+func markSubsetted(resource interface{}) {
+	m := reflect.ValueOf(resource).Elem().FieldByName("Meta")
+	if m.IsNil() {
+		m.Set(reflect.ValueOf(&models.Meta{}))
+	}
+	meta := m.Interface().(*models.Meta)
+	for _, tag := range meta.Tag {
+		if tag.System == subsettedSystem && tag.Code == subsettedCode {
+			return
+		}
+	}
+	meta.Tag = append(meta.Tag, models.Coding{System: subsettedSystem, Code: subsettedCode, Display: "subsetted"})
+}
+
+// readOptions returns the query options for the _summary and _elements parameters of a read, ignoring any other
+// parameters.  If the parameters are invalid, the *search.Error describing the problem is returned.
+func readOptions(resourceType string, values url.Values) (options *search.QueryOptions, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			searchErr, ok := r.(*search.Error)
+			if !ok {
+				panic(r)
+			}
+			err = searchErr
+		}
+	}()
+
+	subsetValues := url.Values{}
+	for _, param := range []string{search.SummaryParam, search.ElementsParam} {
+		if value, ok := values[param]; ok {
+			subsetValues[param] = value
+		}
+	}
+	query := search.Query{Resource: resourceType, Query: subsetValues.Encode()}
+	return query.Options(), nil
+}
+
+// subsetResource returns a copy of the resource containing only the elements selected by the _summary and _elements
+// options, tagged as SUBSETTED.  If the options don't call for a subset, the resource itself is returned.
+func subsetResource(resource interface{}, options *search.QueryOptions) (interface{}, error) {
+	if !options.IsSubsetted() {
+		return resource, nil
+	}
+	b, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err = json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	resourceType, _ := doc["resourceType"].(string)
+	elements, exclude := options.SelectedElements(resourceType)
+	selected := make(map[string]bool)
+	for _, element := range elements {
+		selected[element] = true
+	}
+	for element := range doc {
+		if selected[element] == exclude {
+			delete(doc, element)
+		}
+	}
+
+	subset := models.MapToResource(doc, true)
+	markSubsetted(subset)
+	return subset, nil
+}
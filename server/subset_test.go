@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type SubsetSuite struct {
+	Engine *gin.Engine
+}
+
+var _ = Suite(&SubsetSuite{})
+
+// readOnlyDAL serves a single patient
+type readOnlyDAL struct {
+	DataAccessLayer
+}
+
+func (d *readOnlyDAL) Get(id, resourceType string) (interface{}, error) {
+	if id != "123" {
+		return nil, ErrNotFound
+	}
+	return models.MapToResource(decode(`{
+		"resourceType": "Patient",
+		"id": "123",
+		"meta": {"versionId": "1"},
+		"text": {"status": "generated", "div": "<div>John Smith</div>"},
+		"name": [{"family": ["Smith"], "given": ["John"]}],
+		"gender": "male",
+		"birthDate": "1970-01-01",
+		"photo": [{"contentType": "image/png", "data": "iVBORw0KGgo="}]
+	}`), true), nil
+}
+
+func (s *SubsetSuite) SetUpSuite(c *C) {
+	gin.SetMode(gin.ReleaseMode)
+	s.Engine = gin.New()
+	s.Engine.Use(NegotiateFormat)
+	RegisterServerRoutes(s.Engine, make(map[string][]gin.HandlerFunc), &readOnlyDAL{}, DefaultConfig)
+}
+
+func (s *SubsetSuite) read(path string) (int, map[string]interface{}) {
+	req, err := http.NewRequest("GET", path, nil)
+	util.CheckErr(err)
+	w := httptest.NewRecorder()
+	s.Engine.ServeHTTP(w, req)
+
+	var result map[string]interface{}
+	util.CheckErr(json.Unmarshal(w.Body.Bytes(), &result))
+	return w.Code, result
+}
+
+func elementNames(resource map[string]interface{}) map[string]bool {
+	names := make(map[string]bool)
+	for name := range resource {
+		names[name] = true
+	}
+	return names
+}
+
+func isSubsetted(resource map[string]interface{}) bool {
+	meta, _ := resource["meta"].(map[string]interface{})
+	tags, _ := meta["tag"].([]interface{})
+	for _, tag := range tags {
+		if tag.(map[string]interface{})["code"] == "SUBSETTED" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SubsetSuite) TestReadWithSummary(c *C) {
+	// The narrative and photo aren't summary elements
+	status, result := s.read("/Patient/123?_summary=true")
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(elementNames(result), DeepEquals, map[string]bool{
+		"resourceType": true, "id": true, "meta": true, "name": true, "gender": true, "birthDate": true,
+	})
+	c.Assert(isSubsetted(result), Equals, true)
+
+	status, result = s.read("/Patient/123?_summary=text")
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(elementNames(result), DeepEquals, map[string]bool{"resourceType": true, "id": true, "meta": true, "text": true})
+	c.Assert(isSubsetted(result), Equals, true)
+
+	status, result = s.read("/Patient/123?_summary=data")
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(result["text"], IsNil)
+	c.Assert(result["photo"], NotNil)
+	c.Assert(isSubsetted(result), Equals, true)
+
+	status, result = s.read("/Patient/123?_summary=false")
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(result["text"], NotNil)
+	c.Assert(result["photo"], NotNil)
+	c.Assert(isSubsetted(result), Equals, false)
+}
+
+func (s *SubsetSuite) TestReadWithElements(c *C) {
+	status, result := s.read("/Patient/123?_elements=gender,photo")
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(elementNames(result), DeepEquals, map[string]bool{
+		"resourceType": true, "id": true, "meta": true, "gender": true, "photo": true,
+	})
+	c.Assert(isSubsetted(result), Equals, true)
+	c.Assert(result["meta"].(map[string]interface{})["versionId"], Equals, "1")
+}
+
+func (s *SubsetSuite) TestReadWithInvalidSummary(c *C) {
+	status, result := s.read("/Patient/123?_summary=maybe")
+	c.Assert(status, Equals, http.StatusBadRequest)
+	c.Assert(result["resourceType"], Equals, "OperationOutcome")
+}
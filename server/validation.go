@@ -0,0 +1,487 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/intervention-engine/fhir/models"
+)
+
+// RequiredElements lists the elements that must be present (i.e., have a minimum cardinality of 1) in FHIR DSTU2
+// resources, data types, and resource components.  Resources and data types are keyed by their type name, while
+// resource components are keyed by their element path (e.g., "Observation.component").  A required choice element is
+// listed using its [x] name (e.g., "effective[x]").  Additional rules may be added before the server is run.
+var RequiredElements = map[string][]string{
+	// Data types
+	"Annotation":  {"text"},
+	"Extension":   {"url"},
+	"Narrative":   {"status", "div"},
+	"SampledData": {"origin", "period", "dimensions", "data"},
+	"Signature":   {"type", "when", "who[x]", "contentType", "blob"},
+
+	// Resources
+	"AllergyIntolerance":               {"patient", "substance"},
+	"AllergyIntolerance.reaction":      {"manifestation"},
+	"Appointment":                      {"status", "participant"},
+	"Appointment.participant":          {"status"},
+	"AppointmentResponse":              {"appointment", "participantStatus"},
+	"AuditEvent":                       {"event", "participant", "source"},
+	"AuditEvent.event":                 {"type", "dateTime"},
+	"AuditEvent.participant":           {"requestor"},
+	"AuditEvent.source":                {"identifier"},
+	"AuditEvent.object.detail":         {"type", "value"},
+	"Basic":                            {"code"},
+	"Binary":                           {"contentType", "content"},
+	"BodySite":                         {"patient"},
+	"Bundle":                           {"type"},
+	"Bundle.link":                      {"relation", "url"},
+	"Bundle.entry.request":             {"method", "url"},
+	"Bundle.entry.response":            {"status"},
+	"CarePlan":                         {"status"},
+	"CarePlan.relatedPlan":             {"plan"},
+	"Claim":                            {"type"},
+	"ClinicalImpression":               {"patient", "status"},
+	"Composition":                      {"date", "type", "title", "status", "subject", "author"},
+	"Composition.attester":             {"mode"},
+	"ConceptMap":                       {"status"},
+	"ConceptMap.element.target":        {"equivalence"},
+	"Condition":                        {"patient", "code", "verificationStatus"},
+	"DataElement":                      {"status", "element"},
+	"Device":                           {"type"},
+	"DeviceComponent":                  {"type", "identifier", "lastSystemChange"},
+	"DeviceMetric":                     {"type", "identifier", "category"},
+	"DeviceUseRequest":                 {"device", "subject"},
+	"DeviceUseStatement":               {"device", "subject"},
+	"DiagnosticOrder":                  {"subject"},
+	"DiagnosticOrder.event":            {"status", "dateTime"},
+	"DiagnosticOrder.item":             {"code"},
+	"DiagnosticReport":                 {"status", "code", "subject", "effective[x]", "issued", "performer"},
+	"DiagnosticReport.image":           {"link"},
+	"DocumentManifest":                 {"status", "content"},
+	"DocumentManifest.content":         {"p[x]"},
+	"DocumentReference":                {"type", "indexed", "status", "content"},
+	"DocumentReference.content":        {"attachment"},
+	"DocumentReference.relatesTo":      {"code", "target"},
+	"Encounter":                        {"status"},
+	"Encounter.statusHistory":          {"status", "period"},
+	"Encounter.location":               {"location"},
+	"EpisodeOfCare":                    {"status", "patient"},
+	"EpisodeOfCare.statusHistory":      {"status", "period"},
+	"FamilyMemberHistory":              {"patient", "status", "relationship"},
+	"FamilyMemberHistory.condition":    {"code"},
+	"Flag":                             {"status", "subject", "code"},
+	"Goal":                             {"description", "status"},
+	"Group":                            {"type", "actual"},
+	"Group.characteristic":             {"code", "value[x]", "exclude"},
+	"Group.member":                     {"entity"},
+	"HealthcareService":                {"location"},
+	"HealthcareService.serviceType":    {"type"},
+	"ImagingStudy":                     {"patient", "uid", "numberOfSeries", "numberOfInstances"},
+	"ImagingStudy.series":              {"uid", "modality", "numberOfInstances"},
+	"ImagingStudy.series.instance":     {"uid", "sopClass"},
+	"Immunization":                     {"status", "vaccineCode", "patient", "wasNotGiven", "reported"},
+	"Immunization.vaccinationProtocol": {"doseSequence", "targetDisease", "doseStatus"},
+	"ImmunizationRecommendation":       {"patient", "recommendation"},
+	"ImmunizationRecommendation.recommendation": {"date", "vaccineCode", "forecastStatus"},
+	"List":                          {"status", "mode"},
+	"List.entry":                    {"item"},
+	"Media":                         {"type", "content"},
+	"MedicationAdministration":      {"status", "patient", "effectiveTime[x]", "medication[x]"},
+	"MedicationDispense":            {"medication[x]"},
+	"MedicationOrder":               {"medication[x]"},
+	"MedicationStatement":           {"patient", "status", "medication[x]"},
+	"MessageHeader":                 {"timestamp", "event", "source"},
+	"MessageHeader.source":          {"endpoint"},
+	"MessageHeader.destination":     {"endpoint"},
+	"MessageHeader.response":        {"identifier", "code"},
+	"NamingSystem":                  {"name", "status", "kind", "date", "uniqueId"},
+	"NamingSystem.uniqueId":         {"type", "value"},
+	"NutritionOrder":                {"patient", "dateTime"},
+	"Observation":                   {"status", "code"},
+	"Observation.related":           {"target"},
+	"Observation.component":         {"code"},
+	"OperationDefinition":           {"name", "status", "kind", "code", "system", "instance"},
+	"OperationDefinition.parameter": {"name", "use", "min", "max"},
+	"OperationOutcome":              {"issue"},
+	"OperationOutcome.issue":        {"severity", "code"},
+	"Patient.communication":         {"language"},
+	"Patient.link":                  {"other", "type"},
+	"Person.link":                   {"target"},
+	"Practitioner.qualification":    {"code"},
+	"Procedure":                     {"subject", "status", "code"},
+	"Procedure.focalDevice":         {"manipulated"},
+	"ProcedureRequest":              {"subject", "code"},
+	"Provenance":                    {"target", "recorded"},
+	"Provenance.agent":              {"role"},
+	"Provenance.entity":             {"role", "type", "reference"},
+	"Questionnaire":                 {"status", "group"},
+	"QuestionnaireResponse":         {"status"},
+	"ReferralRequest":               {"status"},
+	"RelatedPerson":                 {"patient"},
+	"Schedule":                      {"actor"},
+	"SearchParameter":               {"url", "name", "status", "code", "base", "type", "description"},
+	"Slot":                          {"schedule", "freeBusyType", "start", "end"},
+	"Specimen":                      {"subject"},
+	"StructureDefinition":           {"url", "name", "status", "kind", "abstract"},
+	"Subscription":                  {"criteria", "reason", "status", "channel"},
+	"Subscription.channel":          {"type", "payload"},
+	"Substance":                     {"code"},
+	"Substance.ingredient":          {"substance"},
+	"TestScript":                    {"url", "name", "status"},
+	"ValueSet":                      {"status"},
+	"ValueSet.compose.include":      {"system"},
+	"ValueSet.codeSystem":           {"system", "concept"},
+	"ValueSet.codeSystem.concept":   {"code"},
+	"ValueSet.expansion":            {"identifier", "timestamp"},
+}
+
+// uriElements are the names of string elements that are URIs, other than those of choice elements (e.g., valueUri)
+var uriElements = map[string]bool{"url": true, "system": true, "implicitRules": true, "profile": true}
+
+// choiceTypes are the data types a choice element (e.g., value[x]) may have, as they appear in element names
+var choiceTypes = []string{
+	"Base64Binary", "Boolean", "Code", "Date", "DateTime", "Decimal", "Id", "Instant", "Integer", "Markdown", "Oid",
+	"PositiveInt", "String", "Time", "UnsignedInt", "Uri", "Address", "Annotation", "Attachment", "CodeableConcept",
+	"Coding", "ContactPoint", "HumanName", "Identifier", "Meta", "Period", "Quantity", "Range", "Ratio", "Reference",
+	"SampledData", "Signature", "Timing",
+}
+
+var (
+	idPattern       = regexp.MustCompile(`^[A-Za-z0-9\-\.]{1,64}$`)
+	codePattern     = regexp.MustCompile(`^[^\s]+( [^\s]+)*$`)
+	oidPattern      = regexp.MustCompile(`^urn:oid:[0-2](\.[1-9][0-9]*)+$`)
+	dateTimePattern = regexp.MustCompile(`^-?[0-9]{4}(-(0[1-9]|1[0-2])(-(0[1-9]|[12][0-9]|3[01])(T([01][0-9]|2[0-3]):[0-5][0-9]:([0-5][0-9]|60)(\.[0-9]+)?(Z|(\+|-)((0[0-9]|1[0-3]):[0-5][0-9]|14:00)))?)?)?$`)
+	timePattern     = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]:([0-5][0-9]|60)(\.[0-9]+)?$`)
+)
+
+// ValidateResource checks the JSON representation of a resource against the structure of its model and the FHIR
+// rules for required elements, cardinality, primitive formats, and choice elements.  It returns an issue for each
+// problem found, with the issue's location identifying the element in error.  If the resource is valid, no issues
+// are returned.
+func ValidateResource(data []byte) []models.OperationOutcomeIssueComponent {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return []models.OperationOutcomeIssueComponent{{
+			Severity:    "error",
+			Code:        "structure",
+			Diagnostics: fmt.Sprintf("Invalid JSON: %s", err.Error()),
+		}}
+	}
+	return validateDocument(doc)
+}
+
+// validateDocument validates a resource that has already been decoded from JSON into generic maps and slices
+func validateDocument(doc interface{}) []models.OperationOutcomeIssueComponent {
+	v := &validator{}
+	v.validateResource(doc, "")
+	return v.issues
+}
+
+// validationOutcome returns an OperationOutcome containing the issues or, if there are none, an informational issue
+// indicating that the resource is valid
+func validationOutcome(issues []models.OperationOutcomeIssueComponent) *models.OperationOutcome {
+	if len(issues) == 0 {
+		return models.NewOperationOutcome("information", "informational", "The resource is valid")
+	}
+	outcome := models.NewOperationOutcome("error", issues[0].Code, issues[0].Diagnostics)
+	outcome.Issue = issues
+	return outcome
+}
+
+// validator walks a resource's JSON representation alongside the reflected types of its model, collecting issues
+type validator struct {
+	issues []models.OperationOutcomeIssueComponent
+}
+
+func (v *validator) addIssue(code, location, format string, args ...interface{}) {
+	v.issues = append(v.issues, models.OperationOutcomeIssueComponent{
+		Severity:    "error",
+		Code:        code,
+		Diagnostics: fmt.Sprintf(format, args...),
+		Location:    []string{location},
+	})
+}
+
+// validateResource validates a resource, which may be the root of the document or a contained resource
+func (v *validator) validateResource(value interface{}, path string) {
+	object, _ := value.(map[string]interface{})
+	resourceType, _ := object["resourceType"].(string)
+	resourceStruct := models.StructForResourceName(resourceType)
+	if resourceStruct == nil {
+		if path == "" {
+			v.addIssue("structure", path, "The resource must have a known resourceType")
+		} else {
+			v.addIssue("structure", path, "%s must be a resource with a known resourceType", path)
+		}
+		return
+	}
+	if path == "" {
+		path = resourceType
+	}
+	v.validateObject(object, reflect.TypeOf(resourceStruct), resourceType, path)
+}
+
+// validateObject validates a complex element against its struct type.  The definition path (the path without any
+// indexes) identifies the required elements of resource components, while data types and resources are identified
+// by their type name.
+func (v *validator) validateObject(object map[string]interface{}, t reflect.Type, definition, path string) {
+	if !strings.HasSuffix(t.Name(), "Component") {
+		definition = t.Name()
+	}
+	fields := jsonFields(t)
+	choices := choiceElements(fields)
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	present := make(map[string]int)
+	for _, name := range names {
+		fieldType, ok := fields[name]
+		if name == "resourceType" && ok {
+			continue
+		} else if !ok {
+			v.addIssue("structure", path+"."+name, "Unknown element %s in %s", name, path)
+			continue
+		}
+		if choice, ok := choices[name]; ok {
+			present[choice+"[x]"]++
+			if present[choice+"[x]"] == 2 {
+				v.addIssue("structure", path+"."+name, "Only one %s[x] element may be present in %s", choice, path)
+			}
+		}
+		present[name]++
+		v.validateValue(object[name], fieldType, primitiveType(t, name, choices), definition+"."+name, path+"."+name)
+	}
+
+	for _, name := range RequiredElements[definition] {
+		if present[name] == 0 {
+			v.addIssue("required", path+"."+name, "Missing required element %s in %s", name, path)
+		}
+	}
+}
+
+// validateValue validates an element's value against its model type.  The primitive type is the FHIR type of a
+// primitive element stored as a Go string (e.g., "id" or "uri"), if known.
+func (v *validator) validateValue(value interface{}, t reflect.Type, primitive, definition, path string) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if value == nil {
+		v.addIssue("structure", path, "%s must not be null", path)
+		return
+	}
+	values, isArray := value.([]interface{})
+	if t.Kind() == reflect.Slice {
+		if !isArray {
+			v.addIssue("structure", path, "%s must be an array", path)
+			return
+		} else if len(values) == 0 {
+			v.addIssue("structure", path, "%s must not be an empty array", path)
+		}
+		for i := range values {
+			v.validateValue(values[i], t.Elem(), primitive, definition, fmt.Sprintf("%s[%d]", path, i))
+		}
+		return
+	} else if isArray {
+		v.addIssue("structure", path, "%s cannot repeat", path)
+		return
+	}
+
+	switch {
+	case t.Kind() == reflect.Interface:
+		v.validateResource(value, path)
+	case t == fhirDateTimeType:
+		s, ok := value.(string)
+		if !ok || (primitive != "time" && !dateTimePattern.MatchString(s)) || (primitive == "time" && !timePattern.MatchString(s)) {
+			v.addIssue("value", path, "%s must be a valid date or dateTime", path)
+		}
+	case t.Kind() == reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.addIssue("structure", path, "%s must be an object", path)
+			return
+		}
+		v.validateObject(object, t, definition, path)
+	case t.Kind() == reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			v.addIssue("structure", path, "%s must be a string", path)
+		} else if strings.TrimSpace(s) == "" {
+			v.addIssue("value", path, "%s must not be empty", path)
+		} else if !validPrimitive(s, primitive) {
+			v.addIssue("value", path, "%s must be a valid %s", path, primitive)
+		}
+	case t.Kind() == reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			v.addIssue("structure", path, "%s must be a boolean", path)
+		}
+	case t.Kind() == reflect.Float64:
+		if _, ok := number(value); !ok {
+			v.addIssue("value", path, "%s must be a valid decimal", path)
+		}
+	case t.Kind() == reflect.Int32, t.Kind() == reflect.Uint32:
+		n, ok := number(value)
+		switch {
+		case !ok || n != math.Trunc(n) || n > math.MaxInt32 || n < math.MinInt32:
+			v.addIssue("value", path, "%s must be a valid integer", path)
+		case t.Kind() == reflect.Uint32 && (n < 0 || (primitive == "positiveInt" && n == 0)):
+			v.addIssue("value", path, "%s must be a valid %s", path, primitive)
+		}
+	}
+}
+
+// number returns the value of a JSON number, which may have been decoded as a json.Number or a float64
+func number(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case json.Number:
+		n, err := value.Float64()
+		return n, err == nil
+	case float64:
+		return value, true
+	}
+	return 0, false
+}
+
+// validPrimitive checks a string against the format of its FHIR primitive type
+func validPrimitive(s, primitive string) bool {
+	switch primitive {
+	case "id":
+		return idPattern.MatchString(s)
+	case "code":
+		return codePattern.MatchString(s)
+	case "uri":
+		return !strings.ContainsAny(s, " \t\r\n")
+	case "oid":
+		return oidPattern.MatchString(s)
+	case "base64Binary":
+		_, err := base64.StdEncoding.DecodeString(s)
+		return err == nil
+	}
+	return true
+}
+
+// primitiveType returns the FHIR primitive type of a string element, if it is known
+func primitiveType(t reflect.Type, name string, choices map[string]string) string {
+	if choice, ok := choices[name]; ok {
+		dataType := strings.TrimPrefix(name, choice)
+		return strings.ToLower(dataType[:1]) + dataType[1:]
+	}
+	switch {
+	case name == "id", t.Name() == "Meta" && name == "versionId":
+		return "id"
+	case uriElements[name] && t.Name() != "ContactPoint":
+		return "uri"
+	}
+	return ""
+}
+
+// choiceElements identifies the choice elements (e.g., valueString and valueQuantity) among a struct's fields,
+// mapping each element's name to the name of its choice (e.g., "value").  Fields are only considered choices if at
+// least two share the same name with different data type suffixes.
+func choiceElements(fields map[string]reflect.Type) map[string]string {
+	candidates := make(map[string][]string)
+	for name := range fields {
+		for _, dataType := range choiceTypes {
+			if prefix := strings.TrimSuffix(name, dataType); prefix != name && prefix != "" {
+				candidates[prefix] = append(candidates[prefix], name)
+			}
+		}
+	}
+	choices := make(map[string]string)
+	for prefix, names := range candidates {
+		if len(names) > 1 {
+			for _, name := range names {
+				choices[name] = prefix
+			}
+		}
+	}
+	return choices
+}
+
+// newValidateOperation returns the built-in $validate operation, which can be invoked on every resource type and
+// instance.  It is only supported by servers whose config enables it.
+func newValidateOperation() Operation {
+	yes, no := true, false
+	zero, one := int32(0), int32(1)
+	return Operation{
+		Definition: &models.OperationDefinition{
+			Url:         "http://hl7.org/fhir/OperationDefinition/Resource-validate",
+			Name:        "Validate a resource",
+			Status:      "active",
+			Kind:        "operation",
+			Code:        "validate",
+			Description: "Checks a resource's required elements, cardinality, primitive formats, and choice elements, and its conformance to its profiles",
+			Idempotent:  &yes,
+			System:      &no,
+			Type:        []string{"Resource"},
+			Instance:    &yes,
+			Parameter: []models.OperationDefinitionParameterComponent{
+				{Name: "resource", Use: "in", Min: &zero, Max: "1", Type: "Resource"},
+				{Name: "mode", Use: "in", Min: &zero, Max: "1", Type: "code"},
+				{Name: "profile", Use: "in", Min: &zero, Max: "1", Type: "uri"},
+				{Name: "return", Use: "out", Min: &one, Max: "1", Type: "OperationOutcome"},
+			},
+		},
+		Handler: validateOperation,
+	}
+}
+
+// validateOperation handles the $validate operation, returning an OperationOutcome describing any problems with the
+// resource.  The optional mode (create, update, or delete) indicates the interaction the resource is being validated
+// for.  Resources validated for an update must have an ID, matching the instance's ID if one was given.  The resource
+// is also validated against the profiles it claims and the optional profile parameter.
+func validateOperation(r *OperationRequest) (interface{}, error) {
+	mode := ""
+	if p := r.Get("mode"); p != nil {
+		mode = p.ValueCode
+	}
+	switch mode {
+	case "delete":
+		return validationOutcome(nil), nil
+	case "", "create", "update":
+	default:
+		return nil, NewOperationError(http.StatusBadRequest, "Unknown validation mode %s", mode)
+	}
+
+	doc, ok := r.resource("resource").(map[string]interface{})
+	if !ok {
+		return nil, NewOperationError(http.StatusBadRequest, "A resource is required")
+	}
+	issues := validateDocument(doc)
+	if resourceType, _ := doc["resourceType"].(string); models.StructForResourceName(resourceType) != nil && resourceType != r.ResourceType {
+		issues = append(issues, models.OperationOutcomeIssueComponent{
+			Severity:    "error",
+			Code:        "invalid",
+			Diagnostics: fmt.Sprintf("The resource must be a %s", r.ResourceType),
+			Location:    []string{r.ResourceType},
+		})
+	}
+	if id, _ := doc["id"].(string); mode == "update" && (id == "" || (r.ID != "" && id != r.ID)) {
+		issues = append(issues, models.OperationOutcomeIssueComponent{
+			Severity:    "error",
+			Code:        "invalid",
+			Diagnostics: "The resource must have the ID of the resource being updated",
+			Location:    []string{r.ResourceType + ".id"},
+		})
+	}
+	var profiles []string
+	if p := r.Get("profile"); p != nil {
+		profiles = append(profiles, p.ValueUri)
+	}
+	if meta, _ := doc["meta"].(map[string]interface{}); len(profiles) > 0 || meta["profile"] != nil {
+		issues = append(issues, NewProfileValidator(r.DAL).Validate(doc, profiles...)...)
+	}
+	return validationOutcome(issues), nil
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type ValidationSuite struct {
+	Engine *gin.Engine
+}
+
+var _ = Suite(&ValidationSuite{})
+
+func (v *ValidationSuite) SetUpSuite(c *C) {
+	config := DefaultConfig
+	config.ValidateResources = true
+
+	gin.SetMode(gin.ReleaseMode)
+	v.Engine = gin.New()
+	v.Engine.Use(NegotiateFormat)
+	RegisterServerRoutes(v.Engine, make(map[string][]gin.HandlerFunc), nil, config)
+}
+
+func (v *ValidationSuite) post(path, body string) (int, *models.OperationOutcome) {
+	req, err := http.NewRequest("POST", path, strings.NewReader(body))
+	util.CheckErr(err)
+	req.Header.Set("Content-Type", "application/json+fhir")
+	w := httptest.NewRecorder()
+	v.Engine.ServeHTTP(w, req)
+
+	outcome := &models.OperationOutcome{}
+	util.CheckErr(json.Unmarshal(w.Body.Bytes(), outcome))
+	return w.Code, outcome
+}
+
+func (v *ValidationSuite) TestValidResource(c *C) {
+	issues := ValidateResource([]byte(`{
+		"resourceType": "Observation",
+		"id": "obs-1",
+		"status": "final",
+		"code": {"coding": [{"system": "http://loinc.org", "code": "8867-4"}]},
+		"effectiveDateTime": "2016-03-01T10:30:00-05:00",
+		"valueQuantity": {"value": 72, "unit": "beats/min"},
+		"component": [{"code": {"text": "Position"}, "valueString": "Sitting"}]
+	}`))
+	c.Assert(issues, HasLen, 0)
+}
+
+func (v *ValidationSuite) TestInvalidResource(c *C) {
+	issues := ValidateResource([]byte(`{
+		"resourceType": "Observation",
+		"id": "obs 1",
+		"code": {"coding": {"system": "http://loinc.org"}},
+		"effectiveDateTime": "yesterday",
+		"valueString": "72",
+		"valueQuantity": {"value": "72"},
+		"interpretation": [{"text": "Normal"}],
+		"component": [{"valueString": "Sitting"}],
+		"comments": ""
+	}`))
+	locations := make(map[string]string)
+	for _, issue := range issues {
+		c.Assert(issue.Severity, Equals, "error")
+		c.Assert(issue.Location, HasLen, 1)
+		locations[issue.Location[0]] = issue.Code + ": " + issue.Diagnostics
+	}
+	c.Assert(locations, DeepEquals, map[string]string{
+		"Observation.id":                  "value: Observation.id must be a valid id",
+		"Observation.code.coding":         "structure: Observation.code.coding must be an array",
+		"Observation.effectiveDateTime":   "value: Observation.effectiveDateTime must be a valid date or dateTime",
+		"Observation.valueString":         "structure: Only one value[x] element may be present in Observation",
+		"Observation.valueQuantity.value": "value: Observation.valueQuantity.value must be a valid decimal",
+		"Observation.interpretation":      "structure: Observation.interpretation cannot repeat",
+		"Observation.component[0].code":   "required: Missing required element code in Observation.component[0]",
+		"Observation.comments":            "value: Observation.comments must not be empty",
+		"Observation.status":              "required: Missing required element status in Observation",
+	})
+
+	issues = ValidateResource([]byte(`{"resourceType": "Patient", "contained": [{"id": "1"}], "foo": "bar"}`))
+	c.Assert(issues, HasLen, 2)
+	c.Assert(issues[0].Diagnostics, Equals, "Patient.contained[0] must be a resource with a known resourceType")
+	c.Assert(issues[1].Diagnostics, Equals, "Unknown element foo in Patient")
+}
+
+func (v *ValidationSuite) TestValidateOperationCanBeDisabled(c *C) {
+	config := DefaultConfig
+	config.EnableValidateOperation = false
+	engine := gin.New()
+	RegisterServerRoutes(engine, make(map[string][]gin.HandlerFunc), nil, config)
+
+	for _, path := range []string{"/Observation/$validate", "/Observation/123/$validate", "/Observation/123"} {
+		req, err := http.NewRequest("POST", path, strings.NewReader(`{"resourceType": "Observation"}`))
+		util.CheckErr(err)
+		req.Header.Set("Content-Type", "application/json+fhir")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		c.Assert(w.Code, Equals, http.StatusNotFound, Commentf("%s", path))
+	}
+
+	for _, op := range NewMetadataController(engine, config).Conformance().Rest[0].Operation {
+		c.Assert(op.Name, Not(Equals), "validate")
+	}
+}
+
+func (v *ValidationSuite) TestValidateOperation(c *C) {
+	status, outcome := v.post("/Observation/$validate", `{"resourceType": "Observation", "status": "final", "code": {"text": "Pulse"}}`)
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(outcome.Issue, HasLen, 1)
+	c.Assert(outcome.Issue[0].Severity, Equals, "information")
+
+	status, outcome = v.post("/Observation/$validate", `{
+		"resourceType": "Parameters",
+		"parameter": [
+			{"name": "resource", "resource": {"resourceType": "Observation", "code": {"text": "Pulse"}, "valueInteger": 72}},
+			{"name": "mode", "valueCode": "create"}
+		]
+	}`)
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(outcome.Issue, HasLen, 2)
+	c.Assert(outcome.Issue[0].Location, DeepEquals, []string{"Observation.valueInteger"})
+	c.Assert(outcome.Issue[0].Diagnostics, Equals, "Unknown element valueInteger in Observation")
+	c.Assert(outcome.Issue[1].Location, DeepEquals, []string{"Observation.status"})
+
+	// The resource must match the type and, for updates, the instance
+	status, outcome = v.post("/Patient/123/$validate", `{
+		"resourceType": "Parameters",
+		"parameter": [
+			{"name": "resource", "resource": {"resourceType": "Observation", "id": "456", "status": "final", "code": {"text": "Pulse"}}},
+			{"name": "mode", "valueCode": "update"}
+		]
+	}`)
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(outcome.Issue, HasLen, 2)
+	c.Assert(outcome.Issue[0].Diagnostics, Equals, "The resource must be a Patient")
+	c.Assert(outcome.Issue[1].Diagnostics, Equals, "The resource must have the ID of the resource being updated")
+
+	status, outcome = v.post("/Patient/$validate", `{"resourceType": "Parameters"}`)
+	c.Assert(status, Equals, http.StatusBadRequest)
+	c.Assert(outcome.Issue[0].Diagnostics, Equals, "A resource is required")
+}
+
+func (v *ValidationSuite) TestCreateAndUpdateAreValidated(c *C) {
+	invalid := `{"resourceType": "Observation", "status": "final", "valueString": "72"}`
+	status, outcome := v.post("/Observation", invalid)
+	c.Assert(status, Equals, http.StatusBadRequest)
+	c.Assert(outcome.Issue, HasLen, 1)
+	c.Assert(outcome.Issue[0].Code, Equals, "required")
+	c.Assert(outcome.Issue[0].Location, DeepEquals, []string{"Observation.code"})
+
+	req, err := http.NewRequest("PUT", "/Observation/123", strings.NewReader(invalid))
+	util.CheckErr(err)
+	req.Header.Set("Content-Type", "application/json+fhir")
+	w := httptest.NewRecorder()
+	v.Engine.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+}
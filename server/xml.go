@@ -0,0 +1,452 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/intervention-engine/fhir/models"
+)
+
+const (
+	fhirNamespace  = "http://hl7.org/fhir"
+	xhtmlNamespace = "http://www.w3.org/1999/xhtml"
+)
+
+// ConvertJSONToXML converts a FHIR JSON resource to its FHIR XML representation.  Primitive values are represented
+// as value attributes, element ids and extension urls are represented as attributes, narrative is represented as
+// XHTML, and contained resources (such as Bundle entries) are wrapped in an element named for their resource type.
+// Elements are written in the order the models declare them, which is the order FHIR XML requires, regardless of
+// the order of the JSON properties.
+func ConvertJSONToXML(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := decodeOrderedJSON(decoder)
+	if err != nil {
+		return nil, err
+	}
+	resource, ok := value.(jsonObject)
+	if !ok || resource.resourceType() == "" {
+		return nil, errors.New("JSON is not a FHIR resource")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	writeXMLResource(&buf, resource, true)
+	return buf.Bytes(), nil
+}
+
+// ConvertXMLToJSON converts a FHIR XML resource to its FHIR JSON representation.  The models for the resource are
+// used to determine which elements repeat and which primitive values are booleans or numbers.
+func ConvertXMLToJSON(data []byte) ([]byte, error) {
+	d := &xmlDecoder{data: data, decoder: xml.NewDecoder(bytes.NewReader(data))}
+	for {
+		token, err := d.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			resource, err := d.decodeResource(start)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(resource)
+		}
+	}
+}
+
+// jsonObject is a JSON object that preserves the order of its properties, so that properties the models don't define
+// keep their order when written as XML elements.
+type jsonObject []jsonProperty
+
+type jsonProperty struct {
+	Key   string
+	Value interface{}
+}
+
+func (o jsonObject) resourceType() string {
+	for _, p := range o {
+		if p.Key == "resourceType" {
+			if s, ok := p.Value.(string); ok {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// inFieldOrder returns the properties sorted in the order the fields are declared in the model struct type t.  Any
+// properties the model doesn't define follow, in their original order.
+func (o jsonObject) inFieldOrder(t reflect.Type) jsonObject {
+	order := make(map[string]int)
+	for i, f := range modelFields(t) {
+		order[f.name] = i
+	}
+	sorted := make(jsonObject, len(o))
+	copy(sorted, o)
+	sort.Stable(byFieldOrder{sorted, order})
+	return sorted
+}
+
+type byFieldOrder struct {
+	properties jsonObject
+	order      map[string]int
+}
+
+func (b byFieldOrder) Len() int { return len(b.properties) }
+func (b byFieldOrder) Swap(i, j int) {
+	b.properties[i], b.properties[j] = b.properties[j], b.properties[i]
+}
+func (b byFieldOrder) Less(i, j int) bool {
+	return b.index(b.properties[i].Key) < b.index(b.properties[j].Key)
+}
+
+func (b byFieldOrder) index(key string) int {
+	if i, ok := b.order[key]; ok {
+		return i
+	}
+	return len(b.order)
+}
+
+// decodeOrderedJSON decodes the next JSON value, representing objects as jsonObjects, arrays as []interface{}, and
+// primitives as strings, json.Numbers, bools, or nil.
+func decodeOrderedJSON(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		object := jsonObject{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, jsonProperty{Key: key.(string), Value: value})
+		}
+		_, err = decoder.Token()
+		return object, err
+	case json.Delim('['):
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = decoder.Token()
+		return array, err
+	}
+	return token, nil
+}
+
+func writeXMLResource(buf *bytes.Buffer, resource jsonObject, root bool) {
+	resourceType := resource.resourceType()
+	buf.WriteString("<" + resourceType)
+	if root {
+		buf.WriteString(` xmlns="` + fhirNamespace + `"`)
+	}
+	buf.WriteString(">")
+	var t reflect.Type
+	if resourceStruct := models.StructForResourceName(resourceType); resourceStruct != nil {
+		t = reflect.TypeOf(resourceStruct)
+	}
+	writeXMLChildren(buf, resource, resourceType, t, true)
+	buf.WriteString("</" + resourceType + ">")
+}
+
+// writeXMLChildren writes the properties of the object as child elements.  If the object's model struct type t is
+// known, the elements are written in the order the model declares them.
+func writeXMLChildren(buf *bytes.Buffer, object jsonObject, name string, t reflect.Type, isResource bool) {
+	var fields map[string]reflect.Type
+	if t != nil {
+		object = object.inFieldOrder(t)
+		fields = jsonFields(t)
+	}
+	for _, p := range object {
+		if p.Key == "resourceType" || isXMLAttribute(p.Key, name, isResource) {
+			continue
+		}
+		childType := complexElementType(fields[p.Key])
+		if array, ok := p.Value.([]interface{}); ok {
+			for _, item := range array {
+				writeXMLElement(buf, p.Key, item, childType)
+			}
+		} else {
+			writeXMLElement(buf, p.Key, p.Value, childType)
+		}
+	}
+}
+
+// complexElementType returns the model struct type of a complex element, given the type of the model field holding
+// it, or nil if the field isn't a complex element (or is unknown).
+func complexElementType(fieldType reflect.Type) reflect.Type {
+	if fieldType == nil {
+		return nil
+	}
+	if fieldType.Kind() == reflect.Slice {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType.Kind() != reflect.Struct || fieldType == fhirDateTimeType {
+		return nil
+	}
+	return fieldType
+}
+
+func writeXMLElement(buf *bytes.Buffer, name string, value interface{}, t reflect.Type) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case jsonObject:
+		if v.resourceType() != "" {
+			buf.WriteString("<" + name + ">")
+			writeXMLResource(buf, v, false)
+			buf.WriteString("</" + name + ">")
+			return
+		}
+		buf.WriteString("<" + name)
+		for _, p := range v {
+			if isXMLAttribute(p.Key, name, false) {
+				writeXMLAttribute(buf, p.Key, p.Value)
+			}
+		}
+		buf.WriteString(">")
+		writeXMLChildren(buf, v, name, t, false)
+		buf.WriteString("</" + name + ">")
+	case []interface{}:
+		// Nested arrays are not valid FHIR, but write the items rather than losing them
+		for _, item := range v {
+			writeXMLElement(buf, name, item, t)
+		}
+	default:
+		if s, ok := v.(string); ok && name == "div" {
+			writeXHTML(buf, s)
+			return
+		}
+		buf.WriteString("<" + name)
+		writeXMLAttribute(buf, "value", v)
+		buf.WriteString("/>")
+	}
+}
+
+func writeXMLAttribute(buf *bytes.Buffer, name string, value interface{}) {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		return
+	}
+	buf.WriteString(" " + name + `="`)
+	xml.EscapeText(buf, []byte(s))
+	buf.WriteString(`"`)
+}
+
+// writeXHTML writes the narrative div as-is, adding the XHTML namespace if necessary
+func writeXHTML(buf *bytes.Buffer, div string) {
+	div = strings.TrimSpace(div)
+	if strings.HasPrefix(div, "<div") && !strings.Contains(div[:strings.Index(div, ">")+1], "xmlns") {
+		div = `<div xmlns="` + xhtmlNamespace + `"` + div[len("<div"):]
+	}
+	buf.WriteString(div)
+}
+
+// isXMLAttribute determines if a property is represented as an XML attribute rather than an element.  Element ids
+// (but not resource ids) and extension urls are attributes.
+func isXMLAttribute(key, elementName string, isResource bool) bool {
+	switch key {
+	case "id":
+		return !isResource
+	case "url":
+		return elementName == "extension" || elementName == "modifierExtension"
+	}
+	return false
+}
+
+type xmlDecoder struct {
+	data    []byte
+	decoder *xml.Decoder
+}
+
+var fhirDateTimeType = reflect.TypeOf(models.FHIRDateTime{})
+
+// decodeResource decodes the resource represented by the start element, using the element name as the resource type.
+func (d *xmlDecoder) decodeResource(start xml.StartElement) (map[string]interface{}, error) {
+	resourceStruct := models.StructForResourceName(start.Name.Local)
+	if resourceStruct == nil {
+		return nil, fmt.Errorf("Unknown resource type: %s", start.Name.Local)
+	}
+	resource, err := d.decodeElement(start, reflect.TypeOf(resourceStruct))
+	if err != nil {
+		return nil, err
+	}
+	resource["resourceType"] = start.Name.Local
+	return resource, nil
+}
+
+// decodeElement decodes a complex element whose model is the passed in struct type.
+func (d *xmlDecoder) decodeElement(start xml.StartElement, t reflect.Type) (map[string]interface{}, error) {
+	fields := jsonFields(t)
+	element := make(map[string]interface{})
+	for _, attr := range start.Attr {
+		if _, ok := fields[attr.Name.Local]; ok && attr.Name.Space == "" {
+			element[attr.Name.Local] = attr.Value
+		}
+	}
+
+	for {
+		offset := d.decoder.InputOffset()
+		token, err := d.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			name := token.Name.Local
+			fieldType, ok := fields[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown element %s in %s", name, start.Name.Local)
+			}
+			repeats := fieldType.Kind() == reflect.Slice
+			if repeats {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			value, err := d.decodeValue(token, offset, fieldType)
+			if err != nil {
+				return nil, err
+			}
+			if repeats {
+				values, _ := element[name].([]interface{})
+				element[name] = append(values, value)
+			} else {
+				element[name] = value
+			}
+		case xml.EndElement:
+			return element, nil
+		}
+	}
+}
+
+// decodeValue decodes the value of an element whose model is the passed in type.  The offset is the position of the
+// element's start tag in the data, which is needed to extract narrative XHTML as-is.
+func (d *xmlDecoder) decodeValue(start xml.StartElement, offset int64, t reflect.Type) (interface{}, error) {
+	switch {
+	case start.Name.Local == "div" && t.Kind() == reflect.String:
+		if err := d.decoder.Skip(); err != nil {
+			return nil, err
+		}
+		return string(bytes.TrimSpace(d.data[offset:d.decoder.InputOffset()])), nil
+	case t.Kind() == reflect.Interface:
+		// A resource container, such as a contained resource or a Bundle entry's resource
+		var resource map[string]interface{}
+		for {
+			token, err := d.decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch token := token.(type) {
+			case xml.StartElement:
+				if resource != nil {
+					return nil, fmt.Errorf("Element %s must contain only one resource", start.Name.Local)
+				}
+				if resource, err = d.decodeResource(token); err != nil {
+					return nil, err
+				}
+			case xml.EndElement:
+				return resource, nil
+			}
+		}
+	case t == fhirDateTimeType || t.Kind() != reflect.Struct:
+		value := ""
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "value" {
+				value = attr.Value
+			}
+		}
+		// Extensions on primitive values are not supported by the models, so skip any children
+		if err := d.decoder.Skip(); err != nil {
+			return nil, err
+		}
+		return primitiveJSONValue(start.Name.Local, value, t)
+	default:
+		return d.decodeElement(start, t)
+	}
+}
+
+func primitiveJSONValue(name, value string, t reflect.Type) (interface{}, error) {
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid boolean value for %s: %s", name, value)
+		}
+		return b, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("Invalid numeric value for %s: %s", name, value)
+		}
+		return json.Number(value), nil
+	}
+	return value, nil
+}
+
+// jsonFields maps the JSON property names of a model's fields (including those of embedded structs) to their types.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for _, f := range modelFields(t) {
+		fields[f.name] = f.fieldType
+	}
+	return fields
+}
+
+// modelField is a field of a model, identified by its JSON property name
+type modelField struct {
+	name      string
+	fieldType reflect.Type
+}
+
+// modelFields returns the fields of a model (including those of embedded structs) in the order they are declared.
+func modelFields(t reflect.Type) []modelField {
+	var fields []modelField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, modelFields(f.Type)...)
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, modelField{name: name, fieldType: f.Type})
+	}
+	return fields
+}
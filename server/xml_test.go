@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type XMLSuite struct{}
+
+var _ = Suite(&XMLSuite{})
+
+func (x *XMLSuite) TestConvertXMLToJSON(c *C) {
+	data, err := ioutil.ReadFile("../fixtures/condition.xml")
+	util.CheckErr(err)
+	jsonData, err := ConvertXMLToJSON(data)
+	util.CheckErr(err)
+
+	condition := &models.Condition{}
+	err = json.Unmarshal(jsonData, condition)
+	util.CheckErr(err)
+	c.Assert(condition.Id, Equals, "8664777288161060797")
+	c.Assert(condition.VerificationStatus, Equals, "confirmed")
+	c.Assert(condition.Patient.Reference, Equals, "https://example.com/base/Patient/4954037118555241963")
+	c.Assert(condition.Code.Coding, HasLen, 3)
+	c.Assert(condition.Code.MatchesCode("http://hl7.org/fhir/sid/icd-10", "I50.1"), Equals, true)
+	c.Assert(condition.Text.Div, Equals, `<div xmlns="http://www.w3.org/1999/xhtml">Heart failure <b>(confirmed)</b></div>`)
+	c.Assert(condition.OnsetDateTime.Precision, Equals, models.Precision(models.Timestamp))
+}
+
+func (x *XMLSuite) TestConvertJSONToXML(c *C) {
+	active := true
+	patient := &models.Patient{
+		Active: &active,
+		Name:   []models.HumanName{{Family: []string{"Duck"}, Given: []string{"Donald", "D."}}},
+	}
+	patient.Id = "123"
+	patient.Text = &models.Narrative{Status: "generated", Div: "<div>Donald &amp; Daisy</div>"}
+	patient.Extension = []models.Extension{{Url: "http://example.org/color", ValueString: "blue"}}
+	patient.Contained = []interface{}{&models.Organization{Name: "Acme <Healthcare>"}}
+	data, err := json.Marshal(patient)
+	util.CheckErr(err)
+
+	xmlData, err := ConvertJSONToXML(data)
+	util.CheckErr(err)
+	xmlString := string(xmlData)
+	c.Assert(strings.HasPrefix(xmlString, `<?xml version="1.0" encoding="UTF-8"?>`), Equals, true)
+	c.Assert(xmlString, Matches, `(?s).*<Patient xmlns="http://hl7.org/fhir"><id value="123"/>.*`)
+	c.Assert(xmlString, Matches, `(?s).*<div xmlns="http://www.w3.org/1999/xhtml">Donald &amp; Daisy</div>.*`)
+	c.Assert(xmlString, Matches, `(?s).*<contained><Organization><name value="Acme &lt;Healthcare&gt;"/></Organization></contained>.*`)
+	c.Assert(xmlString, Matches, `(?s).*<extension url="http://example.org/color"><valueString value="blue"/></extension>.*`)
+	c.Assert(xmlString, Matches, `(?s).*<active value="true"/>.*`)
+	c.Assert(xmlString, Matches, `(?s).*<family value="Duck"/><given value="Donald"/><given value="D."/>.*`)
+
+	// And back again
+	jsonData, err := ConvertXMLToJSON(xmlData)
+	util.CheckErr(err)
+	roundTripped := &models.Patient{}
+	err = json.Unmarshal(jsonData, roundTripped)
+	util.CheckErr(err)
+	c.Assert(*roundTripped.Active, Equals, true)
+	c.Assert(roundTripped.Name[0].Given, DeepEquals, []string{"Donald", "D."})
+	c.Assert(roundTripped.Extension[0].Url, Equals, "http://example.org/color")
+	c.Assert(roundTripped.Contained, HasLen, 1)
+	c.Assert(roundTripped.Contained[0].(*models.Organization).Name, Equals, "Acme <Healthcare>")
+}
+
+func (x *XMLSuite) TestDisplayedReferenceRoundTrip(c *C) {
+	condition := &models.Condition{
+		Patient:            &models.Reference{Reference: "Patient/1", Display: "Bob"},
+		Code:               &models.CodeableConcept{Text: "Heart failure"},
+		VerificationStatus: "confirmed",
+	}
+	data, err := json.Marshal(condition)
+	util.CheckErr(err)
+
+	xmlData, err := ConvertJSONToXML(data)
+	util.CheckErr(err)
+	c.Assert(string(xmlData), Matches, `(?s).*<patient><reference value="Patient/1"/><display value="Bob"/></patient>.*`)
+
+	jsonData, err := ConvertXMLToJSON(xmlData)
+	util.CheckErr(err)
+	roundTripped := &models.Condition{}
+	err = json.Unmarshal(jsonData, roundTripped)
+	util.CheckErr(err)
+	c.Assert(roundTripped.Patient.Reference, Equals, "Patient/1")
+	c.Assert(roundTripped.Patient.Display, Equals, "Bob")
+	c.Assert(roundTripped.Code.Text, Equals, "Heart failure")
+}
+
+func (x *XMLSuite) TestConvertJSONToXMLUsesModelElementOrder(c *C) {
+	data := []byte(`{"verificationStatus":"confirmed","patient":{"display":"Bob","reference":"Patient/1"},"resourceType":"Condition","id":"1","foo":"bar"}`)
+	xmlData, err := ConvertJSONToXML(data)
+	util.CheckErr(err)
+	c.Assert(string(xmlData), Matches, `(?s).*<Condition xmlns="http://hl7.org/fhir"><id value="1"/><patient><reference value="Patient/1"/><display value="Bob"/></patient><verificationStatus value="confirmed"/><foo value="bar"/></Condition>`)
+}
+
+func (x *XMLSuite) TestBundleRoundTrip(c *C) {
+	data, err := ioutil.ReadFile("../fixtures/john_peters_bundle.json")
+	util.CheckErr(err)
+	expected := &models.Bundle{}
+	err = json.Unmarshal(data, expected)
+	util.CheckErr(err)
+
+	xmlData, err := ConvertJSONToXML(data)
+	util.CheckErr(err)
+	jsonData, err := ConvertXMLToJSON(xmlData)
+	util.CheckErr(err)
+	obtained := &models.Bundle{}
+	err = json.Unmarshal(jsonData, obtained)
+	util.CheckErr(err)
+
+	c.Assert(obtained, DeepEquals, expected)
+}
+
+func (x *XMLSuite) TestConvertInvalidXML(c *C) {
+	_, err := ConvertXMLToJSON([]byte(`<Patient xmlns="http://hl7.org/fhir"><foo value="bar"/></Patient>`))
+	c.Assert(err, ErrorMatches, "Unknown element foo in Patient")
+
+	_, err = ConvertXMLToJSON([]byte(`<Foo xmlns="http://hl7.org/fhir"></Foo>`))
+	c.Assert(err, ErrorMatches, "Unknown resource type: Foo")
+
+	_, err = ConvertXMLToJSON([]byte(`<Patient xmlns="http://hl7.org/fhir"><active value="yes"/></Patient>`))
+	c.Assert(err, ErrorMatches, "Invalid boolean value for active: yes")
+}
@@ -81,6 +81,8 @@ func updateReferences(resource interface{}, refMap map[string]string) error {
 	return nil
 }
 
+// updateReference points a reference to an uploaded resource at its new location.  References may use the "cid:"
+// prefix with the resource's old ID, or be relative references (e.g., Patient/patient-123) using the old ID.
 func updateReference(ref *models.Reference, refMap map[string]string) error {
 	if ref != nil && strings.HasPrefix(ref.Reference, "cid:") {
 		newRef, ok := refMap[strings.TrimPrefix(ref.Reference, "cid:")]
@@ -89,6 +91,14 @@ func updateReference(ref *models.Reference, refMap map[string]string) error {
 		} else {
 			return errors.New(fmt.Sprint("Failed to find updated reference for ", ref))
 		}
+	} else if ref != nil {
+		parts := strings.Split(ref.Reference, "/")
+		if len(parts) != 2 {
+			return nil
+		}
+		if newRef, ok := refMap[parts[1]]; ok && strings.HasPrefix(newRef, parts[0]+"/") {
+			ref.Reference = newRef
+		}
 	}
 
 	return nil
@@ -138,8 +148,9 @@ func sortResourcesByDependency(resources []interface{}) []interface{} {
 
 func references(from interface{}, to interface{}) bool {
 	toID := getId(to)
+	toPath := reflect.TypeOf(to).Elem().Name() + "/" + toID
 	for _, ref := range getAllReferences(from) {
-		if strings.TrimPrefix(ref.Reference, "cid:") == toID {
+		if strings.TrimPrefix(ref.Reference, "cid:") == toID || ref.Reference == toPath {
 			return true
 		}
 	}
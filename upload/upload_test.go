@@ -196,3 +196,34 @@ func isValid(decoder *json.Decoder, model interface{}) bool {
 	}
 	return true
 }
+
+func (s *UploadSuite) TestRelativeReferencesWithFHIRIds(c *C) {
+	// Setup the mock server, which assigns UUIDs
+	var conditionPatient string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.RequestURI, "Patient"):
+			w.Header().Add("Location", "http://localhost/Patient/6f1c0e52-3b7e-4b1a-9d59-0c3b8f0e2a11/_history/1")
+		case strings.Contains(r.RequestURI, "Condition"):
+			condition := &models.Condition{}
+			util.CheckErr(json.NewDecoder(r.Body).Decode(condition))
+			conditionPatient = condition.Patient.Reference
+			w.Header().Add("Location", "http://localhost/Condition/condition.1/_history/1")
+		}
+		fmt.Fprintln(w, "Created")
+	}))
+	defer ts.Close()
+
+	patient := &models.Patient{}
+	patient.Id = "patient-123"
+	condition := &models.Condition{}
+	condition.Id = "condition-456"
+	condition.Patient = &models.Reference{Reference: "Patient/patient-123"}
+
+	refMap, err := UploadResources([]interface{}{condition, patient}, ts.URL)
+	util.CheckErr(err)
+
+	c.Assert(conditionPatient, Equals, "Patient/6f1c0e52-3b7e-4b1a-9d59-0c3b8f0e2a11")
+	c.Assert(refMap["patient-123"], Equals, "Patient/6f1c0e52-3b7e-4b1a-9d59-0c3b8f0e2a11")
+	c.Assert(refMap["condition-456"], Equals, "Condition/condition.1")
+}